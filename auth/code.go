@@ -5,12 +5,17 @@ import (
 	"context"
 	"crypto/sha256"
 	"errors"
+	"fmt"
 	tmplHTML "html/template"
+	"io/fs"
+	"path"
 	"strings"
 	tmplText "text/template"
 	"time"
 
 	"github.com/dchest/uniuri"
+	"github.com/fdelbos/commons/utils"
+	"github.com/go-playground/locales"
 )
 
 type (
@@ -22,10 +27,16 @@ type (
 
 const (
 	Digits                    = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZ"
+	DefaultNbDigits           = 8
 	DefaultDigitsTextTemplate = `Your code is {{.Code}}`
 	DefaultDigitsEmailSubject = "Your code"
 	SaltLenght                = 16
 	DefaultCodeValidity       = 5 * time.Minute
+
+	// file names of the templates of a locale directory, see WithCodeTemplatesFS.
+	CodeSubjectFile = "subject.tmpl"
+	CodeTextFile    = "text.tmpl"
+	CodeHTMLFile    = "html.tmpl"
 )
 
 var (
@@ -50,14 +61,26 @@ type (
 		HTMLTemplate string
 		emailSubject string
 
-		tmplText *tmplText.Template
-		tmplHTML *tmplHTML.Template
+		templatesFS   fs.FS
+		defaultLocale string
+		translators   map[string]locales.Translator
+		templates     map[string]*codeTemplates
+	}
+
+	// codeTemplates are the parsed templates of a locale.
+	codeTemplates struct {
+		subject *tmplText.Template
+		text    *tmplText.Template
+		html    *tmplHTML.Template
 	}
 
 	// CodeTemplateData is the data used to render the code templates.
 	CodeTemplateData struct {
-		Code  string    // the code
-		Until time.Time // the date until the code is valid
+		Code      string    // the code
+		Until     time.Time // the date until the code is valid
+		Locale    string    // the locale used to render the templates
+		UntilDate string    // Until formatted as a long date in the locale (ie: "January 2, 2006")
+		UntilTime string    // Until formatted as a short time in the locale (ie: "3:04 pm")
 	}
 )
 
@@ -67,7 +90,7 @@ func NewCodes(mailer Mailer, codeStore CodeStore, opts ...func(*Codes)) (*Codes,
 	code := &Codes{
 		mailer:   mailer,
 		store:    codeStore,
-		nbDigits: 8,
+		nbDigits: DefaultNbDigits,
 		validity: DefaultCodeValidity,
 	}
 	for _, opt := range opts {
@@ -77,43 +100,138 @@ func NewCodes(mailer Mailer, codeStore CodeStore, opts ...func(*Codes)) (*Codes,
 	if code.textTemplate == "" {
 		code.textTemplate = DefaultDigitsTextTemplate
 	}
+	if code.emailSubject == "" {
+		code.emailSubject = DefaultDigitsEmailSubject
+	}
+	if code.defaultLocale == "" {
+		code.defaultLocale = DefaultLocale
+	}
+	code.defaultLocale = NormalizeLocale(code.defaultLocale)
+
+	tmpls, err := parseCodeTemplates(code.emailSubject, code.textTemplate, code.HTMLTemplate)
+	if err != nil {
+		return nil, err
+	}
+	code.templates = map[string]*codeTemplates{
+		code.defaultLocale: tmpls,
+	}
+
+	if code.templatesFS != nil {
+		if err := code.loadTemplates(code.templatesFS); err != nil {
+			return nil, err
+		}
+	}
+
+	return code, nil
+}
 
+func parseCodeTemplates(subject, text, html string) (*codeTemplates, error) {
 	var err error
-	code.tmplText, err = tmplText.New("code").Parse(code.textTemplate)
+	tmpls := &codeTemplates{}
+
+	tmpls.subject, err = tmplText.New("subject").Parse(subject)
 	if err != nil {
 		return nil, err
 	}
 
-	if code.HTMLTemplate != "" {
-		code.tmplHTML, err = tmplHTML.New("code").Parse(code.HTMLTemplate)
+	tmpls.text, err = tmplText.New("code").Parse(text)
+	if err != nil {
+		return nil, err
+	}
+
+	if html != "" {
+		tmpls.html, err = tmplHTML.New("code").Parse(html)
 		if err != nil {
 			return nil, err
 		}
 	}
+	return tmpls, nil
+}
 
-	if code.emailSubject == "" {
-		code.emailSubject = DefaultDigitsEmailSubject
+// loadTemplates registers a locale for every directory at the root of fsys.
+func (c *Codes) loadTemplates(fsys fs.FS) error {
+	entries, err := fs.ReadDir(fsys, ".")
+	if err != nil {
+		return err
 	}
 
-	return code, nil
+	readFile := func(name string, mandatory bool) (string, error) {
+		raw, err := fs.ReadFile(fsys, name)
+		if err != nil {
+			if !mandatory && errors.Is(err, fs.ErrNotExist) {
+				return "", nil
+			}
+			return "", fmt.Errorf("auth: cant read code template %s: %w", name, err)
+		}
+		return string(raw), nil
+	}
+
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		dir := entry.Name()
+
+		subject, err := readFile(path.Join(dir, CodeSubjectFile), true)
+		if err != nil {
+			return err
+		}
+		text, err := readFile(path.Join(dir, CodeTextFile), true)
+		if err != nil {
+			return err
+		}
+		html, err := readFile(path.Join(dir, CodeHTMLFile), false)
+		if err != nil {
+			return err
+		}
+
+		tmpls, err := parseCodeTemplates(strings.TrimSpace(subject), text, html)
+		if err != nil {
+			return fmt.Errorf("auth: invalid code templates for locale %s: %w", dir, err)
+		}
+		c.templates[NormalizeLocale(dir)] = tmpls
+	}
+	return nil
+}
+
+// translator returns the translator used to format dates for the locale.
+func (c *Codes) translator(locale string) locales.Translator {
+	if _, t := matchLocale(c.translators, locale, ""); t != nil {
+		return t
+	}
+	_, t := matchLocale(defaultTranslators, locale, DefaultLocale)
+	return t
 }
 
 // Send sends a code to the given email.
+// The templates are rendered in the first locale set on ctx with WithLocales (or its
+// language) that has templates, falling back to the default locale.
 func (c *Codes) Send(ctx context.Context, to string) error {
+	locale, tmpls := matchLocales(c.templates, LocalesFromContext(ctx), c.defaultLocale)
+
 	digits, code := c.NewCode(to)
+	trans := c.translator(locale)
 	data := CodeTemplateData{
-		Code:  digits,
-		Until: code.Until,
+		Code:      digits,
+		Until:     code.Until,
+		Locale:    locale,
+		UntilDate: trans.FmtDateLong(code.Until),
+		UntilTime: trans.FmtTimeShort(code.Until),
 	}
+
+	subjectBuff := &bytes.Buffer{}
+	if err := tmpls.subject.Execute(subjectBuff, data); err != nil {
+		return err
+	}
+
 	textBuff := &bytes.Buffer{}
-	if err := c.tmplText.Execute(textBuff, data); err != nil {
+	if err := tmpls.text.Execute(textBuff, data); err != nil {
 		return err
 	}
 
 	htmlBuff := &bytes.Buffer{}
-	if c.tmplHTML != nil {
-		buff := &bytes.Buffer{}
-		if err := c.tmplHTML.Execute(buff, data); err != nil {
+	if tmpls.html != nil {
+		if err := tmpls.html.Execute(htmlBuff, data); err != nil {
 			return err
 		}
 	}
@@ -125,7 +243,7 @@ func (c *Codes) Send(ctx context.Context, to string) error {
 	err := c.mailer.Send(
 		ctx,
 		to,
-		c.emailSubject,
+		subjectBuff.String(),
 		textBuff,
 		htmlBuff)
 	return err
@@ -162,7 +280,7 @@ func WithCodeValidity(validity time.Duration) func(*Codes) {
 	}
 }
 
-// WithCodeTemplates sets the templates used to send the code.
+// WithCodeTemplates sets the templates used to send the code in the default locale.
 // The text template and subject are mandatory.
 func WithCodeTemplates(subject, textTemplate, htmlTemplate string) func(*Codes) {
 	return func(c *Codes) {
@@ -172,9 +290,40 @@ func WithCodeTemplates(subject, textTemplate, htmlTemplate string) func(*Codes)
 	}
 }
 
+// WithCodeTemplatesFS registers localized templates from fsys.
+// Each directory at the root of fsys is a locale (ie: "en", "fr", "pt-BR") containing
+// a subject.tmpl and a text.tmpl file, and optionally an html.tmpl file.
+// A locale found in fsys replaces the templates set with WithCodeTemplates.
+func WithCodeTemplatesFS(fsys fs.FS) func(*Codes) {
+	return func(c *Codes) {
+		c.templatesFS = fsys
+	}
+}
+
+// WithCodeDefaultLocale sets the locale used when the requested one has no templates.
+// Default is "en".
+func WithCodeDefaultLocale(locale string) func(*Codes) {
+	return func(c *Codes) {
+		c.defaultLocale = locale
+	}
+}
+
+// WithCodeTranslators sets the translators used to format the expiry dates,
+// for locales not covered by the builtin ones (en, fr, de, es, it, nl, pt).
+func WithCodeTranslators(translators ...locales.Translator) func(*Codes) {
+	return func(c *Codes) {
+		c.translators = newTranslators(translators...)
+	}
+}
+
+// GenDigest returns the digest of a code sent to email, the email is normalized
+// with utils.NormalizeEmail so the same address always has the same digest.
 func GenDigest(email, digits string) []byte {
-	email = strings.TrimSpace(email)
-	email = strings.ToLower(email)
+	if normalized, err := utils.NormalizeEmail(email); err == nil {
+		email = normalized
+	} else {
+		email = strings.ToLower(strings.TrimSpace(email))
+	}
 
 	digits = strings.TrimSpace(digits)
 	digits = strings.ToUpper(digits)
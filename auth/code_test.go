@@ -3,7 +3,9 @@ package auth_test
 import (
 	"context"
 	"io"
+	"strings"
 	"testing"
+	"testing/fstest"
 	"time"
 
 	. "github.com/fdelbos/commons/auth"
@@ -66,3 +68,83 @@ func TestCode(t *testing.T) {
 	mailer.AssertExpectations(t)
 	codeStore.AssertExpectations(t)
 }
+
+func TestCodeLocales(t *testing.T) {
+	mailer := mocks.NewAuthMailer(t)
+	codeStore := mocks.NewAuthCodeStore(t)
+
+	templates := fstest.MapFS{
+		"fr/subject.tmpl":    {Data: []byte("Votre code\n")},
+		"fr/text.tmpl":       {Data: []byte("{{.Locale}} {{.Code}} {{.UntilDate}}")},
+		"fr/html.tmpl":       {Data: []byte("<p>{{.Code}}</p>")},
+		"pt-BR/subject.tmpl": {Data: []byte("Seu código")},
+		"pt-BR/text.tmpl":    {Data: []byte("{{.Locale}} {{.Code}}")},
+	}
+
+	codes, err := NewCodes(mailer, codeStore, WithCodeTemplatesFS(templates))
+	assert.NoError(t, err)
+
+	codeStore.
+		On("NewCode", mock.Anything, mock.Anything).
+		Return(nil)
+
+	cases := []struct {
+		locales []string
+		subject string
+		prefix  string
+		html    bool
+	}{
+		{[]string{""}, DefaultDigitsEmailSubject, "Your code is ", false},
+		{[]string{"fr"}, "Votre code", "fr ", true},
+		{[]string{"fr-CA"}, "Votre code", "fr ", true},
+		{[]string{"pt_br"}, "Seu código", "pt-br ", false},
+		{[]string{"de"}, DefaultDigitsEmailSubject, "Your code is ", false},
+		{[]string{"de", "pt-BR", "fr"}, "Seu código", "pt-br ", false},
+		{[]string{"it-IT", "fr-CA", "pt-BR"}, "Votre code", "fr ", true},
+	}
+
+	for _, tc := range cases {
+		t.Run(strings.Join(tc.locales, ","), func(t *testing.T) {
+			ctx := WithLocales(context.Background(), tc.locales...)
+
+			mailer.
+				On("Send", ctx, "test@example.com", tc.subject, mock.Anything, mock.Anything).
+				Return(func(ctx context.Context, to, subject string, text, html io.Reader) error {
+					raw, err := io.ReadAll(text)
+					assert.NoError(t, err)
+					assert.True(t, strings.HasPrefix(string(raw), tc.prefix), string(raw))
+
+					raw, err = io.ReadAll(html)
+					assert.NoError(t, err)
+					assert.Equal(t, tc.html, len(raw) > 0)
+					return nil
+				}).
+				Once()
+
+			err := codes.Send(ctx, "test@example.com")
+			assert.NoError(t, err)
+		})
+	}
+
+	mailer.AssertExpectations(t)
+}
+
+func TestCodeTemplatesFSErrors(t *testing.T) {
+	_, err := NewCodes(nil, nil, WithCodeTemplatesFS(fstest.MapFS{
+		"fr/text.tmpl": {Data: []byte("{{.Code}}")},
+	}))
+	assert.Error(t, err)
+
+	_, err = NewCodes(nil, nil, WithCodeTemplatesFS(fstest.MapFS{
+		"fr/subject.tmpl": {Data: []byte("code")},
+		"fr/text.tmpl":    {Data: []byte("{{.Code")},
+	}))
+	assert.Error(t, err)
+}
+
+func TestGenDigestNormalization(t *testing.T) {
+	digest := GenDigest("bob@example.com", "abcd")
+	assert.Equal(t, digest, GenDigest("  Bob@EXAMPLE.com ", "ABCD"))
+	assert.Equal(t, GenDigest("bob@xn--exmple-cua.com", "ABCD"), GenDigest("bob@exämple.com", "ABCD"))
+	assert.NotEqual(t, digest, GenDigest("alice@example.com", "ABCD"))
+}
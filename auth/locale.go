@@ -0,0 +1,116 @@
+package auth
+
+import (
+	"context"
+	"strings"
+
+	"github.com/go-playground/locales"
+	"github.com/go-playground/locales/de"
+	"github.com/go-playground/locales/en"
+	"github.com/go-playground/locales/es"
+	"github.com/go-playground/locales/fr"
+	"github.com/go-playground/locales/it"
+	"github.com/go-playground/locales/nl"
+	"github.com/go-playground/locales/pt"
+)
+
+type (
+	ctxKey string
+)
+
+const (
+	localeCtx ctxKey = "commons/auth/locale"
+
+	DefaultLocale = "en"
+)
+
+// defaultTranslators are the translators used to format dates when no
+// translator was registered for a locale with WithCodeTranslators.
+var defaultTranslators = newTranslators(
+	en.New(),
+	fr.New(),
+	de.New(),
+	es.New(),
+	it.New(),
+	nl.New(),
+	pt.New(),
+)
+
+// WithLocale returns a copy of ctx carrying the given locale (ie: "fr", "en-US").
+func WithLocale(ctx context.Context, locale string) context.Context {
+	return WithLocales(ctx, locale)
+}
+
+// WithLocales returns a copy of ctx carrying the locales preferred by the user,
+// the most preferred first (ie: from an Accept-Language header).
+func WithLocales(ctx context.Context, locales ...string) context.Context {
+	res := make([]string, 0, len(locales))
+	for _, locale := range locales {
+		if locale = NormalizeLocale(locale); locale != "" {
+			res = append(res, locale)
+		}
+	}
+	return context.WithValue(ctx, localeCtx, res)
+}
+
+// LocaleFromContext returns the preferred locale stored in ctx by WithLocale or
+// WithLocales, or an empty string if there is none.
+func LocaleFromContext(ctx context.Context) string {
+	if locales := LocalesFromContext(ctx); len(locales) > 0 {
+		return locales[0]
+	}
+	return ""
+}
+
+// LocalesFromContext returns the locales stored in ctx by WithLocale or WithLocales,
+// the most preferred first.
+func LocalesFromContext(ctx context.Context) []string {
+	locales, _ := ctx.Value(localeCtx).([]string)
+	return locales
+}
+
+// NormalizeLocale lowercases the locale and uses '-' as a separator,
+// so "fr_CA", "fr-CA" and "FR-ca" are all the same locale.
+func NormalizeLocale(locale string) string {
+	locale = strings.TrimSpace(locale)
+	locale = strings.ReplaceAll(locale, "_", "-")
+	return strings.ToLower(locale)
+}
+
+// baseLocale returns the language part of the locale ("fr" for "fr-ca").
+func baseLocale(locale string) string {
+	base, _, _ := strings.Cut(locale, "-")
+	return base
+}
+
+// matchLocale returns the best key of m for locale: the locale itself, then its
+// language, then the fallback.
+func matchLocale[T any](m map[string]T, locale, fallback string) (string, T) {
+	return matchLocales(m, []string{locale}, fallback)
+}
+
+// matchLocales returns the best key of m for the locales, in order of preference:
+// the first locale or language of a locale found in m, then the fallback.
+// So "fr-CA, en" matches "fr" before "en", and "en" when there is no french.
+func matchLocales[T any](m map[string]T, locales []string, fallback string) (string, T) {
+	for _, locale := range locales {
+		locale = NormalizeLocale(locale)
+		if v, ok := m[locale]; ok {
+			return locale, v
+		}
+		if base := baseLocale(locale); base != locale {
+			if v, ok := m[base]; ok {
+				return base, v
+			}
+		}
+	}
+	return fallback, m[fallback]
+}
+
+func newTranslators(translators ...locales.Translator) map[string]locales.Translator {
+	res := make(map[string]locales.Translator, len(translators))
+	for _, t := range translators {
+		res[NormalizeLocale(t.Locale())] = t
+	}
+	return res
+}
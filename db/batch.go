@@ -0,0 +1,66 @@
+package db
+
+type (
+	// BatchKind is the kind of a query queued in a Batch.
+	BatchKind int
+
+	// BatchQuery is a query queued in a Batch.
+	BatchQuery struct {
+		Kind   BatchKind
+		SQL    string
+		Args   []any
+		Dest   any     // the destination of BatchGet and BatchSelect
+		Result *Result // the optional result of BatchExec
+	}
+
+	// Batch queues independent queries to send them in a single round trip with
+	// Query.SendBatch. The queries are executed in order, and the destinations are
+	// only filled once SendBatch returns.
+	Batch struct {
+		queries []BatchQuery
+	}
+)
+
+const (
+	BatchExec BatchKind = iota
+	BatchGet
+	BatchSelect
+)
+
+// NewBatch creates an empty batch.
+func NewBatch() *Batch {
+	return &Batch{}
+}
+
+// Exec queues an INSERT, UPDATE, DELETE, etc, see Query.Exec.
+func (b *Batch) Exec(sql string, args ...any) *Batch {
+	return b.ExecResult(nil, sql, args...)
+}
+
+// ExecResult queues an Exec whose result is stored in res, see Query.ExecResult.
+func (b *Batch) ExecResult(res *Result, sql string, args ...any) *Batch {
+	b.queries = append(b.queries, BatchQuery{Kind: BatchExec, SQL: sql, Args: args, Result: res})
+	return b
+}
+
+// Get queues a single row query scanned into dest, see Query.Get.
+func (b *Batch) Get(dest any, sql string, args ...any) *Batch {
+	b.queries = append(b.queries, BatchQuery{Kind: BatchGet, SQL: sql, Args: args, Dest: dest})
+	return b
+}
+
+// Select queues a multiple rows query scanned into dest, see Query.Select.
+func (b *Batch) Select(dest any, sql string, args ...any) *Batch {
+	b.queries = append(b.queries, BatchQuery{Kind: BatchSelect, SQL: sql, Args: args, Dest: dest})
+	return b
+}
+
+// Len returns the number of queued queries.
+func (b *Batch) Len() int {
+	return len(b.queries)
+}
+
+// Queries returns the queued queries, in order.
+func (b *Batch) Queries() []BatchQuery {
+	return b.queries
+}
@@ -0,0 +1,740 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+type (
+	// Cond is a condition of a WHERE or HAVING clause, see Eq, In, And, Expr...
+	Cond interface {
+		build(w *sqlWriter)
+	}
+
+	// Expression is a raw SQL fragment with "?" placeholders for its arguments ("??" for
+	// a literal "?"). It can be used as a condition or as a value of Set and Values.
+	Expression struct {
+		sql  string
+		args []any
+	}
+
+	// SelectBuilder builds a SELECT query, see Select.
+	SelectBuilder struct {
+		columns   []string
+		from      string
+		joins     []Expression
+		where     []Cond
+		groupBy   []string
+		having    []Cond
+		orderBy   []string
+		limit     int
+		offset    int
+		forUpdate bool
+	}
+
+	// InsertBuilder builds an INSERT query, see Insert.
+	InsertBuilder struct {
+		table      string
+		columns    []string
+		rows       [][]any
+		onConflict string
+		returning  []string
+	}
+
+	// UpdateBuilder builds an UPDATE query, see Update.
+	UpdateBuilder struct {
+		table     string
+		columns   []string
+		values    []any
+		where     []Cond
+		returning []string
+	}
+
+	// DeleteBuilder builds a DELETE query, see Delete.
+	DeleteBuilder struct {
+		table     string
+		where     []Cond
+		returning []string
+	}
+
+	// Builder is implemented by all the query builders. The builders render the
+	// placeholders of the dialect and execute the queries with db.Query.
+	// Build returns ErrInvalidQuery when the query can't be valid SQL, ie: an insert
+	// without values or an expression without an argument for each placeholder.
+	//
+	// The table and column names given to the builders are written as is in the queries,
+	// they must never come from user input. The values are always passed as arguments.
+	Builder interface {
+		Build(dialect Dialect) (string, []any, error)
+	}
+
+	cmp struct {
+		column string
+		op     string
+		value  any
+	}
+
+	in[T any] struct {
+		column string
+		not    bool
+		values []T
+	}
+
+	nullCond struct {
+		column string
+		not    bool
+	}
+
+	junction struct {
+		op    string
+		conds []Cond
+	}
+
+	not struct {
+		cond Cond
+	}
+
+	like struct {
+		column  string
+		pattern string
+		ci      bool
+	}
+
+	sqlWriter struct {
+		strings.Builder
+		dialect Dialect
+		args    []any
+		err     error // the first invalid part of the query
+	}
+)
+
+var (
+	ErrInvalidQuery = errors.New("invalid query")
+)
+
+// Select starts a SELECT query of the given columns, all the columns ("*") when none is given.
+func Select(columns ...string) *SelectBuilder {
+	return &SelectBuilder{columns: columns, limit: -1, offset: -1}
+}
+
+// Insert starts an INSERT query in table.
+func Insert(table string) *InsertBuilder {
+	return &InsertBuilder{table: table}
+}
+
+// Update starts an UPDATE query of table.
+func Update(table string) *UpdateBuilder {
+	return &UpdateBuilder{table: table}
+}
+
+// Delete starts a DELETE query in table.
+func Delete(table string) *DeleteBuilder {
+	return &DeleteBuilder{table: table}
+}
+
+// Expr creates a raw SQL expression, ie: Expr("created_at > ?", since) or Expr("attempts + 1").
+func Expr(sql string, args ...any) Expression {
+	return Expression{sql: sql, args: args}
+}
+
+// Eq is "column = value", or "column IS NULL" when value is nil or a nil pointer.
+func Eq(column string, value any) Cond {
+	if isNull(value) {
+		return IsNull(column)
+	}
+	return cmp{column, "=", value}
+}
+
+// Neq is "column <> value", or "column IS NOT NULL" when value is nil or a nil pointer.
+func Neq(column string, value any) Cond {
+	if isNull(value) {
+		return IsNotNull(column)
+	}
+	return cmp{column, "<>", value}
+}
+
+// isNull returns true if value is nil or a nil pointer (ie: an unset *string field).
+func isNull(value any) bool {
+	if value == nil {
+		return true
+	}
+	v := reflect.ValueOf(value)
+	return v.Kind() == reflect.Pointer && v.IsNil()
+}
+
+// Lt is "column < value".
+func Lt(column string, value any) Cond {
+	return cmp{column, "<", value}
+}
+
+// Lte is "column <= value".
+func Lte(column string, value any) Cond {
+	return cmp{column, "<=", value}
+}
+
+// Gt is "column > value".
+func Gt(column string, value any) Cond {
+	return cmp{column, ">", value}
+}
+
+// Gte is "column >= value".
+func Gte(column string, value any) Cond {
+	return cmp{column, ">=", value}
+}
+
+// In is "column IN (values...)", always false when values is empty.
+func In[T any](column string, values []T) Cond {
+	return in[T]{column: column, values: values}
+}
+
+// NotIn is "column NOT IN (values...)", always true when values is empty.
+func NotIn[T any](column string, values []T) Cond {
+	return in[T]{column: column, not: true, values: values}
+}
+
+// IsNull is "column IS NULL".
+func IsNull(column string) Cond {
+	return nullCond{column: column}
+}
+
+// IsNotNull is "column IS NOT NULL".
+func IsNotNull(column string) Cond {
+	return nullCond{column: column, not: true}
+}
+
+// Like is "column LIKE pattern".
+func Like(column, pattern string) Cond {
+	return like{column: column, pattern: pattern}
+}
+
+// ILike is a case insensitive LIKE: ILIKE on postgres, LIKE on sqlite
+// (which is case insensitive for ASCII characters).
+func ILike(column, pattern string) Cond {
+	return like{column: column, pattern: pattern, ci: true}
+}
+
+// And is true when all the conditions are true.
+func And(conds ...Cond) Cond {
+	return junction{op: " AND ", conds: conds}
+}
+
+// Or is true when one of the conditions is true.
+func Or(conds ...Cond) Cond {
+	return junction{op: " OR ", conds: conds}
+}
+
+// Not negates a condition.
+func Not(cond Cond) Cond {
+	return not{cond: cond}
+}
+
+// From sets the table, or the tables (ie: "users u").
+func (b *SelectBuilder) From(table string) *SelectBuilder {
+	b.from = table
+	return b
+}
+
+// Join adds a join clause, ie: Join("JOIN sessions s ON s.user_id = u.id").
+func (b *SelectBuilder) Join(clause string, args ...any) *SelectBuilder {
+	b.joins = append(b.joins, Expr(clause, args...))
+	return b
+}
+
+// Where adds conditions, all the conditions must be true.
+func (b *SelectBuilder) Where(conds ...Cond) *SelectBuilder {
+	b.where = append(b.where, conds...)
+	return b
+}
+
+// GroupBy adds GROUP BY columns.
+func (b *SelectBuilder) GroupBy(columns ...string) *SelectBuilder {
+	b.groupBy = append(b.groupBy, columns...)
+	return b
+}
+
+// Having adds conditions on the groups.
+func (b *SelectBuilder) Having(conds ...Cond) *SelectBuilder {
+	b.having = append(b.having, conds...)
+	return b
+}
+
+// OrderBy adds ORDER BY terms, ie: OrderBy("created_at DESC", "id").
+func (b *SelectBuilder) OrderBy(terms ...string) *SelectBuilder {
+	b.orderBy = append(b.orderBy, terms...)
+	return b
+}
+
+// Limit sets the maximum number of rows.
+func (b *SelectBuilder) Limit(limit int) *SelectBuilder {
+	b.limit = limit
+	return b
+}
+
+// Offset sets the number of rows to skip.
+func (b *SelectBuilder) Offset(offset int) *SelectBuilder {
+	b.offset = offset
+	return b
+}
+
+// ForUpdate locks the selected rows until the end of the transaction on postgres.
+// It is ignored on sqlite, where a write transaction locks the whole database.
+func (b *SelectBuilder) ForUpdate() *SelectBuilder {
+	b.forUpdate = true
+	return b
+}
+
+// Build returns the SQL and the arguments of the query for dialect.
+func (b *SelectBuilder) Build(dialect Dialect) (string, []any, error) {
+	w := &sqlWriter{dialect: dialect}
+	b.build(w)
+	return w.result()
+}
+
+func (b *SelectBuilder) build(w *sqlWriter) {
+	w.WriteString("SELECT ")
+	if len(b.columns) == 0 {
+		w.WriteString("*")
+	}
+	w.WriteString(strings.Join(b.columns, ", "))
+	if b.from != "" {
+		w.WriteString(" FROM " + b.from)
+	}
+	for _, join := range b.joins {
+		w.WriteString(" ")
+		join.build(w)
+	}
+	w.conds(" WHERE ", b.where)
+	if len(b.groupBy) > 0 {
+		w.WriteString(" GROUP BY " + strings.Join(b.groupBy, ", "))
+	}
+	w.conds(" HAVING ", b.having)
+	if len(b.orderBy) > 0 {
+		w.WriteString(" ORDER BY " + strings.Join(b.orderBy, ", "))
+	}
+	if b.limit >= 0 {
+		w.WriteString(" LIMIT ")
+		w.arg(b.limit)
+	} else if b.offset >= 0 && w.dialect == SQLite {
+		// sqlite requires a LIMIT before an OFFSET
+		w.WriteString(" LIMIT -1")
+	}
+	if b.offset >= 0 {
+		w.WriteString(" OFFSET ")
+		w.arg(b.offset)
+	}
+	if b.forUpdate && w.dialect != SQLite {
+		w.WriteString(" FOR UPDATE")
+	}
+}
+
+// Get scans the first row into dest, see Query.Get.
+func (b *SelectBuilder) Get(ctx context.Context, database DB, dest any) error {
+	return get(ctx, database, b, dest)
+}
+
+// Select scans all the rows into dest, see Query.Select.
+func (b *SelectBuilder) Select(ctx context.Context, database DB, dest any) error {
+	return selectAll(ctx, database, b, dest)
+}
+
+// Each streams the rows into dest, calling fn after each row, see Query.Each.
+func (b *SelectBuilder) Each(ctx context.Context, database DB, dest any, fn func() error) error {
+	sql, args, err := b.Build(DialectOf(database))
+	if err != nil {
+		return err
+	}
+	return database.Query(ctx).Each(dest, sql, args, fn)
+}
+
+// Columns sets the inserted columns.
+func (b *InsertBuilder) Columns(columns ...string) *InsertBuilder {
+	b.columns = columns
+	return b
+}
+
+// Values adds a row, with a value for each column.
+func (b *InsertBuilder) Values(values ...any) *InsertBuilder {
+	b.rows = append(b.rows, values)
+	return b
+}
+
+// OnConflictDoNothing ignores the rows conflicting on the given columns (or on any
+// constraint when no column is given).
+func (b *InsertBuilder) OnConflictDoNothing(columns ...string) *InsertBuilder {
+	b.onConflict = conflictTarget(columns) + "DO NOTHING"
+	return b
+}
+
+// OnConflictUpdate updates the given columns of the rows conflicting on the conflict columns,
+// with the inserted values.
+func (b *InsertBuilder) OnConflictUpdate(conflict []string, columns ...string) *InsertBuilder {
+	sets := make([]string, len(columns))
+	for i, column := range columns {
+		sets[i] = fmt.Sprintf("%s = excluded.%s", column, column)
+	}
+	b.onConflict = conflictTarget(conflict) + "DO UPDATE SET " + strings.Join(sets, ", ")
+	return b
+}
+
+// Returning sets the columns returned by the query, to scan with Get or Select.
+func (b *InsertBuilder) Returning(columns ...string) *InsertBuilder {
+	b.returning = columns
+	return b
+}
+
+// Build returns the SQL and the arguments of the query for dialect.
+// It fails without rows, or when a row hasn't a value for each column.
+func (b *InsertBuilder) Build(dialect Dialect) (string, []any, error) {
+	w := &sqlWriter{dialect: dialect}
+	if len(b.rows) == 0 {
+		w.fail("insert into %s without values", b.table)
+	}
+	for _, row := range b.rows {
+		expected := len(b.columns)
+		if expected == 0 {
+			expected = len(b.rows[0])
+		}
+		if len(row) != expected || len(row) == 0 {
+			w.fail("insert into %s with %d values for %d columns", b.table, len(row), expected)
+		}
+	}
+
+	w.WriteString("INSERT INTO " + b.table)
+	if len(b.columns) > 0 {
+		w.WriteString(" (" + strings.Join(b.columns, ", ") + ")")
+	}
+	w.WriteString(" VALUES ")
+	for i, row := range b.rows {
+		if i > 0 {
+			w.WriteString(", ")
+		}
+		w.WriteString("(")
+		for j, value := range row {
+			if j > 0 {
+				w.WriteString(", ")
+			}
+			w.value(value)
+		}
+		w.WriteString(")")
+	}
+	if b.onConflict != "" {
+		w.WriteString(" ON CONFLICT " + b.onConflict)
+	}
+	w.returning(b.returning)
+	return w.result()
+}
+
+// Exec executes the query, see Query.Exec.
+func (b *InsertBuilder) Exec(ctx context.Context, database DB) error {
+	return exec(ctx, database, b)
+}
+
+// ExecResult executes the query and returns the affected rows, see Query.ExecResult.
+func (b *InsertBuilder) ExecResult(ctx context.Context, database DB) (Result, error) {
+	return execResult(ctx, database, b)
+}
+
+// Get scans the first returned row into dest, see Returning.
+func (b *InsertBuilder) Get(ctx context.Context, database DB, dest any) error {
+	return get(ctx, database, b, dest)
+}
+
+// Select scans all the returned rows into dest, see Returning.
+func (b *InsertBuilder) Select(ctx context.Context, database DB, dest any) error {
+	return selectAll(ctx, database, b, dest)
+}
+
+// Set sets a column to a value, or to an Expression (ie: Set("attempts", Expr("attempts + 1"))).
+func (b *UpdateBuilder) Set(column string, value any) *UpdateBuilder {
+	b.columns = append(b.columns, column)
+	b.values = append(b.values, value)
+	return b
+}
+
+// Where adds conditions, all the conditions must be true.
+func (b *UpdateBuilder) Where(conds ...Cond) *UpdateBuilder {
+	b.where = append(b.where, conds...)
+	return b
+}
+
+// Returning sets the columns returned by the query, to scan with Get or Select.
+func (b *UpdateBuilder) Returning(columns ...string) *UpdateBuilder {
+	b.returning = columns
+	return b
+}
+
+// Build returns the SQL and the arguments of the query for dialect.
+// It fails when no column is set.
+func (b *UpdateBuilder) Build(dialect Dialect) (string, []any, error) {
+	w := &sqlWriter{dialect: dialect}
+	if len(b.columns) == 0 {
+		w.fail("update of %s without set", b.table)
+	}
+
+	w.WriteString("UPDATE " + b.table + " SET ")
+	for i, column := range b.columns {
+		if i > 0 {
+			w.WriteString(", ")
+		}
+		w.WriteString(column + " = ")
+		w.value(b.values[i])
+	}
+	w.conds(" WHERE ", b.where)
+	w.returning(b.returning)
+	return w.result()
+}
+
+// Exec executes the query, see Query.Exec.
+func (b *UpdateBuilder) Exec(ctx context.Context, database DB) error {
+	return exec(ctx, database, b)
+}
+
+// ExecResult executes the query and returns the affected rows, see Query.ExecResult.
+func (b *UpdateBuilder) ExecResult(ctx context.Context, database DB) (Result, error) {
+	return execResult(ctx, database, b)
+}
+
+// Get scans the first returned row into dest, see Returning.
+func (b *UpdateBuilder) Get(ctx context.Context, database DB, dest any) error {
+	return get(ctx, database, b, dest)
+}
+
+// Select scans all the returned rows into dest, see Returning.
+func (b *UpdateBuilder) Select(ctx context.Context, database DB, dest any) error {
+	return selectAll(ctx, database, b, dest)
+}
+
+// Where adds conditions, all the conditions must be true.
+func (b *DeleteBuilder) Where(conds ...Cond) *DeleteBuilder {
+	b.where = append(b.where, conds...)
+	return b
+}
+
+// Returning sets the columns returned by the query, to scan with Get or Select.
+func (b *DeleteBuilder) Returning(columns ...string) *DeleteBuilder {
+	b.returning = columns
+	return b
+}
+
+// Build returns the SQL and the arguments of the query for dialect.
+func (b *DeleteBuilder) Build(dialect Dialect) (string, []any, error) {
+	w := &sqlWriter{dialect: dialect}
+	w.WriteString("DELETE FROM " + b.table)
+	w.conds(" WHERE ", b.where)
+	w.returning(b.returning)
+	return w.result()
+}
+
+// Exec executes the query, see Query.Exec.
+func (b *DeleteBuilder) Exec(ctx context.Context, database DB) error {
+	return exec(ctx, database, b)
+}
+
+// ExecResult executes the query and returns the affected rows, see Query.ExecResult.
+func (b *DeleteBuilder) ExecResult(ctx context.Context, database DB) (Result, error) {
+	return execResult(ctx, database, b)
+}
+
+// Get scans the first returned row into dest, see Returning.
+func (b *DeleteBuilder) Get(ctx context.Context, database DB, dest any) error {
+	return get(ctx, database, b, dest)
+}
+
+// Select scans all the returned rows into dest, see Returning.
+func (b *DeleteBuilder) Select(ctx context.Context, database DB, dest any) error {
+	return selectAll(ctx, database, b, dest)
+}
+
+func exec(ctx context.Context, database DB, b Builder) error {
+	sql, args, err := b.Build(DialectOf(database))
+	if err != nil {
+		return err
+	}
+	return database.Query(ctx).Exec(sql, args...)
+}
+
+func execResult(ctx context.Context, database DB, b Builder) (Result, error) {
+	sql, args, err := b.Build(DialectOf(database))
+	if err != nil {
+		return Result{}, err
+	}
+	return database.Query(ctx).ExecResult(sql, args...)
+}
+
+func get(ctx context.Context, database DB, b Builder, dest any) error {
+	sql, args, err := b.Build(DialectOf(database))
+	if err != nil {
+		return err
+	}
+	return database.Query(ctx).Get(dest, sql, args...)
+}
+
+func selectAll(ctx context.Context, database DB, b Builder, dest any) error {
+	sql, args, err := b.Build(DialectOf(database))
+	if err != nil {
+		return err
+	}
+	return database.Query(ctx).Select(dest, sql, args...)
+}
+
+func conflictTarget(columns []string) string {
+	if len(columns) == 0 {
+		return ""
+	}
+	return "(" + strings.Join(columns, ", ") + ") "
+}
+
+func (e Expression) build(w *sqlWriter) {
+	args := e.args
+	for i := 0; i < len(e.sql); i++ {
+		c := e.sql[i]
+		switch {
+		case c == '?' && i+1 < len(e.sql) && e.sql[i+1] == '?':
+			w.WriteByte('?')
+			i++
+		case c == '?':
+			if len(args) == 0 {
+				w.fail("expression %q has more placeholders than its %d arguments", e.sql, len(e.args))
+				return
+			}
+			w.value(args[0])
+			args = args[1:]
+		default:
+			w.WriteByte(c)
+		}
+	}
+	if len(args) > 0 {
+		w.fail("expression %q has fewer placeholders than its %d arguments", e.sql, len(e.args))
+	}
+}
+
+func (c cmp) build(w *sqlWriter) {
+	w.WriteString(c.column + " " + c.op + " ")
+	w.value(c.value)
+}
+
+func (c in[T]) build(w *sqlWriter) {
+	if len(c.values) == 0 {
+		if c.not {
+			w.WriteString("1 = 1")
+		} else {
+			w.WriteString("1 = 0")
+		}
+		return
+	}
+	w.WriteString(c.column)
+	if c.not {
+		w.WriteString(" NOT")
+	}
+	w.WriteString(" IN (")
+	for i, value := range c.values {
+		if i > 0 {
+			w.WriteString(", ")
+		}
+		w.arg(value)
+	}
+	w.WriteString(")")
+}
+
+func (c nullCond) build(w *sqlWriter) {
+	if c.not {
+		w.WriteString(c.column + " IS NOT NULL")
+	} else {
+		w.WriteString(c.column + " IS NULL")
+	}
+}
+
+func (c like) build(w *sqlWriter) {
+	op := " LIKE "
+	if c.ci && w.dialect != SQLite {
+		op = " ILIKE "
+	}
+	w.WriteString(c.column + op)
+	w.arg(c.pattern)
+}
+
+func (c junction) build(w *sqlWriter) {
+	if len(c.conds) == 0 {
+		// neutral element: true for AND, false for OR
+		if c.op == " AND " {
+			w.WriteString("1 = 1")
+		} else {
+			w.WriteString("1 = 0")
+		}
+		return
+	}
+	w.WriteString("(")
+	for i, cond := range c.conds {
+		if i > 0 {
+			w.WriteString(c.op)
+		}
+		cond.build(w)
+	}
+	w.WriteString(")")
+}
+
+func (c not) build(w *sqlWriter) {
+	w.WriteString("NOT (")
+	c.cond.build(w)
+	w.WriteString(")")
+}
+
+// arg writes the placeholder of a new argument: $N on postgres, ? on sqlite.
+func (w *sqlWriter) arg(value any) {
+	w.args = append(w.args, value)
+	if w.dialect == SQLite {
+		w.WriteString("?")
+	} else {
+		fmt.Fprintf(w, "$%d", len(w.args))
+	}
+}
+
+// value writes an Expression, a sub query or the placeholder of an argument.
+func (w *sqlWriter) value(value any) {
+	switch v := value.(type) {
+	case Expression:
+		v.build(w)
+	case *SelectBuilder:
+		w.WriteString("(")
+		v.build(w)
+		w.WriteString(")")
+	default:
+		w.arg(value)
+	}
+}
+
+func (w *sqlWriter) conds(keyword string, conds []Cond) {
+	if len(conds) == 0 {
+		return
+	}
+	w.WriteString(keyword)
+	for i, cond := range conds {
+		if i > 0 {
+			w.WriteString(" AND ")
+		}
+		cond.build(w)
+	}
+}
+
+// fail records the first reason making the query invalid.
+func (w *sqlWriter) fail(format string, args ...any) {
+	if w.err == nil {
+		w.err = fmt.Errorf("%w: "+format, append([]any{ErrInvalidQuery}, args...)...)
+	}
+}
+
+func (w *sqlWriter) result() (string, []any, error) {
+	if w.err != nil {
+		return "", nil, w.err
+	}
+	return w.String(), w.args, nil
+}
+
+func (w *sqlWriter) returning(columns []string) {
+	if len(columns) > 0 {
+		w.WriteString(" RETURNING " + strings.Join(columns, ", "))
+	}
+}
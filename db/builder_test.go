@@ -0,0 +1,189 @@
+package db_test
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	. "github.com/fdelbos/commons/db"
+	"github.com/fdelbos/commons/db/sqlite"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSelectBuild(t *testing.T) {
+	query := Select("id", "email").
+		From("users u").
+		Join("JOIN teams t ON t.id = u.team_id AND t.active = ?", true).
+		Where(
+			Eq("u.status", "active"),
+			Or(ILike("u.email", "%@example.com"), In("u.id", []int{1, 2})),
+			Not(IsNull("u.verified_at")),
+			Expr("u.created_at > ?", "2023-01-01")).
+		OrderBy("u.created_at DESC", "u.id").
+		Limit(10).
+		Offset(20).
+		ForUpdate()
+
+	sql, args, err := query.Build(Postgres)
+	assert.NoError(t, err)
+	assert.Equal(t, "SELECT id, email FROM users u"+
+		" JOIN teams t ON t.id = u.team_id AND t.active = $1"+
+		" WHERE u.status = $2 AND (u.email ILIKE $3 OR u.id IN ($4, $5)) AND NOT (u.verified_at IS NULL) AND u.created_at > $6"+
+		" ORDER BY u.created_at DESC, u.id LIMIT $7 OFFSET $8 FOR UPDATE", sql)
+	assert.Equal(t, []any{true, "active", "%@example.com", 1, 2, "2023-01-01", 10, 20}, args)
+
+	sql, args, err = query.Build(SQLite)
+	assert.NoError(t, err)
+	assert.Equal(t, "SELECT id, email FROM users u"+
+		" JOIN teams t ON t.id = u.team_id AND t.active = ?"+
+		" WHERE u.status = ? AND (u.email LIKE ? OR u.id IN (?, ?)) AND NOT (u.verified_at IS NULL) AND u.created_at > ?"+
+		" ORDER BY u.created_at DESC, u.id LIMIT ? OFFSET ?", sql)
+	assert.Len(t, args, 8)
+
+	sql, _, err = Select().From("users").Offset(5).Build(SQLite)
+	assert.NoError(t, err)
+	assert.Equal(t, "SELECT * FROM users LIMIT -1 OFFSET ?", sql)
+
+	sql, args, err = Select().From("users").Where(Eq("deleted_at", nil), In("id", []int{}), Expr("data ?? 'key'")).Build(Postgres)
+	assert.NoError(t, err)
+	assert.Equal(t, "SELECT * FROM users WHERE deleted_at IS NULL AND 1 = 0 AND data ? 'key'", sql)
+	assert.Empty(t, args)
+
+	sql, args, err = Select().From("users").Where(In("team_id", []any{}), Eq("id", Select("user_id").From("admins").Where(Eq("level", 2)))).Build(Postgres)
+	assert.NoError(t, err)
+	assert.Equal(t, "SELECT * FROM users WHERE 1 = 0 AND id = (SELECT user_id FROM admins WHERE level = $1)", sql)
+	assert.Equal(t, []any{2}, args)
+
+	var deletedAt *time.Time
+	name := "bob"
+	sql, args, err = Select().From("users").Where(Eq("deleted_at", deletedAt), Neq("parent_id", (*int64)(nil)), Eq("name", &name)).Build(Postgres)
+	assert.NoError(t, err)
+	assert.Equal(t, "SELECT * FROM users WHERE deleted_at IS NULL AND parent_id IS NOT NULL AND name = $1", sql)
+	assert.Equal(t, []any{&name}, args)
+}
+
+func TestWriteBuild(t *testing.T) {
+	sql, args, err := Insert("users").
+		Columns("email", "name").
+		Values("bob@example.com", "Bob").
+		Values("alice@example.com", Expr("upper(?)", "alice")).
+		OnConflictUpdate([]string{"email"}, "name").
+		Returning("id").
+		Build(Postgres)
+	assert.NoError(t, err)
+	assert.Equal(t, "INSERT INTO users (email, name) VALUES ($1, $2), ($3, upper($4))"+
+		" ON CONFLICT (email) DO UPDATE SET name = excluded.name RETURNING id", sql)
+	assert.Equal(t, []any{"bob@example.com", "Bob", "alice@example.com", "alice"}, args)
+
+	sql, _, err = Insert("users").Columns("email").Values("bob@example.com").OnConflictDoNothing().Build(SQLite)
+	assert.NoError(t, err)
+	assert.Equal(t, "INSERT INTO users (email) VALUES (?) ON CONFLICT DO NOTHING", sql)
+
+	sql, args, err = Update("jobs").
+		Set("status", "running").
+		Set("attempts", Expr("attempts + ?", 1)).
+		Where(Eq("id", 42), Lte("run_at", "now")).
+		Returning("*").
+		Build(Postgres)
+	assert.NoError(t, err)
+	assert.Equal(t, "UPDATE jobs SET status = $1, attempts = attempts + $2 WHERE id = $3 AND run_at <= $4 RETURNING *", sql)
+	assert.Equal(t, []any{"running", 1, 42, "now"}, args)
+
+	sql, args, err = Delete("sessions").Where(Lt("expires_at", "now"), NotIn("id", []string{})).Build(SQLite)
+	assert.NoError(t, err)
+	assert.Equal(t, "DELETE FROM sessions WHERE expires_at < ? AND 1 = 1", sql)
+	assert.Equal(t, []any{"now"}, args)
+}
+
+func TestInvalidBuild(t *testing.T) {
+	invalid := map[string]Builder{
+		"insert without values":    Insert("users").Columns("email"),
+		"insert without columns":   Insert("users").Values(),
+		"insert missing value":     Insert("users").Columns("email", "name").Values("bob@example.com"),
+		"insert uneven rows":       Insert("users").Values("bob@example.com").Values("alice@example.com", "Alice"),
+		"update without set":       Update("users").Where(Eq("id", 1)),
+		"missing argument":         Select().From("users").Where(Expr("id = ? OR team_id = ?", 1)),
+		"extra argument":           Select().From("users").Where(Expr("id = 1", 2)),
+		"missing value argument":   Update("jobs").Set("attempts", Expr("attempts + ?")),
+		"invalid sub query":        Select().From("users").Where(Eq("id", Select("user_id").From("admins").Where(Expr("level = ?")))),
+		"invalid join argument":    Select().From("users u").Join("JOIN teams t ON t.id = ?"),
+		"invalid delete condition": Delete("sessions").Where(Expr("expires_at < ?")),
+	}
+	for name, query := range invalid {
+		for _, dialect := range []Dialect{Postgres, SQLite} {
+			sql, args, err := query.Build(dialect)
+			assert.ErrorIs(t, err, ErrInvalidQuery, name)
+			assert.Empty(t, sql, name)
+			assert.Nil(t, args, name)
+		}
+	}
+
+	sql, args, err := Select().From("users").Where(Expr("data ?? ? AND id = ?", "key", 1)).Build(Postgres)
+	assert.NoError(t, err)
+	assert.Equal(t, "SELECT * FROM users WHERE data ? $1 AND id = $2", sql)
+	assert.Equal(t, []any{"key", 1}, args)
+}
+
+type builderRow struct {
+	ID        int       `db:"id"`
+	CreatedAt time.Time `db:"created_at"`
+	Msg       string    `db:"msg"`
+}
+
+func TestBuilderExec(t *testing.T) {
+	dname, err := os.MkdirTemp("", "")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dname)
+
+	conn, err := sqlite.NewConn(fmt.Sprintf("%s/db.sqlite3", dname))
+	assert.NoError(t, err)
+	defer conn.Close()
+
+	ctx := context.Background()
+	err = conn.Query(ctx).Exec("CREATE TABLE the_table (id INTEGER PRIMARY KEY AUTOINCREMENT, created_at TIMESTAMP NOT NULL, msg TEXT NOT NULL)")
+	assert.NoError(t, err)
+
+	now := time.Now().UTC()
+	ids := []int{}
+	err = Insert("the_table").
+		Columns("created_at", "msg").
+		Values(now, "hello").
+		Values(now, "world").
+		Values(now.Add(time.Hour), "later").
+		Returning("id").
+		Select(ctx, conn, &ids)
+	assert.NoError(t, err)
+	assert.Equal(t, []int{1, 2, 3}, ids)
+
+	rows := []builderRow{}
+	err = Select().From("the_table").Where(Lte("created_at", now), ILike("msg", "H%")).Select(ctx, conn, &rows)
+	assert.NoError(t, err)
+	assert.Len(t, rows, 1)
+	assert.Equal(t, "hello", rows[0].Msg)
+
+	assert.NoError(t, Update("the_table").Set("msg", Expr("msg || ?", "!")).Where(In("id", []int{1, 2})).Exec(ctx, conn))
+
+	row := builderRow{}
+	assert.NoError(t, Select().From("the_table").Where(Eq("id", 2)).Get(ctx, conn, &row))
+	assert.Equal(t, "world!", row.Msg)
+
+	deleted := []string{}
+	err = Delete("the_table").Where(Gt("id", 1)).Returning("msg").Select(ctx, conn, &deleted)
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, []string{"world!", "later"}, deleted)
+
+	count := 0
+	assert.NoError(t, Select("count(*)").From("the_table").Get(ctx, conn, &count))
+	assert.Equal(t, 1, count)
+
+	err = Select().From("the_table").Where(Eq("id", 99)).Get(ctx, conn, &row)
+	assert.ErrorIs(t, err, ErrNoRows)
+
+	// the invalid queries are not executed
+	assert.ErrorIs(t, Insert("the_table").Columns("msg").Exec(ctx, conn), ErrInvalidQuery)
+	_, err = Update("the_table").Where(Eq("id", 1)).ExecResult(ctx, conn)
+	assert.ErrorIs(t, err, ErrInvalidQuery)
+	assert.ErrorIs(t, Select().From("the_table").Where(Expr("id = ?")).Get(ctx, conn, &row), ErrInvalidQuery)
+}
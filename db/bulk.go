@@ -0,0 +1,108 @@
+package db
+
+import (
+	"context"
+	"reflect"
+)
+
+type (
+	// RowSource iterates over the rows of a bulk insert, see Query.CopyFrom.
+	// It's compatible with pgx.CopyFromSource.
+	RowSource interface {
+		// Next moves to the next row, it returns false at the end of the rows or on error.
+		Next() bool
+		// Values returns the values of the current row, in the order of the columns.
+		Values() ([]any, error)
+		// Err returns the error that stopped the iteration, if any.
+		Err() error
+	}
+
+	sliceSource struct {
+		rows [][]any
+		i    int
+	}
+
+	funcSource struct {
+		next func() ([]any, error)
+		row  []any
+		err  error
+	}
+)
+
+// RowsOf returns a RowSource iterating over rows.
+func RowsOf(rows [][]any) RowSource {
+	return &sliceSource{rows: rows, i: -1}
+}
+
+// RowFunc returns a RowSource calling next for each row, until it returns a nil row or an error.
+func RowFunc(next func() ([]any, error)) RowSource {
+	return &funcSource{next: next}
+}
+
+// BulkInsert inserts all the rows of T at once, and returns the number of inserted rows.
+// The columns are the ones inserted by InsertRow, the primary key is only inserted
+// when the first row has one. The rows are not updated with the generated values.
+func BulkInsert[T any](ctx context.Context, database DB, rows []T) (int64, error) {
+	table, err := tableOf[T]()
+	if err != nil {
+		return 0, err
+	}
+	if len(rows) == 0 {
+		return 0, nil
+	}
+
+	withPK := !reflect.ValueOf(rows[0]).FieldByIndex(table.pk.index).IsZero()
+	columns := []tableColumn{}
+	names := []string{}
+	for _, c := range table.columns {
+		if c.readonly || (c.pk && !withPK) {
+			continue
+		}
+		columns = append(columns, c)
+		names = append(names, c.name)
+	}
+
+	i := 0
+	source := RowFunc(func() ([]any, error) {
+		if i >= len(rows) {
+			return nil, nil
+		}
+		value := reflect.ValueOf(rows[i])
+		i++
+		values := make([]any, len(columns))
+		for j, c := range columns {
+			values[j] = value.FieldByIndex(c.index).Interface()
+		}
+		return values, nil
+	})
+	return database.Query(ctx).CopyFrom(table.name, names, source)
+}
+
+func (s *sliceSource) Next() bool {
+	s.i++
+	return s.i < len(s.rows)
+}
+
+func (s *sliceSource) Values() ([]any, error) {
+	return s.rows[s.i], nil
+}
+
+func (s *sliceSource) Err() error {
+	return nil
+}
+
+func (s *funcSource) Next() bool {
+	if s.err != nil {
+		return false
+	}
+	s.row, s.err = s.next()
+	return s.err == nil && s.row != nil
+}
+
+func (s *funcSource) Values() ([]any, error) {
+	return s.row, nil
+}
+
+func (s *funcSource) Err() error {
+	return s.err
+}
@@ -7,35 +7,118 @@ import (
 )
 
 type (
-	AdvisoryLockID int
+	// AdvisoryLockID identifies a lock, see LockKey to use a name.
+	AdvisoryLockID int64
+
+	// Dialect is the SQL dialect of a database.
+	Dialect string
 
 	Migrator func(string) error
 
+	primaryCtxKey string
+
+	// Result is the outcome of an ExecResult.
+	Result struct {
+		// RowsAffected is the number of rows inserted, updated or deleted.
+		RowsAffected int64
+		// LastInsertID is the rowid of the last inserted row on sqlite.
+		// It's always 0 on postgres, use RETURNING instead.
+		LastInsertID int64
+	}
+
+	// Query runs queries with the $1, $2... placeholders on both dialects.
+	// sqlite binds the placeholders in their order of first appearance, so they
+	// must first appear in ascending order in the queries.
 	Query interface {
 		// Exec is for INSERT, UPDATE, DELETE, CREATE, etc
 		Exec(sql string, arguments ...any) error
+		// ExecResult is Exec, returning the number of affected rows
+		ExecResult(sql string, arguments ...any) (Result, error)
 		// Select is for multiple row results
 		Select(dest interface{}, sql string, args ...any) error
 		// Get is for single row results
 		Get(dest interface{}, sql string, args ...any) error
+		// Each streams the rows of large results: every row is scanned into dest,
+		// then fn is called. Iteration stops at the first error returned by fn,
+		// return ErrStop to stop without error.
+		Each(dest interface{}, sql string, args []any, fn func() error) error
+		// CopyFrom inserts many rows at once and returns the number of inserted rows.
+		// It uses COPY on postgres, and batched INSERTs in a transaction on sqlite.
+		CopyFrom(table string, columns []string, rows RowSource) (int64, error)
+		// SendBatch executes all the queries of the batch, in a single round trip on postgres
+		// and sequentially on sqlite. It returns the first error.
+		SendBatch(batch *Batch) error
 	}
 
 	DB interface {
 		Query(ctx context.Context) Query
-		Tx(ctx context.Context, fn func(ctx context.Context) error) error
-		Lock(ctx context.Context, lockID AdvisoryLockID, fn func(ctx context.Context) error) error
+		// Tx runs fn in a transaction, committed when fn returns no error.
+		// A Tx in a transaction is nested, see TxOptions.
+		Tx(ctx context.Context, fn func(ctx context.Context) error, opts ...func(*TxOptions)) error
+		// Lock runs fn holding an advisory lock, or returns ErrLockFailed when another
+		// holder has it. By default fn runs in a transaction holding an exclusive lock,
+		// see LockOptions.
+		Lock(ctx context.Context, lockID AdvisoryLockID, fn func(ctx context.Context) error, opts ...func(*LockOptions)) error
 	}
 )
 
+const (
+	Postgres Dialect = "pg"
+	SQLite   Dialect = "sqlite"
+
+	primaryCtx primaryCtxKey = "db/primary/ctx"
+)
+
 var (
 	ErrNoRows     = errors.New("no rows in result set")
 	ErrLockFailed = errors.New("lock failed")
+
+	// ErrNotAffected is returned by Affected when no row was changed,
+	// ie: an optimistic update whose version didn't match.
+	ErrNotAffected = errors.New("no rows affected")
+
+	// ErrStop is returned by an Each callback to stop the iteration early.
+	ErrStop = errors.New("stop iteration")
 )
 
 func IsErrNoRows(err error) bool {
 	return err == ErrNoRows
 }
 
+// Affected returns ErrNotAffected when an ExecResult succeeded without changing any row:
+//
+//	err := db.Affected(q.ExecResult("UPDATE items SET version = version + 1 WHERE id = $1 AND version = $2", id, version))
+func Affected(res Result, err error) error {
+	if err != nil {
+		return err
+	}
+	if res.RowsAffected == 0 {
+		return ErrNotAffected
+	}
+	return nil
+}
+
+// DialectOf returns the dialect of the database.
+// Postgres is returned when the database doesn't implement a Dialect() method.
+func DialectOf(database DB) Dialect {
+	if d, ok := database.(interface{ Dialect() Dialect }); ok {
+		return d.Dialect()
+	}
+	return Postgres
+}
+
+// WithPrimary returns a context whose reads go to the primary database when the
+// reads are routed to replicas, ie: to read your own writes.
+func WithPrimary(ctx context.Context) context.Context {
+	return context.WithValue(ctx, primaryCtx, true)
+}
+
+// UsePrimary returns true when the reads of ctx must go to the primary database.
+func UsePrimary(ctx context.Context) bool {
+	primary, _ := ctx.Value(primaryCtx).(bool)
+	return primary
+}
+
 func ReplaceDBInURL(originalURL, newDb string) (string, error) {
 	url, err := url.Parse(originalURL)
 	if err != nil {
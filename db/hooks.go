@@ -0,0 +1,92 @@
+package db
+
+import (
+	"context"
+	"sync"
+)
+
+type (
+	// TxHooks are the callbacks registered on a transaction with AfterCommit and AfterRollback.
+	// The backends create them with WithTxHooks when a transaction begins.
+	TxHooks struct {
+		mu            sync.Mutex
+		parent        *TxHooks
+		afterCommit   []func(ctx context.Context)
+		afterRollback []func(ctx context.Context)
+	}
+
+	hooksCtxKey string
+)
+
+const (
+	hooksCtx hooksCtxKey = "db/hooks/ctx"
+)
+
+// WithTxHooks returns a context carrying new hooks for a transaction beginning.
+// In a nested transaction the hooks are nested in the hooks of the outer transaction.
+func WithTxHooks(ctx context.Context) (context.Context, *TxHooks) {
+	parent, _ := ctx.Value(hooksCtx).(*TxHooks)
+	hooks := &TxHooks{parent: parent}
+	return context.WithValue(ctx, hooksCtx, hooks), hooks
+}
+
+// AfterCommit registers fn to run once the transaction of ctx is committed,
+// after the commit of the outermost transaction for a nested transaction.
+// fn runs immediately when ctx is not in a transaction.
+func AfterCommit(ctx context.Context, fn func(ctx context.Context)) {
+	hooks, ok := ctx.Value(hooksCtx).(*TxHooks)
+	if !ok {
+		fn(ctx)
+		return
+	}
+	hooks.mu.Lock()
+	defer hooks.mu.Unlock()
+	hooks.afterCommit = append(hooks.afterCommit, fn)
+}
+
+// AfterRollback registers fn to run once the transaction of ctx is rolled back,
+// or once a nested transaction is rolled back to its savepoint.
+// fn never runs when ctx is not in a transaction.
+func AfterRollback(ctx context.Context, fn func(ctx context.Context)) {
+	hooks, ok := ctx.Value(hooksCtx).(*TxHooks)
+	if !ok {
+		return
+	}
+	hooks.mu.Lock()
+	defer hooks.mu.Unlock()
+	hooks.afterRollback = append(hooks.afterRollback, fn)
+}
+
+// Committed is called by the backends after the transaction is committed,
+// ctx is the context given to the transaction. The hooks of a nested
+// transaction are moved to the outer transaction.
+func (h *TxHooks) Committed(ctx context.Context) {
+	h.mu.Lock()
+	afterCommit, afterRollback := h.afterCommit, h.afterRollback
+	h.afterCommit, h.afterRollback = nil, nil
+	h.mu.Unlock()
+
+	if h.parent != nil {
+		h.parent.mu.Lock()
+		defer h.parent.mu.Unlock()
+		h.parent.afterCommit = append(h.parent.afterCommit, afterCommit...)
+		h.parent.afterRollback = append(h.parent.afterRollback, afterRollback...)
+		return
+	}
+	for _, fn := range afterCommit {
+		fn(ctx)
+	}
+}
+
+// RolledBack is called by the backends after the transaction is rolled back,
+// ctx is the context given to the transaction.
+func (h *TxHooks) RolledBack(ctx context.Context) {
+	h.mu.Lock()
+	afterRollback := h.afterRollback
+	h.afterCommit, h.afterRollback = nil, nil
+	h.mu.Unlock()
+
+	for _, fn := range afterRollback {
+		fn(ctx)
+	}
+}
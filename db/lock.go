@@ -0,0 +1,57 @@
+package db
+
+import (
+	"hash/fnv"
+)
+
+type (
+	// LockOptions are the options of DB.Lock.
+	LockOptions struct {
+		// Wait blocks until the lock is acquired or the context is done,
+		// instead of failing with ErrLockFailed when the lock is held.
+		Wait bool
+		// Shared locks can be held by many holders at once, but not with an exclusive lock.
+		Shared bool
+		// Session holds the lock for the whole function instead of a transaction,
+		// so the function can run many transactions.
+		Session bool
+	}
+)
+
+// NewLockOptions applies the options to the default LockOptions.
+func NewLockOptions(opts ...func(*LockOptions)) LockOptions {
+	options := LockOptions{}
+	for _, opt := range opts {
+		opt(&options)
+	}
+	return options
+}
+
+// LockKey returns the lock id of a name, ie: db.LockKey("billing/invoices").
+func LockKey(name string) AdvisoryLockID {
+	h := fnv.New64a()
+	h.Write([]byte(name))
+	return AdvisoryLockID(h.Sum64())
+}
+
+// WithLockWait waits for the lock until the context is done. When the context
+// is done first the error wraps both ErrLockFailed and the context error.
+func WithLockWait() func(*LockOptions) {
+	return func(o *LockOptions) {
+		o.Wait = true
+	}
+}
+
+// WithSharedLock takes a shared lock instead of an exclusive lock.
+func WithSharedLock() func(*LockOptions) {
+	return func(o *LockOptions) {
+		o.Shared = true
+	}
+}
+
+// WithSessionLock holds the lock out of a transaction, until the function returns.
+func WithSessionLock() func(*LockOptions) {
+	return func(o *LockOptions) {
+		o.Session = true
+	}
+}
@@ -131,6 +131,7 @@ func newPool(ctx context.Context, url string) (*pgxpool.Pool, error) {
 
 	if err := pool.Ping(context.Background()); err != nil {
 		log.Printf("db/pg Unable to ping database: %v", err)
+		pool.Close()
 		return nil, err
 	}
 
@@ -158,6 +159,7 @@ func newConn(ctx context.Context, url string) (*pgx.Conn, error) {
 
 	if err := conn.Ping(context.Background()); err != nil {
 		log.Printf("db/pg Unable to ping database: %v", err)
+		conn.Close(context.Background())
 		return nil, err
 	}
 
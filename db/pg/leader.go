@@ -0,0 +1,177 @@
+package pg
+
+import (
+	"context"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/fdelbos/commons/db"
+	"github.com/fdelbos/commons/utils"
+	"github.com/jackc/pgx/v5"
+)
+
+type (
+	// LeaderElector elects a single leader among the instances sharing a lock id,
+	// the leader is the instance holding a session advisory lock on a dedicated connection.
+	//
+	// The connection is checked every interval: when it's lost the instance steps down
+	// and campaigns again once reconnected. Since postgres releases the lock as soon as
+	// the connection is lost, two instances can briefly both see themselves as leader,
+	// for at most the check interval.
+	LeaderElector struct {
+		url        string
+		lockID     db.AdvisoryLockID
+		leader     atomic.Bool
+		mu         sync.Mutex
+		onChange   []func(ctx context.Context, leader bool)
+		interval   time.Duration
+		maxBackoff time.Duration
+	}
+)
+
+const (
+	DefaultLeaderInterval   = time.Second
+	DefaultLeaderMaxBackoff = 30 * time.Second
+)
+
+// NewLeaderElector creates an elector campaigning for lockID on the database url.
+func NewLeaderElector(url string, lockID db.AdvisoryLockID, opts ...func(*LeaderElector)) *LeaderElector {
+	e := &LeaderElector{
+		url:        url,
+		lockID:     lockID,
+		interval:   DefaultLeaderInterval,
+		maxBackoff: DefaultLeaderMaxBackoff,
+	}
+	for _, opt := range opts {
+		opt(e)
+	}
+	return e
+}
+
+// IsLeader returns true while this instance is the leader.
+func (e *LeaderElector) IsLeader() bool {
+	return e.leader.Load()
+}
+
+// OnChange adds a function called when this instance is elected or steps down,
+// it can be called before or while the elector runs. The functions run in the
+// elector goroutine, so they must be fast.
+func (e *LeaderElector) OnChange(fn func(ctx context.Context, leader bool)) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.onChange = append(e.onChange, fn)
+}
+
+// Cron runs fn every interval until ctx is canceled, only when this instance is the leader.
+func (e *LeaderElector) Cron(ctx context.Context, interval time.Duration, fn func()) {
+	utils.Cron(ctx, interval, func() {
+		if e.IsLeader() {
+			fn()
+		}
+	})
+}
+
+// Run campaigns until ctx is canceled, then releases the leadership.
+func (e *LeaderElector) Run(ctx context.Context) {
+	for attempt := 0; ; attempt++ {
+		connected, err := e.campaign(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+		if connected {
+			attempt = 0
+		}
+
+		delay := utils.Backoff(attempt+1, e.interval, e.maxBackoff)
+		log.Printf("db/pg leader elector disconnected, reconnecting in %s: %v", delay, err)
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+	}
+}
+
+// campaign connects and tries to take the lock every interval, then checks the
+// connection every interval while leading, until an error occurs.
+// It returns true when the connection succeeded.
+func (e *LeaderElector) campaign(ctx context.Context) (bool, error) {
+	conn, err := newConn(ctx, e.url)
+	if err != nil {
+		return false, err
+	}
+	defer conn.Close(context.Background())
+	defer e.setLeader(ctx, false)
+
+	ticker := time.NewTicker(e.interval)
+	defer ticker.Stop()
+	for {
+		// a hung connection is detected like a lost one
+		checkCtx, cancel := context.WithTimeout(ctx, e.interval)
+		elected := true
+		if e.IsLeader() {
+			err = conn.Ping(checkCtx)
+		} else {
+			elected, err = e.elect(checkCtx, conn)
+		}
+		cancel()
+		if err != nil {
+			return true, err
+		}
+		e.setLeader(ctx, elected)
+
+		select {
+		case <-ctx.Done():
+			if e.IsLeader() {
+				// the lock is released even if ctx is canceled
+				if _, err := conn.Exec(context.Background(), lockQuery("pg_advisory_unlock", db.LockOptions{}), int64(e.lockID)); err != nil {
+					log.Printf("db/pg error while releasing the leadership %d: %v", e.lockID, err)
+				}
+			}
+			return true, ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// elect tries to take the lock, without waiting.
+func (e *LeaderElector) elect(ctx context.Context, conn *pgx.Conn) (bool, error) {
+	err := acquire(ctx, conn, e.lockID, "", db.LockOptions{})
+	if err == db.ErrLockFailed {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (e *LeaderElector) setLeader(ctx context.Context, leader bool) {
+	if e.leader.Swap(leader) == leader {
+		return
+	}
+
+	e.mu.Lock()
+	onChange := append([]func(context.Context, bool){}, e.onChange...)
+	e.mu.Unlock()
+
+	for _, fn := range onChange {
+		fn(ctx, leader)
+	}
+}
+
+// WithLeaderInterval sets the delay between the campaigns and the connection checks,
+// it's also the first reconnection delay. Default is 1 second.
+func WithLeaderInterval(interval time.Duration) func(*LeaderElector) {
+	return func(e *LeaderElector) {
+		e.interval = interval
+	}
+}
+
+// WithLeaderMaxBackoff sets the maximum delay between the reconnections. Default is 30 seconds.
+func WithLeaderMaxBackoff(maxBackoff time.Duration) func(*LeaderElector) {
+	return func(e *LeaderElector) {
+		e.maxBackoff = maxBackoff
+	}
+}
@@ -0,0 +1,64 @@
+package pg
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/fdelbos/commons/db"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLeaderElector(t *testing.T) {
+	pool := newTestPool(t)
+	key := db.LockKey("pg/leader_test")
+	opts := []func(*LeaderElector){WithLeaderInterval(20 * time.Millisecond), WithLeaderMaxBackoff(100 * time.Millisecond)}
+
+	first := NewLeaderElector(namedURL(t, "leader_test_first"), key, opts...)
+	second := NewLeaderElector(namedURL(t, "leader_test_second"), key, opts...)
+	changes := make(chan bool, 10)
+	second.OnChange(func(ctx context.Context, leader bool) {
+		changes <- leader
+	})
+
+	run := func(e *LeaderElector) (context.CancelFunc, chan struct{}) {
+		ctx, cancel := context.WithCancel(context.Background())
+		done := make(chan struct{})
+		go func() {
+			e.Run(ctx)
+			close(done)
+		}()
+		t.Cleanup(func() {
+			cancel()
+			<-done
+		})
+		return cancel, done
+	}
+	waitChange := func(leader bool) {
+		select {
+		case got := <-changes:
+			assert.Equal(t, leader, got)
+		case <-time.After(5 * time.Second):
+			t.Fatalf("the elector didn't change to leader=%t", leader)
+		}
+	}
+
+	stopFirst, firstDone := run(first)
+	assert.Eventually(t, first.IsLeader, 5*time.Second, 10*time.Millisecond)
+	run(second)
+	time.Sleep(100 * time.Millisecond)
+	assert.False(t, second.IsLeader())
+
+	// the leadership is handed off when the leader stops
+	stopFirst()
+	<-firstDone
+	assert.False(t, first.IsLeader())
+	waitChange(true)
+	assert.True(t, second.IsLeader())
+
+	// the leader steps down when its connection is lost, and campaigns again once reconnected
+	terminate(t, pool, "leader_test_second")
+	waitChange(false)
+	waitChange(true)
+	assert.True(t, second.IsLeader())
+}
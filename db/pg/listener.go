@@ -0,0 +1,191 @@
+package pg
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/fdelbos/commons/db"
+	"github.com/fdelbos/commons/utils"
+	"github.com/jackc/pgx/v5"
+)
+
+type (
+	// NotificationHandler receives the payloads notified on a channel.
+	NotificationHandler func(ctx context.Context, payload string)
+
+	// Listener receives the notifications of postgres channels (LISTEN/NOTIFY) on a
+	// dedicated connection, and dispatches the payloads to the handlers of the channel.
+	// The handlers run one at a time, in the listener goroutine, so they must be fast.
+	//
+	// The listener reconnects when the connection is lost, the notifications sent
+	// while it's disconnected are lost, see WithListenerReconnect.
+	Listener struct {
+		url         string
+		mu          sync.Mutex
+		handlers    map[string][]NotificationHandler
+		changed     chan struct{}
+		onReconnect func(ctx context.Context)
+		minBackoff  time.Duration
+		maxBackoff  time.Duration
+	}
+)
+
+const (
+	DefaultListenerMinBackoff = 100 * time.Millisecond
+	DefaultListenerMaxBackoff = 30 * time.Second
+
+	notify = "SELECT pg_notify($1, $2)"
+)
+
+// NewListener creates a listener connecting to the database url.
+func NewListener(url string, opts ...func(*Listener)) *Listener {
+	l := &Listener{
+		url:        url,
+		handlers:   map[string][]NotificationHandler{},
+		changed:    make(chan struct{}, 1),
+		minBackoff: DefaultListenerMinBackoff,
+		maxBackoff: DefaultListenerMaxBackoff,
+	}
+	for _, opt := range opts {
+		opt(l)
+	}
+	return l
+}
+
+// Notify sends a notification on a channel. In a transaction the notification
+// is only sent when the transaction commits.
+func Notify(ctx context.Context, database db.DB, channel, payload string) error {
+	return database.Query(ctx).Exec(notify, channel, payload)
+}
+
+// Listen adds a handler of the notifications of a channel,
+// it can be called before or while the listener runs.
+func (l *Listener) Listen(channel string, fn NotificationHandler) {
+	l.mu.Lock()
+	l.handlers[channel] = append(l.handlers[channel], fn)
+	l.mu.Unlock()
+
+	// wake up the listener to subscribe to the channel
+	select {
+	case l.changed <- struct{}{}:
+	default:
+	}
+}
+
+// Run listens to the channels until ctx is canceled.
+func (l *Listener) Run(ctx context.Context) {
+	for attempt := 0; ; attempt++ {
+		connected, err := l.listen(ctx, attempt > 0)
+		if ctx.Err() != nil {
+			return
+		}
+		if connected {
+			attempt = 0
+		}
+
+		delay := utils.Backoff(attempt+1, l.minBackoff, l.maxBackoff)
+		log.Printf("db/pg listener disconnected, reconnecting in %s: %v", delay, err)
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+	}
+}
+
+// listen connects, subscribes to the channels and dispatches the notifications until an error occurs.
+// It returns true when the connection succeeded.
+func (l *Listener) listen(ctx context.Context, reconnect bool) (bool, error) {
+	conn, err := newConn(ctx, l.url)
+	if err != nil {
+		return false, err
+	}
+	defer conn.Close(context.Background())
+
+	subscribed := map[string]bool{}
+	subscribe := func() error {
+		for _, channel := range l.channels() {
+			if subscribed[channel] {
+				continue
+			}
+			if _, err := conn.Exec(ctx, "LISTEN "+pgx.Identifier{channel}.Sanitize()); err != nil {
+				return err
+			}
+			subscribed[channel] = true
+		}
+		return nil
+	}
+	if err := subscribe(); err != nil {
+		return true, err
+	}
+	if reconnect && l.onReconnect != nil {
+		l.onReconnect(ctx)
+	}
+
+	for {
+		// the wait is interrupted when a channel is added
+		waitCtx, cancel := context.WithCancel(ctx)
+		go func() {
+			select {
+			case <-l.changed:
+				cancel()
+			case <-waitCtx.Done():
+			}
+		}()
+		notification, err := conn.WaitForNotification(waitCtx)
+		cancel()
+
+		if err == nil {
+			l.dispatch(ctx, notification.Channel, notification.Payload)
+		} else if ctx.Err() != nil {
+			return true, ctx.Err()
+		} else if !errors.Is(err, context.Canceled) {
+			return true, err
+		}
+		if err := subscribe(); err != nil {
+			return true, err
+		}
+	}
+}
+
+func (l *Listener) channels() []string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	res := make([]string, 0, len(l.handlers))
+	for channel := range l.handlers {
+		res = append(res, channel)
+	}
+	return res
+}
+
+func (l *Listener) dispatch(ctx context.Context, channel, payload string) {
+	l.mu.Lock()
+	handlers := append([]NotificationHandler{}, l.handlers[channel]...)
+	l.mu.Unlock()
+
+	for _, fn := range handlers {
+		fn(ctx, payload)
+	}
+}
+
+// WithListenerReconnect sets a function called after a reconnection, ie: to clear a
+// cache since the notifications sent while disconnected are lost.
+func WithListenerReconnect(fn func(ctx context.Context)) func(*Listener) {
+	return func(l *Listener) {
+		l.onReconnect = fn
+	}
+}
+
+// WithListenerBackoff sets the backoff between the reconnections.
+// Defaults are from 100 milliseconds to 30 seconds.
+func WithListenerBackoff(minBackoff, maxBackoff time.Duration) func(*Listener) {
+	return func(l *Listener) {
+		l.minBackoff = minBackoff
+		l.maxBackoff = maxBackoff
+	}
+}
@@ -0,0 +1,80 @@
+package pg
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// notifyUntil notifies payload on channel until it's received, since the listener subscribes asynchronously.
+func notifyUntil(t *testing.T, pool *pgPool, channel, payload string, received chan string) {
+	ctx := context.Background()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		assert.NoError(t, Notify(ctx, pool, channel, payload))
+		timeout := time.After(50 * time.Millisecond)
+		for waiting := true; waiting; {
+			select {
+			case got := <-received:
+				if got == payload {
+					return
+				}
+			case <-timeout:
+				waiting = false
+			}
+		}
+	}
+	t.Fatalf("the notification %s was not received", payload)
+}
+
+func TestListenerReconnect(t *testing.T) {
+	pool := newTestPool(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	received := make(chan string, 100)
+	reconnected := make(chan struct{}, 1)
+	listener := NewListener(namedURL(t, "listener_test"),
+		WithListenerBackoff(10*time.Millisecond, 100*time.Millisecond),
+		WithListenerReconnect(func(ctx context.Context) {
+			select {
+			case reconnected <- struct{}{}:
+			default:
+			}
+		}))
+	listener.Listen("listener_test", func(ctx context.Context, payload string) {
+		received <- payload
+	})
+	done := make(chan struct{})
+	go func() {
+		listener.Run(ctx)
+		close(done)
+	}()
+
+	notifyUntil(t, pool, "listener_test", "before", received)
+
+	// the listener subscribes again after the connection is lost
+	terminate(t, pool, "listener_test")
+	select {
+	case <-reconnected:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the listener didn't reconnect")
+	}
+	notifyUntil(t, pool, "listener_test", "after", received)
+
+	// a channel added while running is subscribed
+	added := make(chan string, 100)
+	listener.Listen("listener_test_added", func(ctx context.Context, payload string) {
+		added <- payload
+	})
+	notifyUntil(t, pool, "listener_test_added", "added", added)
+
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the listener didn't stop")
+	}
+}
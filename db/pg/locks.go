@@ -1,15 +1,37 @@
 package pg
 
-import "context"
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+
+	"github.com/fdelbos/commons/db"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+)
 
 type (
+	// Locker runs functions holding session advisory locks, on a pool opened on first use.
+	// It must be closed when done.
 	Locker struct {
-		dbURL string
+		dbURL  string
+		mu     sync.Mutex
+		pool   *pgPool
+		closed bool
+	}
+
+	// lockConn is implemented by pgx.Tx, *pgx.Conn and *pgxpool.Conn.
+	lockConn interface {
+		Exec(ctx context.Context, sql string, arguments ...any) (pgconn.CommandTag, error)
+		QueryRow(ctx context.Context, sql string, args ...any) pgx.Row
 	}
 )
 
-const (
-	tryLock = "SELECT pg_try_advisory_lock($1)"
+var (
+	// ErrLockerClosed is returned by the locks of a closed Locker.
+	ErrLockerClosed = errors.New("the locker is closed")
 )
 
 func NewLocker(dbURL string) *Locker {
@@ -18,22 +40,118 @@ func NewLocker(dbURL string) *Locker {
 	}
 }
 
-func (l *Locker) TryLock(ctx context.Context, key int, fn func(context.Context) error) (bool, error) {
-	return AdvisoryLock(ctx, l.dbURL, key, fn)
+// TryLock runs fn holding the session lock key, and returns false when the lock is held by another session.
+func (l *Locker) TryLock(ctx context.Context, key db.AdvisoryLockID, fn func(context.Context) error) (bool, error) {
+	pool, err := l.open()
+	if err != nil {
+		return false, err
+	}
+	return tryLock(ctx, pool, key, fn)
+}
+
+// Close closes the pool of the locker, it waits for the functions holding a lock.
+func (l *Locker) Close() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.closed = true
+	if l.pool != nil {
+		l.pool.Close()
+		l.pool = nil
+	}
+}
+
+// open returns the pool of the locker, a pool that failed to open is opened again on the next call.
+func (l *Locker) open() (*pgPool, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.closed {
+		return nil, ErrLockerClosed
+	}
+	if l.pool == nil {
+		pool, err := NewPool(l.dbURL)
+		if err != nil {
+			return nil, err
+		}
+		l.pool = pool
+	}
+	return l.pool, nil
 }
 
+// AdvisoryLock runs fn holding the session lock key on a new connection,
+// and returns false when the lock is held by another session.
+//
+// Deprecated: use a Locker, or DB.Lock with db.WithSessionLock.
 func AdvisoryLock(ctx context.Context, url string, key int, fn func(context.Context) error) (bool, error) {
 	conn, err := NewConn(url)
 	if err != nil {
 		return false, err
 	}
 	defer conn.Close(ctx)
-	res := false
-	if err = conn.Query(ctx).Get(&res, tryLock, key); err != nil {
-		return false, err
-	}
-	if !res {
+	return tryLock(ctx, conn, db.AdvisoryLockID(key), fn)
+}
+
+// tryLock runs fn holding the session lock key, and returns false when the lock is held by
+// another session. The errors of fn are returned as is, even db.ErrLockFailed.
+func tryLock(ctx context.Context, database db.DB, key db.AdvisoryLockID, fn func(context.Context) error) (bool, error) {
+	acquired := false
+	err := database.Lock(ctx, key, func(ctx context.Context) error {
+		acquired = true
+		return fn(ctx)
+	}, db.WithSessionLock())
+	if !acquired && errors.Is(err, db.ErrLockFailed) {
 		return false, nil
 	}
-	return true, fn(ctx)
+	return acquired, err
+}
+
+// lock runs fn in a transaction holding a transaction level lock.
+func lock(ctx context.Context, conn txBeginner, lockID db.AdvisoryLockID, fn func(ctx context.Context) error, options db.LockOptions) error {
+	return tx(ctx, conn, func(ctx context.Context) error {
+		if err := acquire(ctx, ctx.Value(pgCtx).(pgx.Tx), lockID, "xact_", options); err != nil {
+			return err
+		}
+		return fn(ctx)
+	})
+}
+
+// sessionLock runs fn holding a session level lock on conn.
+func sessionLock(ctx context.Context, conn lockConn, lockID db.AdvisoryLockID, fn func(ctx context.Context) error, options db.LockOptions) error {
+	if err := acquire(ctx, conn, lockID, "", options); err != nil {
+		return err
+	}
+	defer func() {
+		// the lock is released even if ctx is canceled
+		if _, err := conn.Exec(context.Background(), lockQuery("pg_advisory_unlock", options), int64(lockID)); err != nil {
+			log.Printf("db/pg error while releasing the advisory lock %d: %v", lockID, err)
+		}
+	}()
+	return fn(ctx)
+}
+
+func acquire(ctx context.Context, conn lockConn, lockID db.AdvisoryLockID, scope string, options db.LockOptions) error {
+	if options.Wait {
+		_, err := conn.Exec(ctx, lockQuery("pg_advisory_"+scope+"lock", options), int64(lockID))
+		if err != nil && ctx.Err() != nil {
+			return fmt.Errorf("%w: %w", db.ErrLockFailed, ctx.Err())
+		}
+		return err
+	}
+
+	locked := false
+	if err := conn.QueryRow(ctx, lockQuery("pg_try_advisory_"+scope+"lock", options), int64(lockID)).Scan(&locked); err != nil {
+		return err
+	}
+	if !locked {
+		return db.ErrLockFailed
+	}
+	return nil
+}
+
+// lockQuery returns the query calling an advisory lock function, with the
+// shared variant for a shared lock (ie: pg_try_advisory_xact_lock_shared).
+func lockQuery(function string, options db.LockOptions) string {
+	if options.Shared {
+		function += "_shared"
+	}
+	return "SELECT " + function + "($1)"
 }
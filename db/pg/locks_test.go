@@ -0,0 +1,60 @@
+package pg
+
+import (
+	"context"
+	"testing"
+
+	"github.com/fdelbos/commons/db"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLocker(t *testing.T) {
+	url := testURL(t)
+	ctx := context.Background()
+	key := db.LockKey("pg/locker_test")
+
+	locker := NewLocker(url)
+	other := NewLocker(url)
+	defer other.Close()
+
+	locked, err := locker.TryLock(ctx, key, func(ctx context.Context) error {
+		// held by another session
+		locked, err := other.TryLock(ctx, key, func(context.Context) error { return nil })
+		assert.NoError(t, err)
+		assert.False(t, locked)
+
+		locked, err = AdvisoryLock(ctx, url, int(key), func(context.Context) error { return nil })
+		assert.NoError(t, err)
+		assert.False(t, locked)
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.True(t, locked)
+
+	// the errors of fn are not acquisition failures
+	locked, err = locker.TryLock(ctx, key, func(context.Context) error { return db.ErrLockFailed })
+	assert.ErrorIs(t, err, db.ErrLockFailed)
+	assert.True(t, locked)
+
+	locker.Close()
+	_, err = locker.TryLock(ctx, key, func(context.Context) error { return nil })
+	assert.ErrorIs(t, err, ErrLockerClosed)
+}
+
+func TestLockerOpen(t *testing.T) {
+	ctx := context.Background()
+	fn := func(context.Context) error { return nil }
+
+	// nothing listens on port 1
+	locker := NewLocker("postgres://postgres@127.0.0.1:1/postgres?connect_timeout=1")
+	defer locker.Close()
+	_, err := locker.TryLock(ctx, 1, fn)
+	assert.Error(t, err)
+	assert.Nil(t, locker.pool)
+
+	// the pool is opened again after a failure
+	locker.dbURL = testURL(t)
+	locked, err := locker.TryLock(ctx, 1, fn)
+	assert.NoError(t, err)
+	assert.True(t, locked)
+}
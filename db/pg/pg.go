@@ -3,6 +3,7 @@ package pg
 import (
 	"context"
 	"errors"
+	"strings"
 
 	"github.com/fdelbos/commons/db"
 	"github.com/georgysavva/scany/v2/pgxscan"
@@ -15,6 +16,7 @@ type (
 	pgPool struct {
 		pool        *pgxpool.Pool
 		databaseURL string
+		replicas    *replicaSet
 	}
 
 	PgConn struct {
@@ -26,6 +28,8 @@ type (
 		Exec(ctx context.Context, sql string, arguments ...any) (pgconn.CommandTag, error)
 		Query(ctx context.Context, sql string, args ...any) (pgx.Rows, error)
 		QueryRow(ctx context.Context, sql string, args ...any) pgx.Row
+		CopyFrom(ctx context.Context, tableName pgx.Identifier, columnNames []string, rowSrc pgx.CopyFromSource) (int64, error)
+		SendBatch(ctx context.Context, b *pgx.Batch) pgx.BatchResults
 	}
 
 	query struct {
@@ -53,39 +57,98 @@ func NewConn(url string) (*PgConn, error) {
 	return res, nil
 }
 
-func NewPool(url string) (*pgPool, error) {
+// NewPool creates a pool of connections to a postgres database, and to its
+// read replicas, see PoolOptions. It must be closed when done.
+func NewPool(url string, opts ...func(*PoolOptions)) (*pgPool, error) {
+	options := NewPoolOptions(opts...)
 	pool, err := newPool(context.Background(), url)
 	if err != nil {
 		return nil, err
 	}
-	return &pgPool{
+	res := &pgPool{
 		pool:        pool,
 		databaseURL: url,
-	}, nil
+	}
+
+	if len(options.Replicas) > 0 {
+		res.replicas, err = newReplicaSet(options)
+		if err != nil {
+			pool.Close()
+			return nil, err
+		}
+	}
+	return res, nil
 }
 
 func (pg *pgPool) Query(ctx context.Context) db.Query {
-	return queryFromCtx(ctx, pg.pool)
+	if pg.replicas == nil || ctx.Value(pgCtx) != nil || db.UsePrimary(ctx) {
+		return queryFromCtx(ctx, pg.pool)
+	}
+	replica := pg.replicas.pick()
+	if replica == nil {
+		return queryFromCtx(ctx, pg.pool)
+	}
+	return &replicaQuery{
+		query:   &query{pg.pool, ctx},
+		replica: replica,
+	}
 }
 
-func (pg *pgPool) Tx(ctx context.Context, fn func(ctx context.Context) error) error {
-	return tx(ctx, pg.pool, fn)
+func (pg *pgPool) Tx(ctx context.Context, fn func(ctx context.Context) error, opts ...func(*db.TxOptions)) error {
+	return tx(ctx, pg.pool, fn, opts...)
 }
 
-func (pg *pgPool) Lock(ctx context.Context, lockID db.AdvisoryLockID, fn func(ctx context.Context) error) error {
-	return lock(ctx, pg.pool, lockID, fn)
+func (pg *pgPool) Dialect() db.Dialect {
+	return db.Postgres
 }
 
-func (pg *PgConn) Lock(ctx context.Context, lockID db.AdvisoryLockID, fn func(ctx context.Context) error) error {
-	return lock(ctx, pg.conn, lockID, fn)
+func (pg *pgPool) Retryable(err error) bool {
+	return Retryable(err)
+}
+
+func (pg *pgPool) Close() {
+	if pg.replicas != nil {
+		pg.replicas.close()
+	}
+	pg.pool.Close()
+}
+
+func (pg *pgPool) Lock(ctx context.Context, lockID db.AdvisoryLockID, fn func(ctx context.Context) error, opts ...func(*db.LockOptions)) error {
+	options := db.NewLockOptions(opts...)
+	if !options.Session {
+		return lock(ctx, pg.pool, lockID, fn, options)
+	}
+
+	conn, err := pg.pool.Acquire(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Release()
+	return sessionLock(ctx, conn, lockID, fn, options)
+}
+
+func (pg *PgConn) Lock(ctx context.Context, lockID db.AdvisoryLockID, fn func(ctx context.Context) error, opts ...func(*db.LockOptions)) error {
+	options := db.NewLockOptions(opts...)
+	if !options.Session {
+		return lock(ctx, pg.conn, lockID, fn, options)
+	}
+	return sessionLock(ctx, pg.conn, lockID, fn, options)
 }
 
 func (pg *PgConn) Query(ctx context.Context) db.Query {
 	return queryFromCtx(ctx, pg.conn)
 }
 
-func (pg *PgConn) Tx(ctx context.Context, fn func(ctx context.Context) error) error {
-	return tx(ctx, pg.conn, fn)
+func (pg *PgConn) Tx(ctx context.Context, fn func(ctx context.Context) error, opts ...func(*db.TxOptions)) error {
+	return tx(ctx, pg.conn, fn, opts...)
+}
+
+func (pg *PgConn) Dialect() db.Dialect {
+	return db.Postgres
+}
+
+func (pg *PgConn) Retryable(err error) bool {
+	return Retryable(err)
 }
 
 func (pg *PgConn) Close(ctx context.Context) error {
@@ -103,6 +166,20 @@ func (q *query) Exec(sql string, arguments ...interface{}) error {
 	return err
 }
 
+func (q *query) ExecResult(sql string, arguments ...interface{}) (db.Result, error) {
+	if q.conn == nil {
+		return db.Result{}, ErrNoConnectionInContext
+	}
+	tag, err := q.conn.Exec(q.ctx, sql, arguments...)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return db.Result{}, db.ErrNoRows
+		}
+		return db.Result{}, err
+	}
+	return db.Result{RowsAffected: tag.RowsAffected()}, nil
+}
+
 func (q *query) Select(dest interface{}, sql string, args ...interface{}) error {
 	if q.conn == nil {
 		return ErrNoConnectionInContext
@@ -124,3 +201,82 @@ func (q *query) Get(dest interface{}, sql string, args ...interface{}) error {
 	}
 	return err
 }
+
+func (q *query) Each(dest interface{}, sql string, args []interface{}, fn func() error) error {
+	if q.conn == nil {
+		return ErrNoConnectionInContext
+	}
+	rows, err := q.conn.Query(q.ctx, sql, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	scanner := pgxscan.NewRowScanner(rows)
+	for rows.Next() {
+		if err := q.ctx.Err(); err != nil {
+			return err
+		}
+		if err := scanner.Scan(dest); err != nil {
+			return err
+		}
+		if err := fn(); err != nil {
+			if errors.Is(err, db.ErrStop) {
+				return nil
+			}
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+func (q *query) CopyFrom(table string, columns []string, rows db.RowSource) (int64, error) {
+	if q.conn == nil {
+		return 0, ErrNoConnectionInContext
+	}
+	return q.conn.CopyFrom(q.ctx, pgx.Identifier(strings.Split(table, ".")), columns, rows)
+}
+
+func (q *query) SendBatch(batch *db.Batch) error {
+	if q.conn == nil {
+		return ErrNoConnectionInContext
+	}
+	if batch.Len() == 0 {
+		return nil
+	}
+
+	pgBatch := &pgx.Batch{}
+	for _, bq := range batch.Queries() {
+		pgBatch.Queue(bq.SQL, bq.Args...)
+	}
+	results := q.conn.SendBatch(q.ctx, pgBatch)
+	defer results.Close()
+
+	for _, bq := range batch.Queries() {
+		var err error
+		switch bq.Kind {
+		case db.BatchExec:
+			var tag pgconn.CommandTag
+			tag, err = results.Exec()
+			if err == nil && bq.Result != nil {
+				*bq.Result = db.Result{RowsAffected: tag.RowsAffected()}
+			}
+
+		case db.BatchGet, db.BatchSelect:
+			var rows pgx.Rows
+			rows, err = results.Query()
+			if err == nil && bq.Kind == db.BatchGet {
+				err = pgxscan.ScanOne(bq.Dest, rows)
+			} else if err == nil {
+				err = pgxscan.ScanAll(bq.Dest, rows)
+			}
+		}
+		if err != nil {
+			if errors.Is(err, pgx.ErrNoRows) {
+				return db.ErrNoRows
+			}
+			return err
+		}
+	}
+	return results.Close()
+}
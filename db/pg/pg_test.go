@@ -0,0 +1,43 @@
+package pg
+
+import (
+	"context"
+	"net/url"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// testURL returns the url of the test database from PG_TEST_URL, the test is skipped when it's not set.
+func testURL(t *testing.T) string {
+	url := os.Getenv("PG_TEST_URL")
+	if url == "" {
+		t.Skip("PG_TEST_URL is not set")
+	}
+	return url
+}
+
+// namedURL returns the url of the test database with the application_name of the connections,
+// so they can be terminated by name, see terminate.
+func namedURL(t *testing.T, name string) string {
+	u, err := url.Parse(testURL(t))
+	assert.NoError(t, err)
+	query := u.Query()
+	query.Set("application_name", name)
+	u.RawQuery = query.Encode()
+	return u.String()
+}
+
+// terminate closes the connections of namedURL on the server side, like a lost connection.
+func terminate(t *testing.T, pool *pgPool, name string) {
+	err := pool.Query(context.Background()).Exec("SELECT pg_terminate_backend(pid) FROM pg_stat_activity WHERE application_name = $1", name)
+	assert.NoError(t, err)
+}
+
+func newTestPool(t *testing.T) *pgPool {
+	pool, err := NewPool(testURL(t))
+	assert.NoError(t, err)
+	t.Cleanup(pool.Close)
+	return pool
+}
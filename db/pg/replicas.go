@@ -0,0 +1,261 @@
+package pg
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"regexp"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+type (
+	// PoolOptions configures the read replicas of a pool.
+	//
+	// Outside of a transaction, the queries of Select, Get and Each starting with SELECT are
+	// sent to a healthy replica, everything else goes to the primary: the queries starting
+	// with WITH (ie: WITH ... INSERT ... RETURNING), the row locking SELECT (FOR UPDATE...)
+	// and SELECT INTO. Use db.WithPrimary to read from the primary (ie: to read your own
+	// writes), and to Get the result of a function changing the database in a SELECT:
+	//
+	//	err := pool.Query(db.WithPrimary(ctx)).Get(&id, "SELECT nextval('seq')")
+	PoolOptions struct {
+		// Replicas are the urls of the read replicas.
+		Replicas []string
+		// MaxLag ejects the replicas lagging more behind the primary, 0 for no limit.
+		MaxLag time.Duration
+		// CheckInterval is the delay between the health checks of the replicas.
+		CheckInterval time.Duration
+	}
+
+	replica struct {
+		pool    *pgxpool.Pool
+		host    string
+		healthy atomic.Bool
+	}
+
+	// replicaQuery sends the reads to a replica and everything else to the primary.
+	replicaQuery struct {
+		*query
+		replica pgxInterface
+	}
+
+	// replicaSet routes the reads to the healthy replicas, in turn.
+	replicaSet struct {
+		replicas []*replica
+		next     atomic.Uint64
+		maxLag   time.Duration
+		interval time.Duration
+		cancel   context.CancelFunc
+		wg       sync.WaitGroup
+	}
+)
+
+const (
+	DefaultReplicaMaxLag        = 10 * time.Second
+	DefaultReplicaCheckInterval = 5 * time.Second
+
+	// the lag is 0 when the replica replayed all it received, as an idle
+	// primary doesn't advance the last replay timestamp
+	replicaLag = `SELECT CASE
+		WHEN NOT pg_is_in_recovery() OR pg_last_wal_receive_lsn() = pg_last_wal_replay_lsn() THEN 0
+		ELSE COALESCE(EXTRACT(EPOCH FROM now() - pg_last_xact_replay_timestamp()), 0)
+	END::float8`
+)
+
+var (
+	// the SELECT writing or locking rows, a replica would fail to run them
+	writing = regexp.MustCompile(`(?i)\bfor\s+(update|share|no\s+key\s+update|key\s+share)\b|\binto\b`)
+)
+
+func NewPoolOptions(opts ...func(*PoolOptions)) PoolOptions {
+	options := PoolOptions{
+		MaxLag:        DefaultReplicaMaxLag,
+		CheckInterval: DefaultReplicaCheckInterval,
+	}
+	for _, opt := range opts {
+		opt(&options)
+	}
+	return options
+}
+
+// newReplicaSet connects to the replicas, checks them once and keeps checking them
+// in the background until closed. A replica failing to connect is ejected until it recovers.
+func newReplicaSet(options PoolOptions) (*replicaSet, error) {
+	set := &replicaSet{
+		maxLag:   options.MaxLag,
+		interval: options.CheckInterval,
+	}
+	for _, url := range options.Replicas {
+		config, err := pgxpool.ParseConfig(url)
+		if err != nil {
+			log.Printf("db/pg Unable to parse the replica url: %v", err)
+			set.close()
+			return nil, err
+		}
+		pool, err := pgxpool.NewWithConfig(context.Background(), config)
+		if err != nil {
+			log.Printf("db/pg Unable to create replica connection pool: %v", err)
+			set.close()
+			return nil, err
+		}
+		set.replicas = append(set.replicas, &replica{
+			pool: pool,
+			host: fmt.Sprintf("%s:%d", config.ConnConfig.Host, config.ConnConfig.Port),
+		})
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	set.cancel = cancel
+	set.check(ctx)
+
+	set.wg.Add(1)
+	go func() {
+		defer set.wg.Done()
+		ticker := time.NewTicker(set.interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				set.check(ctx)
+			}
+		}
+	}()
+	return set, nil
+}
+
+// pick returns a healthy replica, or nil when there is none.
+// The healthy replicas are picked in turn, an ejected replica doesn't send its turn to the next one.
+func (set *replicaSet) pick() pgxInterface {
+	healthy := uint64(0)
+	for _, r := range set.replicas {
+		if r.healthy.Load() {
+			healthy++
+		}
+	}
+	if healthy == 0 {
+		return nil
+	}
+
+	var first *pgxpool.Pool
+	turn := set.next.Add(1) % healthy
+	for _, r := range set.replicas {
+		if !r.healthy.Load() {
+			continue
+		}
+		if turn == 0 {
+			return r.pool
+		}
+		if first == nil {
+			first = r.pool
+		}
+		turn--
+	}
+	// a replica was ejected meanwhile
+	if first == nil {
+		return nil
+	}
+	return first
+}
+
+func (set *replicaSet) check(ctx context.Context) {
+	for _, r := range set.replicas {
+		err := set.checkReplica(ctx, r)
+		if ctx.Err() != nil {
+			return
+		}
+		healthy := err == nil
+		if r.healthy.Swap(healthy) == healthy {
+			continue
+		}
+		if healthy {
+			log.Printf(`db/pg replica host="%s" is healthy`, r.host)
+		} else {
+			log.Printf(`db/pg replica host="%s" is ejected: %v`, r.host, err)
+		}
+	}
+}
+
+func (set *replicaSet) checkReplica(ctx context.Context, r *replica) error {
+	// the lag query also checks the connection
+	checkCtx, cancel := context.WithTimeout(ctx, set.interval)
+	defer cancel()
+
+	seconds := 0.0
+	if err := r.pool.QueryRow(checkCtx, replicaLag).Scan(&seconds); err != nil {
+		return err
+	}
+	lag := time.Duration(seconds * float64(time.Second))
+	if set.maxLag > 0 && lag > set.maxLag {
+		return fmt.Errorf("replication lag of %s", lag.Round(time.Millisecond))
+	}
+	return nil
+}
+
+func (set *replicaSet) close() {
+	if set.cancel != nil {
+		set.cancel()
+	}
+	set.wg.Wait()
+	for _, r := range set.replicas {
+		r.pool.Close()
+	}
+}
+
+func (q *replicaQuery) Select(dest interface{}, sql string, args ...interface{}) error {
+	return q.route(sql).Select(dest, sql, args...)
+}
+
+func (q *replicaQuery) Get(dest interface{}, sql string, args ...interface{}) error {
+	return q.route(sql).Get(dest, sql, args...)
+}
+
+func (q *replicaQuery) Each(dest interface{}, sql string, args []interface{}, fn func() error) error {
+	return q.route(sql).Each(dest, sql, args, fn)
+}
+
+func (q *replicaQuery) route(sql string) *query {
+	if isRead(sql) {
+		return &query{q.replica, q.ctx}
+	}
+	return q.query
+}
+
+// isRead returns true for the queries a replica can run. Only a leading SELECT is a read,
+// so the WITH queries always go to the primary: their CTE can write.
+func isRead(sql string) bool {
+	fields := strings.Fields(sql)
+	if len(fields) == 0 || !strings.EqualFold(fields[0], "select") {
+		return false
+	}
+	return !writing.MatchString(sql)
+}
+
+// WithReplicas adds read replicas to the pool.
+func WithReplicas(urls ...string) func(*PoolOptions) {
+	return func(options *PoolOptions) {
+		options.Replicas = append(options.Replicas, urls...)
+	}
+}
+
+// WithReplicaMaxLag sets the replication lag above which a replica is ejected,
+// 0 for no limit. Default is 10 seconds.
+func WithReplicaMaxLag(maxLag time.Duration) func(*PoolOptions) {
+	return func(options *PoolOptions) {
+		options.MaxLag = maxLag
+	}
+}
+
+// WithReplicaCheckInterval sets the delay between the health checks of the replicas.
+// Default is 5 seconds.
+func WithReplicaCheckInterval(interval time.Duration) func(*PoolOptions) {
+	return func(options *PoolOptions) {
+		options.CheckInterval = interval
+	}
+}
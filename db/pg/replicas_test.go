@@ -0,0 +1,77 @@
+package pg
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIsRead(t *testing.T) {
+	cases := []struct {
+		sql  string
+		read bool
+	}{
+		{"SELECT * FROM users", true},
+		{"  select id\n\tFROM users WHERE email = $1", true},
+		{"SELECT count(*) FROM users u JOIN teams t ON t.id = u.team_id", true},
+		{"SELECT * FROM users WHERE into_date > $1", true},
+		{"", false},
+		{"INSERT INTO users (email) VALUES ($1)", false},
+		{"UPDATE users SET email = $1", false},
+		{"DELETE FROM users", false},
+		{"SELECT * FROM users FOR UPDATE", false},
+		{"SELECT * FROM jobs FOR UPDATE SKIP LOCKED", false},
+		{"SELECT * FROM users for share", false},
+		{"SELECT * FROM users FOR NO KEY UPDATE", false},
+		{"SELECT * FROM users FOR KEY SHARE", false},
+		{"SELECT * INTO users_backup FROM users", false},
+		{"WITH active AS (SELECT * FROM users) SELECT * FROM active", false},
+		{"WITH inserted AS (INSERT INTO users (email) VALUES ($1) RETURNING id) SELECT id FROM inserted", false},
+		{"WITH stale AS (SELECT id FROM jobs) UPDATE jobs SET status = 'dead' WHERE id IN (SELECT id FROM stale) RETURNING *", false},
+		{"(SELECT 1)", false},
+		// the literals are not parsed, a false match only sends a read to the primary
+		{"SELECT 'for update' AS label", false},
+	}
+	for _, c := range cases {
+		assert.Equal(t, c.read, isRead(c.sql), c.sql)
+	}
+}
+
+func TestPick(t *testing.T) {
+	set := &replicaSet{}
+	assert.Nil(t, set.pick())
+
+	// the pools connect lazily, the replicas are never reached
+	for i := 0; i < 3; i++ {
+		pool, err := pgxpool.New(context.Background(), fmt.Sprintf("postgres://localhost:1/replica%d", i))
+		assert.NoError(t, err)
+		defer pool.Close()
+		set.replicas = append(set.replicas, &replica{pool: pool, host: fmt.Sprintf("replica%d", i)})
+	}
+	assert.Nil(t, set.pick())
+
+	picked := func(n int) map[pgxInterface]int {
+		res := map[pgxInterface]int{}
+		for i := 0; i < n; i++ {
+			res[set.pick()]++
+		}
+		return res
+	}
+
+	// the healthy replicas are picked in turn
+	set.replicas[0].healthy.Store(true)
+	set.replicas[2].healthy.Store(true)
+	assert.Equal(t, map[pgxInterface]int{
+		set.replicas[0].pool: 3,
+		set.replicas[2].pool: 3,
+	}, picked(6))
+
+	set.replicas[0].healthy.Store(false)
+	assert.Equal(t, map[pgxInterface]int{set.replicas[2].pool: 4}, picked(4))
+
+	set.replicas[2].healthy.Store(false)
+	assert.Nil(t, set.pick())
+}
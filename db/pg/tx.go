@@ -7,56 +7,84 @@ import (
 
 	"github.com/fdelbos/commons/db"
 	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+const (
+	// SQLSTATE of the transient transaction errors
+	serializationFailure = "40001"
+	deadlockDetected     = "40P01"
 )
 
 type (
 	txBeginner interface {
-		Begin(ctx context.Context) (pgx.Tx, error)
+		BeginTx(ctx context.Context, txOptions pgx.TxOptions) (pgx.Tx, error)
 	}
 )
 
-func tx(ctx context.Context, conn txBeginner, fn func(ctx context.Context) error) error {
+func tx(ctx context.Context, conn txBeginner, fn func(ctx context.Context) error, opts ...func(*db.TxOptions)) error {
+	options := db.NewTxOptions(opts...)
 
-	if q := ctx.Value(pgCtx); q != nil {
-		if _, ok := q.(pgx.Tx); ok {
-			log.Fatal("db/pg database context is already in a transaction")
+	var tx pgx.Tx
+	var err error
+	if outer, ok := ctx.Value(pgCtx).(pgx.Tx); ok {
+		if options.Propagation == db.TxJoin {
+			return fn(ctx)
 		}
+		// pgx runs a transaction started from a transaction in a savepoint
+		tx, err = outer.Begin(ctx)
+	} else {
+		tx, err = conn.BeginTx(ctx, txOptions(options))
 	}
-
-	tx, err := conn.Begin(ctx)
 	if err != nil {
 		log.Printf("db/pg cant obtain transaction on the database: %v", err)
 		return err
 	}
 
+	// rolled back when fn fails, or panics
+	closed := false
+	hooksCtx, hooks := db.WithTxHooks(ctx)
 	defer func() {
-		if !tx.Conn().IsClosed() {
-			if err := tx.Rollback(ctx); err != nil && !errors.Is(err, pgx.ErrTxClosed) {
-				log.Printf("db/pg error while rolling back: %v", err)
+		if !closed {
+			if !tx.Conn().IsClosed() {
+				if err := tx.Rollback(ctx); err != nil && !errors.Is(err, pgx.ErrTxClosed) {
+					log.Printf("db/pg error while rolling back: %v", err)
+				}
 			}
+			hooks.RolledBack(ctx)
 		}
 	}()
 
-	fnCtx := context.WithValue(ctx, pgCtx, tx)
-	if err := fn(fnCtx); err != nil {
+	fnCtx := context.WithValue(hooksCtx, pgCtx, tx)
+	err = fn(fnCtx)
+	if err == nil {
+		err = tx.Commit(ctx)
+	}
+	if err != nil {
 		return err
-	} else {
-		return tx.Commit(ctx)
 	}
+	closed = true
+	hooks.Committed(ctx)
+	return nil
 }
 
-func lock(ctx context.Context, conn txBeginner, lockID db.AdvisoryLockID, fn func(ctx context.Context) error) error {
-	return tx(ctx, conn, func(ctx context.Context) error {
-		dest := struct {
-			Locked bool `db:"locked"`
-		}{}
-		err := queryFromCtx(ctx, nil).Get(&dest, "SELECT pg_try_advisory_xact_lock($1) as locked", lockID)
-		if err != nil {
-			return err
-		}
-		if !dest.Locked {
-			return db.ErrLockFailed
-		}
-		return fn(ctx)
-	})
+func txOptions(options db.TxOptions) pgx.TxOptions {
+	res := pgx.TxOptions{IsoLevel: pgx.TxIsoLevel(options.Isolation)}
+	if options.ReadOnly {
+		res.AccessMode = pgx.ReadOnly
+	}
+	if options.Deferrable {
+		res.DeferrableMode = pgx.Deferrable
+	}
+	return res
+}
+
+// Retryable returns true when err is a serialization failure or a deadlock,
+// so the transaction can be run again, see db.RetryTx.
+func Retryable(err error) bool {
+	pgErr := &pgconn.PgError{}
+	if !errors.As(err, &pgErr) {
+		return false
+	}
+	return pgErr.Code == serializationFailure || pgErr.Code == deadlockDetected
 }
@@ -0,0 +1,43 @@
+package pg
+
+import (
+	"context"
+	"testing"
+
+	"github.com/fdelbos/commons/db"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTxPanicHooks(t *testing.T) {
+	pool := newTestPool(t)
+	ctx := context.Background()
+
+	calls := []string{}
+	record := func(name string) func(context.Context) {
+		return func(context.Context) { calls = append(calls, name) }
+	}
+
+	assert.PanicsWithValue(t, "boom", func() {
+		pool.Tx(ctx, func(ctx context.Context) error {
+			db.AfterCommit(ctx, record("commit"))
+			db.AfterRollback(ctx, record("rollback"))
+			panic("boom")
+		})
+	})
+	assert.Equal(t, []string{"rollback"}, calls)
+
+	calls = []string{}
+	err := pool.Tx(ctx, func(ctx context.Context) error {
+		db.AfterCommit(ctx, record("commit"))
+		assert.PanicsWithValue(t, "boom", func() {
+			pool.Tx(ctx, func(ctx context.Context) error {
+				db.AfterCommit(ctx, record("savepoint commit"))
+				db.AfterRollback(ctx, record("savepoint rollback"))
+				panic("boom")
+			})
+		})
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"savepoint rollback", "commit"}, calls)
+}
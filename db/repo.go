@@ -0,0 +1,243 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+	"unicode"
+)
+
+type (
+	// Table is implemented by the row types given to the generic helpers
+	// (GetByID, List, InsertRow, UpdateRow and DeleteByID), on their value receiver.
+	//
+	// The columns are the `db` struct tags used by scany, with the options:
+	//   - pk: the primary key, "id" by default. A zero primary key is not inserted,
+	//     so the database generates it.
+	//   - readonly: the column is never inserted nor updated (ie: a default or generated value).
+	Table interface {
+		TableName() string
+	}
+
+	// Pagination selects a page of results.
+	Pagination struct {
+		Limit  int64 `json:"limit"`
+		Offset int64 `json:"offset"`
+	}
+
+	tableColumn struct {
+		name     string
+		index    []int
+		pk       bool
+		readonly bool
+	}
+
+	tableInfo struct {
+		name    string
+		columns []tableColumn
+		pk      *tableColumn
+	}
+)
+
+var (
+	ErrNoTable      = errors.New("the type doesn't implement db.Table")
+	ErrNoPrimaryKey = errors.New("the type has no primary key")
+
+	tables sync.Map // reflect.Type -> *tableInfo
+)
+
+// GetByID returns the row of T with the given primary key, or ErrNoRows.
+func GetByID[T any](ctx context.Context, database DB, id any) (*T, error) {
+	table, err := tableOf[T]()
+	if err != nil {
+		return nil, err
+	}
+	res := new(T)
+	err = Select().From(table.name).Where(Eq(table.pk.name, id)).Get(ctx, database, res)
+	if err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// List returns a page of the rows of T matching all the filters, ordered by primary key.
+// A zero limit returns all the rows.
+func List[T any](ctx context.Context, database DB, filters []Cond, page Pagination) ([]T, error) {
+	table, err := tableOf[T]()
+	if err != nil {
+		return nil, err
+	}
+	query := Select().
+		From(table.name).
+		Where(filters...).
+		OrderBy(table.pk.name)
+	if page.Limit > 0 {
+		query.Limit(int(page.Limit))
+	}
+	if page.Offset > 0 {
+		query.Offset(int(page.Offset))
+	}
+
+	res := []T{}
+	err = query.Select(ctx, database, &res)
+	return res, err
+}
+
+// InsertRow inserts row, and updates it with the inserted values (ie: the generated primary key).
+func InsertRow[T any](ctx context.Context, database DB, row *T) error {
+	table, err := tableOf[T]()
+	if err != nil {
+		return err
+	}
+	value := reflect.ValueOf(row).Elem()
+
+	columns := []string{}
+	values := []any{}
+	for _, c := range table.columns {
+		field := value.FieldByIndex(c.index)
+		if c.readonly || (c.pk && field.IsZero()) {
+			continue
+		}
+		columns = append(columns, c.name)
+		values = append(values, field.Interface())
+	}
+	return Insert(table.name).
+		Columns(columns...).
+		Values(values...).
+		Returning("*").
+		Get(ctx, database, row)
+}
+
+// UpdateRow updates all the columns of row, and updates it with the stored values.
+// ErrNoRows is returned when no row has its primary key.
+func UpdateRow[T any](ctx context.Context, database DB, row *T) error {
+	table, err := tableOf[T]()
+	if err != nil {
+		return err
+	}
+	value := reflect.ValueOf(row).Elem()
+
+	query := Update(table.name)
+	for _, c := range table.columns {
+		if !c.readonly && !c.pk {
+			query.Set(c.name, value.FieldByIndex(c.index).Interface())
+		}
+	}
+	return query.
+		Where(Eq(table.pk.name, value.FieldByIndex(table.pk.index).Interface())).
+		Returning("*").
+		Get(ctx, database, row)
+}
+
+// DeleteByID deletes the row of T with the given primary key.
+// ErrNoRows is returned when there is no such row.
+func DeleteByID[T any](ctx context.Context, database DB, id any) error {
+	table, err := tableOf[T]()
+	if err != nil {
+		return err
+	}
+	deleted := reflect.New(reflect.TypeOf((*T)(nil)).Elem().FieldByIndex(table.pk.index).Type)
+	return Delete(table.name).
+		Where(Eq(table.pk.name, id)).
+		Returning(table.pk.name).
+		Get(ctx, database, deleted.Interface())
+}
+
+// tableOf returns the table of T, read from its struct tags once.
+func tableOf[T any]() (*tableInfo, error) {
+	typ := reflect.TypeOf((*T)(nil)).Elem()
+	if info, ok := tables.Load(typ); ok {
+		return info.(*tableInfo), nil
+	}
+
+	t, ok := any(*new(T)).(Table)
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrNoTable, typ)
+	}
+	if typ.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("%w: %s is not a struct", ErrNoTable, typ)
+	}
+
+	info := &tableInfo{
+		name:    t.TableName(),
+		columns: tableColumns(typ, nil),
+	}
+	for i, c := range info.columns {
+		if c.pk {
+			info.pk = &info.columns[i]
+		}
+	}
+	if info.pk == nil {
+		for i, c := range info.columns {
+			if c.name == "id" {
+				info.columns[i].pk = true
+				info.pk = &info.columns[i]
+			}
+		}
+	}
+	if info.pk == nil {
+		return nil, fmt.Errorf("%w: %s", ErrNoPrimaryKey, typ)
+	}
+
+	tables.Store(typ, info)
+	return info, nil
+}
+
+// tableColumns returns the columns of a struct the way scany maps them:
+// the db tag or the snake case name of the field, with the embedded structs flattened.
+func tableColumns(typ reflect.Type, index []int) []tableColumn {
+	columns := []tableColumn{}
+	for i := 0; i < typ.NumField(); i++ {
+		field := typ.Field(i)
+		if !field.IsExported() && !field.Anonymous {
+			continue
+		}
+		fieldIndex := append(append([]int{}, index...), i)
+
+		tag, hasTag := field.Tag.Lookup("db")
+		name, options, _ := strings.Cut(tag, ",")
+		if name == "-" {
+			continue
+		}
+		if field.Anonymous && !hasTag && field.Type.Kind() == reflect.Struct {
+			columns = append(columns, tableColumns(field.Type, fieldIndex)...)
+			continue
+		}
+		if name == "" {
+			name = toSnakeCase(field.Name)
+		}
+
+		column := tableColumn{name: name, index: fieldIndex}
+		for _, option := range strings.Split(options, ",") {
+			switch strings.TrimSpace(option) {
+			case "pk":
+				column.pk = true
+			case "readonly":
+				column.readonly = true
+			}
+		}
+		columns = append(columns, column)
+	}
+	return columns
+}
+
+// toSnakeCase converts a field name to snake case, ie: UserID to user_id.
+func toSnakeCase(name string) string {
+	runes := []rune(name)
+	res := strings.Builder{}
+	for i, r := range runes {
+		if unicode.IsUpper(r) {
+			boundary := i > 0 && (unicode.IsLower(runes[i-1]) ||
+				(i+1 < len(runes) && unicode.IsLower(runes[i+1]) && unicode.IsUpper(runes[i-1])))
+			if boundary {
+				res.WriteByte('_')
+			}
+			r = unicode.ToLower(r)
+		}
+		res.WriteRune(r)
+	}
+	return res.String()
+}
@@ -0,0 +1,168 @@
+package db_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	. "github.com/fdelbos/commons/db"
+	"github.com/fdelbos/commons/db/sqlite"
+	"github.com/stretchr/testify/assert"
+)
+
+type (
+	audit struct {
+		UpdatedBy string `db:"updated_by"`
+	}
+
+	user struct {
+		ID        int64     `db:"id"`
+		Email     string    `db:"email"`
+		FullName  string    // full_name
+		CreatedAt time.Time `db:"created_at,readonly"`
+		audit
+	}
+
+	notATable struct {
+		ID int64 `db:"id"`
+	}
+)
+
+func (user) TableName() string {
+	return "users"
+}
+
+func newRepoDB(t *testing.T) *sqlite.SqlConn {
+	dname, err := os.MkdirTemp("", "")
+	assert.NoError(t, err)
+	t.Cleanup(func() { os.RemoveAll(dname) })
+
+	conn, err := sqlite.NewConn(fmt.Sprintf("%s/db.sqlite3", dname))
+	assert.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+
+	err = conn.Query(context.Background()).Exec(`
+CREATE TABLE users (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	email TEXT NOT NULL UNIQUE,
+	full_name TEXT NOT NULL,
+	created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	updated_by TEXT NOT NULL
+)`)
+	assert.NoError(t, err)
+	return conn
+}
+
+func TestRepository(t *testing.T) {
+	conn := newRepoDB(t)
+	ctx := context.Background()
+
+	bob := &user{Email: "bob@example.com", FullName: "Bob", audit: audit{UpdatedBy: "admin"}}
+	assert.NoError(t, InsertRow(ctx, conn, bob))
+	assert.Equal(t, int64(1), bob.ID)
+	assert.False(t, bob.CreatedAt.IsZero())
+
+	for i := 0; i < 4; i++ {
+		u := &user{Email: fmt.Sprintf("user%d@example.com", i), FullName: "User", audit: audit{UpdatedBy: "admin"}}
+		assert.NoError(t, InsertRow(ctx, conn, u))
+	}
+
+	found, err := GetByID[user](ctx, conn, bob.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, "Bob", found.FullName)
+	assert.Equal(t, "admin", found.UpdatedBy)
+
+	bob.FullName = "Bobby"
+	bob.UpdatedBy = "bob"
+	createdAt := bob.CreatedAt
+	bob.CreatedAt = time.Time{}
+	assert.NoError(t, UpdateRow(ctx, conn, bob))
+	// readonly columns are not updated, and read back
+	assert.Equal(t, createdAt, bob.CreatedAt)
+	found, err = GetByID[user](ctx, conn, bob.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, "Bobby", found.FullName)
+	assert.Equal(t, "bob", found.UpdatedBy)
+
+	page, err := List[user](ctx, conn, []Cond{Eq("full_name", "User")}, Pagination{Limit: 2, Offset: 1})
+	assert.NoError(t, err)
+	assert.Len(t, page, 2)
+	assert.Equal(t, "user1@example.com", page[0].Email)
+	assert.Equal(t, "user2@example.com", page[1].Email)
+
+	all, err := List[user](ctx, conn, nil, Pagination{})
+	assert.NoError(t, err)
+	assert.Len(t, all, 5)
+
+	assert.NoError(t, DeleteByID[user](ctx, conn, bob.ID))
+	_, err = GetByID[user](ctx, conn, bob.ID)
+	assert.ErrorIs(t, err, ErrNoRows)
+	assert.ErrorIs(t, DeleteByID[user](ctx, conn, bob.ID), ErrNoRows)
+	assert.ErrorIs(t, UpdateRow(ctx, conn, bob), ErrNoRows)
+
+	_, err = GetByID[notATable](ctx, conn, 1)
+	assert.ErrorIs(t, err, ErrNoTable)
+}
+
+func TestRepositoryTx(t *testing.T) {
+	conn := newRepoDB(t)
+	ctx := context.Background()
+
+	errRollback := errors.New("rollback")
+	err := conn.Tx(ctx, func(ctx context.Context) error {
+		u := &user{Email: "bob@example.com", FullName: "Bob"}
+		assert.NoError(t, InsertRow(ctx, conn, u))
+
+		// visible inside the transaction
+		found, err := GetByID[user](ctx, conn, u.ID)
+		assert.NoError(t, err)
+		assert.Equal(t, "Bob", found.FullName)
+		return errRollback
+	})
+	assert.ErrorIs(t, err, errRollback)
+
+	all, err := List[user](ctx, conn, nil, Pagination{})
+	assert.NoError(t, err)
+	assert.Empty(t, all)
+
+	err = conn.Tx(ctx, func(ctx context.Context) error {
+		return InsertRow(ctx, conn, &user{Email: "bob@example.com", FullName: "Bob"})
+	})
+	assert.NoError(t, err)
+	all, err = List[user](ctx, conn, nil, Pagination{})
+	assert.NoError(t, err)
+	assert.Len(t, all, 1)
+}
+
+func TestBulkInsert(t *testing.T) {
+	conn := newRepoDB(t)
+	ctx := context.Background()
+
+	users := []user{}
+	for i := 0; i < 2500; i++ {
+		users = append(users, user{Email: fmt.Sprintf("user%d@example.com", i), FullName: "User", audit: audit{UpdatedBy: "admin"}})
+	}
+	n, err := BulkInsert(ctx, conn, users)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2500), n)
+
+	all, err := List[user](ctx, conn, nil, Pagination{})
+	assert.NoError(t, err)
+	assert.Len(t, all, 2500)
+	assert.Equal(t, int64(1), all[0].ID)
+	assert.Equal(t, "user2499@example.com", all[2499].Email)
+
+	// nothing is inserted when a row fails
+	n, err = BulkInsert(ctx, conn, []user{{ID: 3000, Email: "new@example.com"}, {ID: 3001, Email: "user1@example.com"}})
+	assert.Error(t, err)
+	assert.Equal(t, int64(0), n)
+	_, err = GetByID[user](ctx, conn, 3000)
+	assert.ErrorIs(t, err, ErrNoRows)
+
+	n, err = BulkInsert(ctx, conn, []user{})
+	assert.NoError(t, err)
+	assert.Equal(t, int64(0), n)
+}
@@ -0,0 +1,90 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	"github.com/fdelbos/commons/db"
+)
+
+const (
+	// maximum number of rows and of arguments of a batched INSERT
+	bulkMaxRows = 1000
+	bulkMaxArgs = 32766
+)
+
+// CopyFrom inserts the rows with batched multi rows INSERTs,
+// in a new transaction unless the query is already in one.
+func (q *query) CopyFrom(table string, columns []string, rows db.RowSource) (int64, error) {
+	conn, ok := q.conn.(*sql.DB)
+	if !ok {
+		return q.copyFrom(table, columns, rows)
+	}
+
+	count := int64(0)
+	err := tx(q.ctx, conn, func(ctx context.Context) error {
+		var err error
+		count, err = queryFromCtx(ctx, conn).(*query).copyFrom(table, columns, rows)
+		return err
+	})
+	if err != nil {
+		return 0, err
+	}
+	return count, nil
+}
+
+func (q *query) copyFrom(table string, columns []string, rows db.RowSource) (int64, error) {
+	if len(columns) == 0 {
+		return 0, fmt.Errorf("db/sqlite bulk insert into %s without columns", table)
+	}
+	batchSize := bulkMaxArgs / len(columns)
+	if batchSize > bulkMaxRows {
+		batchSize = bulkMaxRows
+	}
+
+	count := int64(0)
+	batch := 0
+	insert := db.Insert(table).Columns(columns...)
+	flush := func() error {
+		if batch == 0 {
+			return nil
+		}
+		stmt, args, err := insert.Build(db.SQLite)
+		if err != nil {
+			return err
+		}
+		res, err := q.ExecResult(stmt, args...)
+		if err != nil {
+			return err
+		}
+		count += res.RowsAffected
+		batch = 0
+		insert = db.Insert(table).Columns(columns...)
+		return nil
+	}
+
+	for rows.Next() {
+		values, err := rows.Values()
+		if err != nil {
+			return 0, err
+		}
+		if len(values) != len(columns) {
+			return 0, fmt.Errorf("db/sqlite bulk insert got %d values for %d columns", len(values), len(columns))
+		}
+		insert.Values(values...)
+		batch++
+		if batch == batchSize {
+			if err := flush(); err != nil {
+				return 0, err
+			}
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+	if err := flush(); err != nil {
+		return 0, err
+	}
+	return count, nil
+}
@@ -20,8 +20,13 @@ func queryFromCtx(ctx context.Context, defaultConn *sql.DB) db.Query {
 	if q == nil {
 		return &query{defaultConn, ctx}
 	}
-	if _, ok := q.(*sql.DB); ok {
-		return &query{q.(*sql.DB), ctx}
+	switch v := q.(type) {
+	case *sql.Conn:
+		return &query{v, ctx}
+	case *sql.Tx:
+		return &query{v, ctx}
+	case *sql.DB:
+		return &query{v, ctx}
 	}
 	panic("sqlite database context is not a Query object")
 }
@@ -0,0 +1,85 @@
+package sqlite
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/fdelbos/commons/db"
+)
+
+type (
+	// advisoryLock emulates a postgres advisory lock in the process.
+	advisoryLock struct {
+		readers  int
+		writer   bool
+		released chan struct{} // closed and replaced on every release
+	}
+)
+
+// Lock emulates the postgres advisory locks in the process, the locks are only shared by
+// the users of this SqlConn: two SqlConn never exclude each other, even on the same database.
+//
+// Unlike postgres the locks are not reentrant: locking again in fn a lock it holds fails
+// (unless both are shared), and with db.WithLockWait it blocks until ctx is done.
+func (conn *SqlConn) Lock(ctx context.Context, lockID db.AdvisoryLockID, fn func(ctx context.Context) error, opts ...func(*db.LockOptions)) error {
+	options := db.NewLockOptions(opts...)
+	if err := conn.acquire(ctx, lockID, options); err != nil {
+		return err
+	}
+	defer conn.release(lockID, options)
+
+	if options.Session {
+		return fn(ctx)
+	}
+	return conn.Tx(ctx, fn)
+}
+
+func (conn *SqlConn) acquire(ctx context.Context, lockID db.AdvisoryLockID, options db.LockOptions) error {
+	for {
+		conn.locksMu.Lock()
+		l, ok := conn.locks[lockID]
+		if !ok {
+			l = &advisoryLock{released: make(chan struct{})}
+			conn.locks[lockID] = l
+		}
+		if options.Shared && !l.writer {
+			l.readers++
+			conn.locksMu.Unlock()
+			return nil
+		}
+		if !options.Shared && !l.writer && l.readers == 0 {
+			l.writer = true
+			conn.locksMu.Unlock()
+			return nil
+		}
+		released := l.released
+		conn.locksMu.Unlock()
+
+		if !options.Wait {
+			return db.ErrLockFailed
+		}
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("%w: %w", db.ErrLockFailed, ctx.Err())
+		case <-released:
+		}
+	}
+}
+
+func (conn *SqlConn) release(lockID db.AdvisoryLockID, options db.LockOptions) {
+	conn.locksMu.Lock()
+	defer conn.locksMu.Unlock()
+
+	l := conn.locks[lockID]
+	if options.Shared {
+		l.readers--
+	} else {
+		l.writer = false
+	}
+	close(l.released)
+	if l.readers == 0 && !l.writer {
+		delete(conn.locks, lockID)
+	} else {
+		l.released = make(chan struct{})
+	}
+}
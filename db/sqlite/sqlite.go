@@ -4,40 +4,53 @@ import (
 	"context"
 	"database/sql"
 	"errors"
-	"log"
+	"sync"
 
 	"github.com/fdelbos/commons/db"
 	"github.com/georgysavva/scany/sqlscan"
-	_ "github.com/mattn/go-sqlite3"
 )
 
 type (
 	SqlConn struct {
 		db *sql.DB
+
+		// the advisory locks held on this connection, see Lock
+		locksMu sync.Mutex
+		locks   map[db.AdvisoryLockID]*advisoryLock
+	}
+
+	// sqlInterface is implemented by *sql.DB, *sql.Conn and *sql.Tx.
+	sqlInterface interface {
+		ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+		QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
 	}
 
 	query struct {
-		conn *sql.DB
+		conn sqlInterface
 		ctx  context.Context
 	}
 )
 
 func NewConn(path string) (*SqlConn, error) {
-	db, err := sql.Open("sqlite3", path)
+	sqlDB, err := sql.Open("sqlite3", path)
 	if err != nil {
 		return nil, err
 	}
-	if err := db.Ping(); err != nil {
+	if err := sqlDB.Ping(); err != nil {
 		return nil, err
 	}
 
-	return &SqlConn{db: db}, nil
+	return &SqlConn{db: sqlDB, locks: map[db.AdvisoryLockID]*advisoryLock{}}, nil
 }
 
 func (conn *SqlConn) Query(ctx context.Context) db.Query {
 	return queryFromCtx(ctx, conn.db)
 }
 
+func (conn *SqlConn) Dialect() db.Dialect {
+	return db.SQLite
+}
+
 func (conn *SqlConn) Close() error {
 	if conn.db != nil {
 		return conn.db.Close()
@@ -45,13 +58,12 @@ func (conn *SqlConn) Close() error {
 	return nil
 }
 
-func (conn *SqlConn) Tx(ctx context.Context, fn func(ctx context.Context) error) error {
-	return tx(ctx, conn.db, fn)
+func (conn *SqlConn) Tx(ctx context.Context, fn func(ctx context.Context) error, opts ...func(*db.TxOptions)) error {
+	return tx(ctx, conn.db, fn, opts...)
 }
 
-func (conn *SqlConn) Lock(ctx context.Context, lockID db.AdvisoryLockID, fn func(ctx context.Context) error) error {
-	log.Fatal("sqlite does not support advisory locks!")
-	return nil // never called
+func (conn *SqlConn) Retryable(err error) bool {
+	return Retryable(err)
 }
 
 func (q *query) Exec(query string, arguments ...interface{}) error {
@@ -62,6 +74,25 @@ func (q *query) Exec(query string, arguments ...interface{}) error {
 	return err
 }
 
+func (q *query) ExecResult(query string, arguments ...interface{}) (db.Result, error) {
+	res, err := q.conn.ExecContext(q.ctx, query, arguments...)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return db.Result{}, db.ErrNoRows
+		}
+		return db.Result{}, err
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return db.Result{}, err
+	}
+	lastID, err := res.LastInsertId()
+	if err != nil {
+		return db.Result{}, err
+	}
+	return db.Result{RowsAffected: affected, LastInsertID: lastID}, nil
+}
+
 func (q *query) Select(dest interface{}, query string, args ...interface{}) error {
 	err := sqlscan.Select(q.ctx, q.conn, dest, query, args...)
 	if err != nil && errors.Is(err, sql.ErrNoRows) {
@@ -77,3 +108,51 @@ func (q *query) Get(dest interface{}, query string, args ...interface{}) error {
 	}
 	return err
 }
+
+func (q *query) Each(dest interface{}, query string, args []interface{}, fn func() error) error {
+	rows, err := q.conn.QueryContext(q.ctx, query, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	scanner := sqlscan.NewRowScanner(rows)
+	for rows.Next() {
+		if err := q.ctx.Err(); err != nil {
+			return err
+		}
+		if err := scanner.Scan(dest); err != nil {
+			return err
+		}
+		if err := fn(); err != nil {
+			if errors.Is(err, db.ErrStop) {
+				return nil
+			}
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// SendBatch executes the queries sequentially, sqlite has no network round trip to save.
+func (q *query) SendBatch(batch *db.Batch) error {
+	for _, bq := range batch.Queries() {
+		var err error
+		switch bq.Kind {
+		case db.BatchExec:
+			var res db.Result
+			res, err = q.ExecResult(bq.SQL, bq.Args...)
+			if err == nil && bq.Result != nil {
+				*bq.Result = res
+			}
+		case db.BatchGet:
+			err = q.Get(bq.Dest, bq.SQL, bq.Args...)
+		case db.BatchSelect:
+			err = q.Select(bq.Dest, bq.SQL, bq.Args...)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
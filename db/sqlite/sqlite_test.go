@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 	"os"
+	"sync"
 	"testing"
 	"time"
 
@@ -69,3 +70,503 @@ func TestHSQLITE(t *testing.T) {
 	assert.Equal(t, 11, len(dests))
 
 }
+
+// newTestConn returns a connection to a new migrated database.
+func newTestConn(t *testing.T) *sqlite.SqlConn {
+	dname, err := os.MkdirTemp("", "")
+	assert.NoError(t, err)
+	t.Cleanup(func() { os.RemoveAll(dname) })
+
+	dbURL := fmt.Sprintf("%s/db.sqlite3", dname)
+	conn, err := sqlite.NewConn(dbURL)
+	assert.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+
+	err = sqlite.Migrate(dbURL, os.DirFS("test_migrations/."))
+	assert.NoError(t, err)
+	return conn
+}
+
+func TestExecResult(t *testing.T) {
+	conn := newTestConn(t)
+	ctx := context.Background()
+
+	for i := 1; i <= 3; i++ {
+		res, err := conn.Query(ctx).ExecResult("insert into the_table (created_at, msg) values ($1, $2)", "2021-01-01", "hello")
+		assert.NoError(t, err)
+		assert.Equal(t, int64(1), res.RowsAffected)
+		assert.Equal(t, int64(i), res.LastInsertID)
+	}
+
+	res, err := conn.Query(ctx).ExecResult("update the_table set msg = $1", "updated")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(3), res.RowsAffected)
+
+	// optimistic update
+	err = db.Affected(conn.Query(ctx).ExecResult("update the_table set msg = $1 where id = $2 and msg = $3", "again", 1, "updated"))
+	assert.NoError(t, err)
+	err = db.Affected(conn.Query(ctx).ExecResult("update the_table set msg = $1 where id = $2 and msg = $3", "again", 1, "updated"))
+	assert.ErrorIs(t, err, db.ErrNotAffected)
+
+	res, err = db.Delete("the_table").Where(db.Gt("id", 1)).ExecResult(ctx, conn)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2), res.RowsAffected)
+
+	_, err = conn.Query(ctx).ExecResult("update nowhere set msg = $1", "x")
+	assert.Error(t, err)
+	assert.Error(t, db.Affected(db.Result{RowsAffected: 1}, err))
+}
+
+func TestEach(t *testing.T) {
+	conn := newTestConn(t)
+	ctx := context.Background()
+
+	for i := 0; i < 10; i++ {
+		err := conn.Query(ctx).Exec("insert into the_table (created_at, msg) values ($1, $2)", "2021-01-01", fmt.Sprintf("msg %d", i))
+		assert.NoError(t, err)
+	}
+
+	// all the rows
+	row := TheTable{}
+	ids := []int{}
+	err := conn.Query(ctx).Each(&row, "select * from the_table where id > $1 order by id", []any{2}, func() error {
+		ids = append(ids, row.ID)
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []int{3, 4, 5, 6, 7, 8, 9, 10}, ids)
+
+	// early exit
+	count := 0
+	err = db.Select().From("the_table").Each(ctx, conn, &row, func() error {
+		count++
+		if count == 3 {
+			return db.ErrStop
+		}
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, 3, count)
+
+	errFailed := fmt.Errorf("failed")
+	err = conn.Query(ctx).Each(&row, "select * from the_table", nil, func() error {
+		return errFailed
+	})
+	assert.ErrorIs(t, err, errFailed)
+
+	// the connection is still usable in a transaction after an early exit
+	err = conn.Tx(ctx, func(ctx context.Context) error {
+		return conn.Query(ctx).Each(&row, "select * from the_table", nil, func() error {
+			return db.ErrStop
+		})
+	})
+	assert.NoError(t, err)
+
+	// canceled context
+	cancelCtx, cancel := context.WithCancel(ctx)
+	count = 0
+	err = conn.Query(cancelCtx).Each(&row, "select * from the_table", nil, func() error {
+		count++
+		cancel()
+		return nil
+	})
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.Equal(t, 1, count)
+}
+
+func TestCopyFrom(t *testing.T) {
+	conn := newTestConn(t)
+	ctx := context.Background()
+
+	columns := []string{"created_at", "msg"}
+	n, err := conn.Query(ctx).CopyFrom("the_table", columns, db.RowsOf([][]any{
+		{"2021-01-01", "first"},
+		{"2021-01-02", "second"},
+	}))
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2), n)
+
+	// the rows are rolled back with the transaction
+	errRollback := fmt.Errorf("rollback")
+	err = conn.Tx(ctx, func(ctx context.Context) error {
+		i := 0
+		n, err := conn.Query(ctx).CopyFrom("the_table", columns, db.RowFunc(func() ([]any, error) {
+			if i == 5 {
+				return nil, nil
+			}
+			i++
+			return []any{"2021-01-01", fmt.Sprintf("msg %d", i)}, nil
+		}))
+		assert.NoError(t, err)
+		assert.Equal(t, int64(5), n)
+		return errRollback
+	})
+	assert.ErrorIs(t, err, errRollback)
+
+	count := 0
+	assert.NoError(t, conn.Query(ctx).Get(&count, "select count(*) from the_table"))
+	assert.Equal(t, 2, count)
+
+	// a failing source inserts nothing
+	errSource := fmt.Errorf("source failed")
+	i := 0
+	_, err = conn.Query(ctx).CopyFrom("the_table", columns, db.RowFunc(func() ([]any, error) {
+		if i == 3 {
+			return nil, errSource
+		}
+		i++
+		return []any{"2021-01-01", "msg"}, nil
+	}))
+	assert.ErrorIs(t, err, errSource)
+	assert.NoError(t, conn.Query(ctx).Get(&count, "select count(*) from the_table"))
+	assert.Equal(t, 2, count)
+
+	_, err = conn.Query(ctx).CopyFrom("the_table", columns, db.RowsOf([][]any{{"2021-01-01"}}))
+	assert.Error(t, err)
+}
+
+func TestSendBatch(t *testing.T) {
+	conn := newTestConn(t)
+	ctx := context.Background()
+
+	res := db.Result{}
+	first := TheTable{}
+	all := []TheTable{}
+	count := 0
+	batch := db.NewBatch().
+		Exec("insert into the_table (created_at, msg) values ($1, $2)", "2021-01-01", "first").
+		ExecResult(&res, "insert into the_table (created_at, msg) values ($1, $2)", "2021-01-02", "second").
+		Get(&first, "select * from the_table where id = $1", 1).
+		Select(&all, "select * from the_table order by id").
+		Get(&count, "select count(*) from the_table")
+	assert.Equal(t, 5, batch.Len())
+
+	assert.NoError(t, conn.Query(ctx).SendBatch(batch))
+	assert.Equal(t, int64(1), res.RowsAffected)
+	assert.Equal(t, "first", first.Msg)
+	assert.Len(t, all, 2)
+	assert.Equal(t, 2, count)
+
+	err := conn.Query(ctx).SendBatch(db.NewBatch().Get(&first, "select * from the_table where id = $1", 999))
+	assert.ErrorIs(t, err, db.ErrNoRows)
+	assert.NoError(t, conn.Query(ctx).SendBatch(db.NewBatch()))
+}
+
+func TestNestedTx(t *testing.T) {
+	conn := newTestConn(t)
+	ctx := context.Background()
+
+	insert := func(ctx context.Context, msg string) error {
+		return conn.Query(ctx).Exec("insert into the_table (created_at, msg) values ($1, $2)", "2021-01-01", msg)
+	}
+
+	errInner := fmt.Errorf("inner failed")
+	err := conn.Tx(ctx, func(ctx context.Context) error {
+		assert.NoError(t, insert(ctx, "outer"))
+
+		// the failed savepoint only rolls back its own changes
+		err := conn.Tx(ctx, func(ctx context.Context) error {
+			assert.NoError(t, insert(ctx, "rolled back"))
+			return errInner
+		})
+		assert.ErrorIs(t, err, errInner)
+
+		// savepoints can be nested
+		return conn.Tx(ctx, func(ctx context.Context) error {
+			assert.NoError(t, insert(ctx, "nested"))
+			return conn.Tx(ctx, func(ctx context.Context) error {
+				return insert(ctx, "nested twice")
+			})
+		})
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"outer", "nested", "nested twice"}, messages(ctx, t, conn))
+
+	// a joined transaction is rolled back with the outer one
+	err = conn.Tx(ctx, func(ctx context.Context) error {
+		err := conn.Tx(ctx, func(ctx context.Context) error {
+			return insert(ctx, "joined")
+		}, db.WithPropagation(db.TxJoin))
+		assert.NoError(t, err)
+		assert.Contains(t, messages(ctx, t, conn), "joined")
+		return errInner
+	})
+	assert.ErrorIs(t, err, errInner)
+	assert.Equal(t, []string{"outer", "nested", "nested twice"}, messages(ctx, t, conn))
+}
+
+// messages returns the messages of the_table, in their insertion order.
+func messages(ctx context.Context, t *testing.T, conn *sqlite.SqlConn) []string {
+	res := []string{}
+	assert.NoError(t, conn.Query(ctx).Select(&res, "select msg from the_table order by id"))
+	return res
+}
+
+func TestTxPanic(t *testing.T) {
+	conn := newTestConn(t)
+	ctx := context.Background()
+
+	insert := func(ctx context.Context, msg string) error {
+		return conn.Query(ctx).Exec("insert into the_table (created_at, msg) values ($1, $2)", "2021-01-01", msg)
+	}
+
+	assert.PanicsWithValue(t, "boom", func() {
+		conn.Tx(ctx, func(ctx context.Context) error {
+			assert.NoError(t, insert(ctx, "panicked"))
+			panic("boom")
+		})
+	})
+
+	// the connection went back to the pool without the transaction
+	err := conn.Tx(ctx, func(ctx context.Context) error {
+		assert.NoError(t, insert(ctx, "outer"))
+		assert.PanicsWithValue(t, "boom", func() {
+			conn.Tx(ctx, func(ctx context.Context) error {
+				assert.NoError(t, insert(ctx, "panicked savepoint"))
+				panic("boom")
+			})
+		})
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"outer"}, messages(ctx, t, conn))
+}
+
+func TestTxOptions(t *testing.T) {
+	conn := newTestConn(t)
+	ctx := context.Background()
+
+	insert := func(ctx context.Context) error {
+		return conn.Query(ctx).Exec("insert into the_table (created_at, msg) values ($1, $2)", "2021-01-01", "hello")
+	}
+
+	err := conn.Tx(ctx, insert, db.WithReadOnly())
+	assert.Error(t, err)
+
+	count := 0
+	err = conn.Tx(ctx, func(ctx context.Context) error {
+		return conn.Query(ctx).Get(&count, "select count(*) from the_table")
+	}, db.WithReadOnly())
+	assert.NoError(t, err)
+	assert.Equal(t, 0, count)
+
+	// the connection is writable again
+	assert.NoError(t, insert(ctx))
+
+	for _, mode := range []db.LockMode{db.TxDeferred, db.TxImmediate, db.TxExclusive} {
+		assert.NoError(t, conn.Tx(ctx, insert, db.WithLockMode(mode)))
+	}
+	assert.NoError(t, conn.Query(ctx).Get(&count, "select count(*) from the_table"))
+	assert.Equal(t, 4, count)
+}
+
+func TestRetryTx(t *testing.T) {
+	dname, err := os.MkdirTemp("", "")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dname)
+
+	dbURL := fmt.Sprintf("%s/db.sqlite3", dname)
+	assert.NoError(t, sqlite.Migrate(dbURL, os.DirFS("test_migrations/.")))
+
+	locker, err := sqlite.NewConn(dbURL)
+	assert.NoError(t, err)
+	defer locker.Close()
+	conn, err := sqlite.NewConn(dbURL + "?_busy_timeout=0")
+	assert.NoError(t, err)
+	defer conn.Close()
+
+	ctx := context.Background()
+	insert := func(ctx context.Context) error {
+		return conn.Query(ctx).Exec("insert into the_table (created_at, msg) values ($1, $2)", "2021-01-01", "hello")
+	}
+
+	// locker holds the write lock until released
+	locked := make(chan struct{})
+	release := make(chan struct{})
+	wg := sync.WaitGroup{}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		err := locker.Tx(ctx, func(ctx context.Context) error {
+			close(locked)
+			<-release
+			return nil
+		}, db.WithLockMode(db.TxImmediate))
+		assert.NoError(t, err)
+	}()
+	<-locked
+
+	err = conn.Tx(ctx, insert, db.WithLockMode(db.TxImmediate))
+	assert.Error(t, err)
+	assert.True(t, conn.Retryable(err))
+
+	time.AfterFunc(50*time.Millisecond, func() { close(release) })
+	err = db.RetryTx(ctx, conn, insert,
+		db.WithLockMode(db.TxImmediate),
+		db.WithTxRetries(50, 5*time.Millisecond, 20*time.Millisecond))
+	assert.NoError(t, err)
+	wg.Wait()
+
+	count := 0
+	assert.NoError(t, conn.Query(ctx).Get(&count, "select count(*) from the_table"))
+	assert.Equal(t, 1, count)
+
+	// other errors are not retried
+	calls := 0
+	errFailed := fmt.Errorf("failed")
+	err = db.RetryTx(ctx, conn, func(ctx context.Context) error {
+		calls++
+		return errFailed
+	})
+	assert.ErrorIs(t, err, errFailed)
+	assert.Equal(t, 1, calls)
+	assert.False(t, conn.Retryable(errFailed))
+}
+
+func TestTxHooks(t *testing.T) {
+	conn := newTestConn(t)
+	ctx := context.Background()
+
+	events := []string{}
+	record := func(event string) func(context.Context) {
+		return func(context.Context) { events = append(events, event) }
+	}
+
+	// no transaction
+	db.AfterCommit(ctx, record("no tx commit"))
+	db.AfterRollback(ctx, record("no tx rollback"))
+	assert.Equal(t, []string{"no tx commit"}, events)
+
+	events = []string{}
+	errFailed := fmt.Errorf("failed")
+	err := conn.Tx(ctx, func(ctx context.Context) error {
+		db.AfterCommit(ctx, record("commit"))
+		db.AfterRollback(ctx, record("rollback"))
+
+		// committed savepoint, its hooks wait for the outer transaction
+		assert.NoError(t, conn.Tx(ctx, func(ctx context.Context) error {
+			db.AfterCommit(ctx, record("savepoint commit"))
+			return nil
+		}))
+
+		// rolled back savepoint
+		err := conn.Tx(ctx, func(ctx context.Context) error {
+			db.AfterCommit(ctx, record("failed savepoint commit"))
+			db.AfterRollback(ctx, record("failed savepoint rollback"))
+			return errFailed
+		})
+		assert.ErrorIs(t, err, errFailed)
+
+		// joined transaction
+		assert.NoError(t, conn.Tx(ctx, func(ctx context.Context) error {
+			db.AfterCommit(ctx, record("joined commit"))
+			return nil
+		}, db.WithPropagation(db.TxJoin)))
+
+		assert.Equal(t, []string{"failed savepoint rollback"}, events)
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"failed savepoint rollback", "commit", "savepoint commit", "joined commit"}, events)
+
+	events = []string{}
+	err = conn.Tx(ctx, func(ctx context.Context) error {
+		db.AfterCommit(ctx, record("commit"))
+		db.AfterRollback(ctx, record("rollback"))
+		assert.NoError(t, conn.Tx(ctx, func(ctx context.Context) error {
+			db.AfterRollback(ctx, record("savepoint rollback"))
+			return nil
+		}))
+		return errFailed
+	})
+	assert.ErrorIs(t, err, errFailed)
+	assert.Equal(t, []string{"rollback", "savepoint rollback"}, events)
+}
+
+func TestLock(t *testing.T) {
+	conn := newTestConn(t)
+	ctx := context.Background()
+	lockID := db.LockKey("test")
+	assert.Equal(t, lockID, db.LockKey("test"))
+	assert.NotEqual(t, lockID, db.LockKey("other"))
+
+	insert := func(ctx context.Context) error {
+		return conn.Query(ctx).Exec("insert into the_table (created_at, msg) values ($1, $2)", "2021-01-01", "hello")
+	}
+	count := func() int {
+		res := 0
+		assert.NoError(t, conn.Query(ctx).Get(&res, "select count(*) from the_table"))
+		return res
+	}
+
+	// an exclusive lock excludes all the other locks
+	err := conn.Lock(ctx, lockID, func(ctx context.Context) error {
+		assert.ErrorIs(t, conn.Lock(ctx, lockID, insert), db.ErrLockFailed)
+		assert.ErrorIs(t, conn.Lock(ctx, lockID, insert, db.WithSharedLock()), db.ErrLockFailed)
+		assert.NoError(t, conn.Lock(ctx, lockID+1, func(ctx context.Context) error { return nil }))
+
+		timeoutCtx, cancel := context.WithTimeout(ctx, 20*time.Millisecond)
+		defer cancel()
+		err := conn.Lock(timeoutCtx, lockID, insert, db.WithLockWait())
+		assert.ErrorIs(t, err, db.ErrLockFailed)
+		assert.ErrorIs(t, err, context.DeadlineExceeded)
+		return nil
+	})
+	assert.NoError(t, err)
+
+	// shared locks can be held together
+	err = conn.Lock(ctx, lockID, func(ctx context.Context) error {
+		assert.ErrorIs(t, conn.Lock(ctx, lockID, insert), db.ErrLockFailed)
+		return conn.Lock(context.Background(), lockID, insert, db.WithSharedLock(), db.WithSessionLock())
+	}, db.WithSharedLock(), db.WithSessionLock())
+	assert.NoError(t, err)
+	assert.Equal(t, 1, count())
+
+	// waiting for the release
+	locked := make(chan struct{})
+	wg := sync.WaitGroup{}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		err := conn.Lock(ctx, lockID, func(ctx context.Context) error {
+			close(locked)
+			time.Sleep(20 * time.Millisecond)
+			return nil
+		}, db.WithSessionLock())
+		assert.NoError(t, err)
+	}()
+	<-locked
+	assert.NoError(t, conn.Lock(ctx, lockID, insert, db.WithLockWait()))
+	wg.Wait()
+	assert.Equal(t, 2, count())
+
+	// the function runs in a transaction, unless the lock is a session lock
+	errFailed := fmt.Errorf("failed")
+	fail := func(ctx context.Context) error {
+		assert.NoError(t, insert(ctx))
+		return errFailed
+	}
+	assert.ErrorIs(t, conn.Lock(ctx, lockID, fail), errFailed)
+	assert.Equal(t, 2, count())
+	assert.ErrorIs(t, conn.Lock(ctx, lockID, fail, db.WithSessionLock()), errFailed)
+	assert.Equal(t, 3, count())
+}
+
+func TestLockByConn(t *testing.T) {
+	ctx := context.Background()
+	lockID := db.LockKey("test")
+
+	first, err := sqlite.NewConn(":memory:")
+	assert.NoError(t, err)
+	defer first.Close()
+	second, err := sqlite.NewConn(":memory:")
+	assert.NoError(t, err)
+	defer second.Close()
+
+	// the in memory databases are distinct, so are their locks
+	err = first.Lock(ctx, lockID, func(ctx context.Context) error {
+		assert.ErrorIs(t, first.Lock(ctx, lockID, func(ctx context.Context) error { return nil }), db.ErrLockFailed)
+		return second.Lock(ctx, lockID, func(ctx context.Context) error { return nil }, db.WithSessionLock())
+	}, db.WithSessionLock())
+	assert.NoError(t, err)
+}
@@ -3,45 +3,127 @@ package sqlite
 import (
 	"context"
 	"database/sql"
+	"errors"
+	"fmt"
 	"log"
+	"sync/atomic"
+
+	"github.com/fdelbos/commons/db"
+	"github.com/mattn/go-sqlite3"
 )
 
 type (
 	txBeginner interface {
-		Begin() (*sql.Tx, error)
+		Conn(ctx context.Context) (*sql.Conn, error)
 	}
 )
 
-func tx(ctx context.Context, conn txBeginner, fn func(ctx context.Context) error) error {
+var (
+	// used to give a unique name to the savepoints
+	savepoints atomic.Int64
+)
+
+// tx runs fn in a transaction on a dedicated connection, as database/sql
+// can't begin IMMEDIATE or EXCLUSIVE transactions.
+func tx(ctx context.Context, conn txBeginner, fn func(ctx context.Context) error, opts ...func(*db.TxOptions)) error {
+	options := db.NewTxOptions(opts...)
 
-	if q := ctx.Value(sqlCtx); q != nil {
-		if _, ok := q.(*sql.Tx); ok {
-			log.Fatal("database context is already in a transaction")
+	if outer, ok := ctx.Value(sqlCtx).(*sql.Conn); ok {
+		if options.Propagation == db.TxJoin {
+			return fn(ctx)
 		}
+		return savepoint(ctx, outer, fn)
 	}
 
-	tx, err := conn.Begin()
+	c, err := conn.Conn(ctx)
 	if err != nil {
+		log.Printf("cant obtain a connection to the database: %v", err)
+		return err
+	}
+	defer c.Close()
+
+	// the connection is reset even if ctx is canceled
+	resetCtx := context.Background()
+	if options.ReadOnly {
+		if _, err := c.ExecContext(ctx, "PRAGMA query_only = ON"); err != nil {
+			return err
+		}
+		defer func() {
+			if _, err := c.ExecContext(resetCtx, "PRAGMA query_only = OFF"); err != nil {
+				log.Printf("error while resetting the read only mode: %v", err)
+			}
+		}()
+	}
+
+	begin := "BEGIN"
+	if options.LockMode != "" {
+		begin += " " + string(options.LockMode)
+	}
+	if _, err := c.ExecContext(ctx, begin); err != nil {
 		log.Printf("cant obtain transaction on the database: %v", err)
 		return err
 	}
 
+	// rolled back when fn fails, or panics: the connection goes back to the pool
 	closed := false
+	hooksCtx, hooks := db.WithTxHooks(ctx)
 	defer func() {
 		if !closed {
-			if err := tx.Rollback(); err != nil {
+			if _, err := c.ExecContext(resetCtx, "ROLLBACK"); err != nil {
 				log.Printf("error while rolling back: %v", err)
 			}
+			hooks.RolledBack(ctx)
 		}
 	}()
 
-	fnCtx := context.WithValue(ctx, sqlCtx, tx)
+	fnCtx := context.WithValue(hooksCtx, sqlCtx, c)
 	if err := fn(fnCtx); err != nil {
 		return err
-	} else if err := tx.Commit(); err != nil {
+	} else if _, err := c.ExecContext(resetCtx, "COMMIT"); err != nil {
+		return err
+	}
+	closed = true
+	hooks.Committed(ctx)
+	return nil
+}
+
+// savepoint runs fn in a savepoint of tx, only the changes of fn are rolled back on error.
+func savepoint(ctx context.Context, tx sqlInterface, fn func(ctx context.Context) error) error {
+	name := fmt.Sprintf("sp_%d", savepoints.Add(1))
+	if _, err := tx.ExecContext(ctx, "SAVEPOINT "+name); err != nil {
 		return err
-	} else {
-		closed = true
-		return nil
 	}
+
+	closed := false
+	hooksCtx, hooks := db.WithTxHooks(ctx)
+	defer func() {
+		if !closed {
+			// ROLLBACK TO keeps the savepoint, it must also be released
+			if _, err := tx.ExecContext(context.Background(), "ROLLBACK TO SAVEPOINT "+name); err != nil {
+				log.Printf("error while rolling back to savepoint: %v", err)
+			} else if _, err := tx.ExecContext(context.Background(), "RELEASE SAVEPOINT "+name); err != nil {
+				log.Printf("error while releasing savepoint: %v", err)
+			}
+			hooks.RolledBack(ctx)
+		}
+	}()
+
+	if err := fn(hooksCtx); err != nil {
+		return err
+	} else if _, err := tx.ExecContext(ctx, "RELEASE SAVEPOINT "+name); err != nil {
+		return err
+	}
+	closed = true
+	hooks.Committed(ctx)
+	return nil
+}
+
+// Retryable returns true when err is caused by a busy or locked database,
+// so the transaction can be run again, see db.RetryTx.
+func Retryable(err error) bool {
+	sqliteErr := sqlite3.Error{}
+	if !errors.As(err, &sqliteErr) {
+		return false
+	}
+	return sqliteErr.Code == sqlite3.ErrBusy || sqliteErr.Code == sqlite3.ErrLocked
 }
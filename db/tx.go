@@ -0,0 +1,152 @@
+package db
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/fdelbos/commons/utils"
+)
+
+type (
+	// Propagation defines how DB.Tx behaves when the context is already in a transaction.
+	Propagation int
+
+	// IsolationLevel is the isolation level of a postgres transaction.
+	IsolationLevel string
+
+	// LockMode is how a sqlite transaction acquires the database lock.
+	LockMode string
+
+	// TxOptions are the options of DB.Tx and RetryTx.
+	// Only the propagation applies to a nested transaction.
+	TxOptions struct {
+		Propagation Propagation
+		// Isolation is the isolation level on postgres, the database default when empty.
+		// The sqlite transactions are always serializable.
+		Isolation IsolationLevel
+		ReadOnly  bool
+		// Deferrable is for postgres serializable read only transactions.
+		Deferrable bool
+		// LockMode is for sqlite, TxDeferred when empty.
+		LockMode LockMode
+
+		// MaxAttempts, MinBackoff and MaxBackoff are used by RetryTx.
+		MaxAttempts int
+		MinBackoff  time.Duration
+		MaxBackoff  time.Duration
+	}
+)
+
+const (
+	// TxSavepoint runs a nested transaction in a SAVEPOINT, so an error
+	// only rolls back the changes of the nested transaction. It's the default.
+	TxSavepoint Propagation = iota
+	// TxJoin runs a nested transaction in the outer transaction,
+	// an error is returned to the outer transaction without any rollback.
+	TxJoin
+)
+
+const (
+	ReadUncommitted IsolationLevel = "read uncommitted"
+	ReadCommitted   IsolationLevel = "read committed"
+	RepeatableRead  IsolationLevel = "repeatable read"
+	Serializable    IsolationLevel = "serializable"
+
+	TxDeferred  LockMode = "DEFERRED"  // the lock is acquired by the first read or write
+	TxImmediate LockMode = "IMMEDIATE" // the write lock is acquired when the transaction begins
+	TxExclusive LockMode = "EXCLUSIVE" // no other connection can read or write
+
+	DefaultTxMaxAttempts = 5
+	DefaultTxMinBackoff  = 10 * time.Millisecond
+	DefaultTxMaxBackoff  = time.Second
+)
+
+// NewTxOptions applies the options to the default TxOptions.
+func NewTxOptions(opts ...func(*TxOptions)) TxOptions {
+	options := TxOptions{
+		Propagation: TxSavepoint,
+		MaxAttempts: DefaultTxMaxAttempts,
+		MinBackoff:  DefaultTxMinBackoff,
+		MaxBackoff:  DefaultTxMaxBackoff,
+	}
+	for _, opt := range opts {
+		opt(&options)
+	}
+	return options
+}
+
+// RetryTx runs fn in a transaction with DB.Tx, and runs it again in a new transaction when
+// it fails with a transient error (ie: a serialization failure or a deadlock on postgres, or
+// a busy database on sqlite). The database must implement a Retryable(error) bool method,
+// otherwise the transaction is never retried.
+//
+// fn may run several times, it must not have side effects out of the transaction.
+// RetryTx must not be used in a transaction, only the outermost transaction can be retried.
+func RetryTx(ctx context.Context, database DB, fn func(ctx context.Context) error, opts ...func(*TxOptions)) error {
+	options := NewTxOptions(opts...)
+	retryable, ok := database.(interface{ Retryable(error) bool })
+
+	for attempt := 1; ; attempt++ {
+		err := database.Tx(ctx, fn, opts...)
+		if err == nil || !ok || !retryable.Retryable(err) || attempt >= options.MaxAttempts {
+			return err
+		}
+
+		delay := utils.Jitter(utils.Backoff(attempt, options.MinBackoff, options.MaxBackoff), 0.2)
+		log.Printf("db transaction failed, retrying in %s: %v", delay, err)
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+// WithPropagation sets how a nested transaction uses the outer one. Default is TxSavepoint.
+func WithPropagation(propagation Propagation) func(*TxOptions) {
+	return func(o *TxOptions) {
+		o.Propagation = propagation
+	}
+}
+
+// WithIsolation sets the isolation level of a postgres transaction.
+func WithIsolation(level IsolationLevel) func(*TxOptions) {
+	return func(o *TxOptions) {
+		o.Isolation = level
+	}
+}
+
+// WithReadOnly makes the transaction read only.
+func WithReadOnly() func(*TxOptions) {
+	return func(o *TxOptions) {
+		o.ReadOnly = true
+	}
+}
+
+// WithDeferrable makes a postgres serializable read only transaction deferrable:
+// it may wait when it begins, but then runs without serialization failures.
+func WithDeferrable() func(*TxOptions) {
+	return func(o *TxOptions) {
+		o.Deferrable = true
+	}
+}
+
+// WithLockMode sets how a sqlite transaction acquires the database lock. Default is TxDeferred.
+func WithLockMode(mode LockMode) func(*TxOptions) {
+	return func(o *TxOptions) {
+		o.LockMode = mode
+	}
+}
+
+// WithTxRetries sets the maximum number of attempts of RetryTx and the backoff between attempts.
+// Defaults are 5 attempts, with a backoff from 10 milliseconds to 1 second.
+func WithTxRetries(maxAttempts int, minBackoff, maxBackoff time.Duration) func(*TxOptions) {
+	return func(o *TxOptions) {
+		o.MaxAttempts = maxAttempts
+		o.MinBackoff = minBackoff
+		o.MaxBackoff = maxBackoff
+	}
+}
@@ -0,0 +1,153 @@
+package email
+
+import (
+	"bufio"
+	"encoding/base64"
+	"errors"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/mail"
+	"net/textproto"
+	"strings"
+)
+
+type (
+	// Bounce is a delivery status notification (RFC 3464) or, when Complaint
+	// is true, an abuse report (RFC 5965).
+	Bounce struct {
+		Complaint  bool
+		Recipients []BounceRecipient
+	}
+
+	// BounceRecipient is the status of a recipient in a Bounce.
+	BounceRecipient struct {
+		Email      string
+		Action     string // failed, delayed, delivered, relayed or expanded
+		Status     string // the enhanced status code, ie: 5.1.1
+		Diagnostic string // the reply of the remote server, or the feedback type of a complaint
+	}
+)
+
+// ErrNotBounce is returned when a message is not a delivery or abuse report.
+var ErrNotBounce = errors.New("the message is not a bounce")
+
+// ParseBounce parses a raw bounce message, a multipart/report with a
+// message/delivery-status or message/feedback-report part.
+func ParseBounce(r io.Reader) (*Bounce, error) {
+	msg, err := mail.ReadMessage(r)
+	if err != nil {
+		return nil, err
+	}
+	mediaType, params, err := mime.ParseMediaType(msg.Header.Get("Content-Type"))
+	if err != nil || mediaType != "multipart/report" {
+		return nil, ErrNotBounce
+	}
+
+	reader := multipart.NewReader(msg.Body, params["boundary"])
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			return nil, ErrNotBounce
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		var body io.Reader = part
+		if strings.EqualFold(part.Header.Get("Content-Transfer-Encoding"), "base64") {
+			body = base64.NewDecoder(base64.StdEncoding, part)
+		}
+
+		partType, _, _ := mime.ParseMediaType(part.Header.Get("Content-Type"))
+		switch partType {
+		case "message/delivery-status":
+			return parseDeliveryStatus(body)
+		case "message/feedback-report":
+			return parseFeedbackReport(body)
+		}
+	}
+}
+
+// parseDeliveryStatus parses the per-message fields followed by the per-recipient fields.
+func parseDeliveryStatus(r io.Reader) (*Bounce, error) {
+	groups, err := readFieldGroups(r)
+	if err != nil {
+		return nil, err
+	}
+
+	bounce := &Bounce{}
+	for _, fields := range groups {
+		recipient := fields.Get("Final-Recipient")
+		if recipient == "" {
+			recipient = fields.Get("Original-Recipient")
+		}
+		if recipient == "" {
+			continue
+		}
+		bounce.Recipients = append(bounce.Recipients, BounceRecipient{
+			Email:      typedValue(recipient),
+			Action:     strings.ToLower(strings.TrimSpace(fields.Get("Action"))),
+			Status:     strings.TrimSpace(fields.Get("Status")),
+			Diagnostic: typedValue(fields.Get("Diagnostic-Code")),
+		})
+	}
+	if len(bounce.Recipients) == 0 {
+		return nil, ErrNotBounce
+	}
+	return bounce, nil
+}
+
+func parseFeedbackReport(r io.Reader) (*Bounce, error) {
+	groups, err := readFieldGroups(r)
+	if err != nil {
+		return nil, err
+	}
+
+	bounce := &Bounce{Complaint: true}
+	for _, fields := range groups {
+		feedbackType := strings.ToLower(strings.TrimSpace(fields.Get("Feedback-Type")))
+		for _, rcpt := range fields.Values("Original-Rcpt-To") {
+			bounce.Recipients = append(bounce.Recipients, BounceRecipient{
+				Email:      strings.Trim(strings.TrimSpace(rcpt), "<>"),
+				Action:     "failed",
+				Diagnostic: feedbackType,
+			})
+		}
+	}
+	if len(bounce.Recipients) == 0 {
+		return nil, ErrNotBounce
+	}
+	return bounce, nil
+}
+
+// readFieldGroups reads the groups of header fields separated by empty lines.
+func readFieldGroups(r io.Reader) ([]textproto.MIMEHeader, error) {
+	reader := textproto.NewReader(bufio.NewReader(r))
+	groups := []textproto.MIMEHeader{}
+	for {
+		fields, err := reader.ReadMIMEHeader()
+		if len(fields) > 0 {
+			groups = append(groups, fields)
+		}
+		if err == io.EOF {
+			return groups, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
+// typedValue returns the value of a "type; value" field, ie: "rfc822; bob@example.com".
+func typedValue(field string) string {
+	if _, value, found := strings.Cut(field, ";"); found {
+		field = value
+	}
+	return strings.Trim(strings.TrimSpace(field), "<>")
+}
+
+// Permanent returns true if the delivery to the recipient failed permanently.
+func (r BounceRecipient) Permanent() bool {
+	return r.Action == "failed" && strings.HasPrefix(r.Status, "5")
+}
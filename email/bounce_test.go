@@ -0,0 +1,118 @@
+package email_test
+
+import (
+	"strings"
+	"testing"
+
+	. "github.com/fdelbos/commons/email"
+	"github.com/stretchr/testify/assert"
+)
+
+const dsnBounce = `From: Mail Delivery System <MAILER-DAEMON@mx.example.com>
+To: noreply@example.com
+Subject: Undelivered Mail Returned to Sender
+MIME-Version: 1.0
+Content-Type: multipart/report; report-type=delivery-status;
+	boundary="BOUNDARY"
+
+--BOUNDARY
+Content-Type: text/plain; charset=us-ascii
+
+I'm sorry to have to inform you that your message could not
+be delivered to one or more recipients.
+
+--BOUNDARY
+Content-Type: message/delivery-status
+
+Reporting-MTA: dns; mx.example.com
+Arrival-Date: Mon, 19 Oct 2026 10:00:00 +0200
+
+Final-Recipient: rfc822; Bob@Example.com
+Original-Recipient: rfc822;bob@example.com
+Action: failed
+Status: 5.1.1
+Diagnostic-Code: smtp; 550 5.1.1 <bob@example.com>:
+	Recipient address rejected: User unknown
+
+Final-Recipient: rfc822; carol@example.com
+Action: delayed
+Status: 4.4.1
+Diagnostic-Code: smtp; 451 4.4.1 try again later
+
+--BOUNDARY
+Content-Type: text/rfc822-headers
+
+From: noreply@example.com
+To: bob@example.com
+Subject: hello
+
+--BOUNDARY--
+`
+
+const arfComplaint = `From: complaints@isp.example.net
+To: abuse@example.com
+Subject: FW: hello
+MIME-Version: 1.0
+Content-Type: multipart/report; report-type=feedback-report;
+	boundary="BOUNDARY"
+
+--BOUNDARY
+Content-Type: text/plain; charset="US-ASCII"
+
+This is an email abuse report.
+
+--BOUNDARY
+Content-Type: message/feedback-report
+
+Feedback-Type: abuse
+User-Agent: SomeGenerator/1.0
+Version: 1
+Original-Rcpt-To: <dave@isp.example.net>
+
+--BOUNDARY
+Content-Type: message/rfc822
+
+From: noreply@example.com
+To: dave@isp.example.net
+Subject: hello
+
+hello
+--BOUNDARY--
+`
+
+func TestParseBounce(t *testing.T) {
+	bounce, err := ParseBounce(strings.NewReader(dsnBounce))
+	assert.NoError(t, err)
+	assert.False(t, bounce.Complaint)
+	assert.Len(t, bounce.Recipients, 2)
+
+	bob := bounce.Recipients[0]
+	assert.Equal(t, "Bob@Example.com", bob.Email)
+	assert.Equal(t, "failed", bob.Action)
+	assert.Equal(t, "5.1.1", bob.Status)
+	assert.Equal(t, "550 5.1.1 <bob@example.com>: Recipient address rejected: User unknown", bob.Diagnostic)
+	assert.True(t, bob.Permanent())
+
+	carol := bounce.Recipients[1]
+	assert.Equal(t, "carol@example.com", carol.Email)
+	assert.Equal(t, "delayed", carol.Action)
+	assert.False(t, carol.Permanent())
+}
+
+func TestParseComplaint(t *testing.T) {
+	bounce, err := ParseBounce(strings.NewReader(arfComplaint))
+	assert.NoError(t, err)
+	assert.True(t, bounce.Complaint)
+	assert.Equal(t, []BounceRecipient{
+		{Email: "dave@isp.example.net", Action: "failed", Diagnostic: "abuse"},
+	}, bounce.Recipients)
+}
+
+func TestParseBounceErrors(t *testing.T) {
+	_, err := ParseBounce(strings.NewReader("From: bob@example.com\nSubject: hello\n\nhello\n"))
+	assert.ErrorIs(t, err, ErrNotBounce)
+
+	noStatus := strings.Replace(dsnBounce, "message/delivery-status", "text/plain", 1)
+	_, err = ParseBounce(strings.NewReader(noStatus))
+	assert.ErrorIs(t, err, ErrNotBounce)
+}
@@ -0,0 +1,262 @@
+package email
+
+import (
+	"regexp"
+	"sort"
+	"strings"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+type (
+	// cssRule is a simple selector with its declarations.
+	cssRule struct {
+		tag         string
+		id          string
+		classes     []string
+		specificity int
+		order       int
+		decls       []string
+	}
+)
+
+var (
+	cssComments       = regexp.MustCompile(`(?s)/\*.*?\*/`)
+	cssSimpleSelector = regexp.MustCompile(`^([a-zA-Z][a-zA-Z0-9-]*|\*)?((?:[.#][a-zA-Z0-9_-]+)*)$`)
+	cssSelectorPart   = regexp.MustCompile(`[.#][a-zA-Z0-9_-]+`)
+)
+
+// InlineCSS moves the rules of the <style> elements of an HTML document into the style
+// attribute of the elements they match, as most email clients ignore <style> elements.
+// Only simple selectors are inlined (ie: "p", ".note", "#footer", "td.cell"); other rules
+// and at-rules like @media are kept in the <style> element.
+// Existing style attributes take precedence over the inlined rules.
+func InlineCSS(doc string) (string, error) {
+	root, err := html.Parse(strings.NewReader(doc))
+	if err != nil {
+		return "", err
+	}
+
+	rules := []cssRule{}
+	for _, style := range findAll(root, atom.Style) {
+		css := textContent(style)
+		inlined, kept := parseCSS(css, len(rules))
+		rules = append(rules, inlined...)
+
+		if strings.TrimSpace(kept) == "" {
+			style.Parent.RemoveChild(style)
+			continue
+		}
+		for c := style.FirstChild; c != nil; c = style.FirstChild {
+			style.RemoveChild(c)
+		}
+		style.AppendChild(&html.Node{Type: html.TextNode, Data: kept})
+	}
+
+	if len(rules) > 0 {
+		sort.SliceStable(rules, func(i, j int) bool {
+			if rules[i].specificity != rules[j].specificity {
+				return rules[i].specificity < rules[j].specificity
+			}
+			return rules[i].order < rules[j].order
+		})
+		applyCSS(root, rules)
+	}
+
+	buff := &strings.Builder{}
+	if err := html.Render(buff, root); err != nil {
+		return "", err
+	}
+	return buff.String(), nil
+}
+
+// parseCSS splits a stylesheet into the rules that can be inlined and the
+// css that must stay in the document.
+func parseCSS(css string, order int) ([]cssRule, string) {
+	css = cssComments.ReplaceAllString(css, "")
+
+	inlined := []cssRule{}
+	kept := &strings.Builder{}
+
+	for {
+		css = strings.TrimSpace(css)
+		open := strings.IndexByte(css, '{')
+		if open < 0 {
+			break
+		}
+		prelude := strings.TrimSpace(css[:open])
+
+		// find the matching closing brace, at-rules can contain nested blocks
+		depth, end := 0, -1
+		for i := open; i < len(css) && end < 0; i++ {
+			switch css[i] {
+			case '{':
+				depth++
+			case '}':
+				depth--
+				if depth == 0 {
+					end = i
+				}
+			}
+		}
+		if end < 0 {
+			kept.WriteString(css)
+			break
+		}
+		block := css[open+1 : end]
+		css = css[end+1:]
+
+		if strings.HasPrefix(prelude, "@") {
+			kept.WriteString(prelude + " {" + block + "}\n")
+			continue
+		}
+
+		decls := parseDeclarations(block)
+		if len(decls) == 0 {
+			continue
+		}
+
+		others := []string{}
+		for _, selector := range strings.Split(prelude, ",") {
+			selector = strings.TrimSpace(selector)
+			rule, ok := parseSelector(selector)
+			if !ok {
+				others = append(others, selector)
+				continue
+			}
+			rule.order = order
+			rule.decls = decls
+			order++
+			inlined = append(inlined, rule)
+		}
+		if len(others) > 0 {
+			kept.WriteString(strings.Join(others, ", ") + " {" + block + "}\n")
+		}
+	}
+	return inlined, kept.String()
+}
+
+func parseDeclarations(block string) []string {
+	decls := []string{}
+	for _, decl := range strings.Split(block, ";") {
+		decl = strings.TrimSpace(decl)
+		if decl != "" {
+			decls = append(decls, decl)
+		}
+	}
+	return decls
+}
+
+func parseSelector(selector string) (cssRule, bool) {
+	m := cssSimpleSelector.FindStringSubmatch(selector)
+	if m == nil || selector == "" {
+		return cssRule{}, false
+	}
+
+	rule := cssRule{}
+	if m[1] != "" && m[1] != "*" {
+		rule.tag = strings.ToLower(m[1])
+		rule.specificity = 1
+	}
+	for _, part := range cssSelectorPart.FindAllString(m[2], -1) {
+		if part[0] == '#' {
+			if rule.id != "" {
+				return cssRule{}, false
+			}
+			rule.id = part[1:]
+			rule.specificity += 100
+		} else {
+			rule.classes = append(rule.classes, part[1:])
+			rule.specificity += 10
+		}
+	}
+	return rule, true
+}
+
+func (r cssRule) matches(n *html.Node) bool {
+	if r.tag != "" && r.tag != n.Data {
+		return false
+	}
+	if r.id != "" && attr(n, "id") != r.id {
+		return false
+	}
+	if len(r.classes) > 0 {
+		classes := strings.Fields(attr(n, "class"))
+		for _, class := range r.classes {
+			found := false
+			for _, c := range classes {
+				if c == class {
+					found = true
+					break
+				}
+			}
+			if !found {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// applyCSS sets the style attribute of every element of the tree, rules must be
+// sorted by ascending precedence.
+func applyCSS(n *html.Node, rules []cssRule) {
+	if n.Type == html.ElementNode && n.DataAtom != atom.Style && n.DataAtom != atom.Head {
+		decls := []string{}
+		for _, rule := range rules {
+			if rule.matches(n) {
+				decls = append(decls, rule.decls...)
+			}
+		}
+		if len(decls) > 0 {
+			if style := strings.TrimSpace(attr(n, "style")); style != "" {
+				decls = append(decls, strings.TrimSuffix(style, ";"))
+			}
+			setAttr(n, "style", strings.Join(decls, "; ")+";")
+		}
+	}
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		applyCSS(c, rules)
+	}
+}
+
+func attr(n *html.Node, key string) string {
+	for _, a := range n.Attr {
+		if a.Key == key {
+			return a.Val
+		}
+	}
+	return ""
+}
+
+func setAttr(n *html.Node, key, value string) {
+	for i, a := range n.Attr {
+		if a.Key == key {
+			n.Attr[i].Val = value
+			return
+		}
+	}
+	n.Attr = append(n.Attr, html.Attribute{Key: key, Val: value})
+}
+
+func findAll(n *html.Node, a atom.Atom) []*html.Node {
+	res := []*html.Node{}
+	if n.Type == html.ElementNode && n.DataAtom == a {
+		res = append(res, n)
+	}
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		res = append(res, findAll(c, a)...)
+	}
+	return res
+}
+
+func textContent(n *html.Node) string {
+	buff := &strings.Builder{}
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		if c.Type == html.TextNode {
+			buff.WriteString(c.Data)
+		}
+	}
+	return buff.String()
+}
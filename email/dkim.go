@@ -0,0 +1,448 @@
+package email
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/wneessen/go-mail"
+)
+
+type (
+	// DKIM signs messages with DomainKeys Identified Mail (RFC 6376), using a RSA key
+	// (rsa-sha256) or an Ed25519 key (ed25519-sha256, RFC 8463) and the relaxed
+	// canonicalization for the header and the body.
+	DKIM struct {
+		domain    string
+		selector  string
+		signer    crypto.Signer
+		algorithm string
+		headers   []string
+		now       func() time.Time
+	}
+
+	headerField struct {
+		name string
+		raw  string // the complete field, with its folding and without the final CRLF
+	}
+)
+
+const (
+	dkimHeader    = "DKIM-Signature"
+	dkimLineWidth = 72
+)
+
+var (
+	// DefaultDKIMHeaders are the headers signed by default, when they are present.
+	DefaultDKIMHeaders = []string{
+		"From", "Reply-To", "Subject", "Date", "To", "Cc", "Message-ID",
+		"MIME-Version", "Content-Type", "List-Unsubscribe", "List-Unsubscribe-Post",
+	}
+
+	ErrDKIMKey         = errors.New("dkim: the key must be a RSA or Ed25519 private key")
+	ErrDKIMNoFrom      = errors.New("dkim: the message has no From header")
+	ErrDKIMNoSignature = errors.New("dkim: the message is not signed")
+	ErrDKIMInvalid     = errors.New("dkim: invalid signature")
+
+	dkimWSP  = regexp.MustCompile(`[ \t]+`)
+	dkimBTag = regexp.MustCompile(`(^|;)(\s*b\s*=)[^;]*`)
+)
+
+// NewDKIM creates a signer for domain, the public key must be published in
+// the TXT record <selector>._domainkey.<domain>, see DKIMRecord.
+func NewDKIM(domain, selector string, key crypto.Signer, opts ...func(*DKIM)) (*DKIM, error) {
+	d := &DKIM{
+		domain:   domain,
+		selector: selector,
+		signer:   key,
+		headers:  DefaultDKIMHeaders,
+		now:      time.Now,
+	}
+	switch key.(type) {
+	case *rsa.PrivateKey:
+		d.algorithm = "rsa-sha256"
+	case ed25519.PrivateKey:
+		d.algorithm = "ed25519-sha256"
+	default:
+		return nil, ErrDKIMKey
+	}
+	for _, opt := range opts {
+		opt(d)
+	}
+	return d, nil
+}
+
+// ParseDKIMKey parses a PEM encoded RSA (PKCS #1 or PKCS #8) or Ed25519 (PKCS #8) private key.
+func ParseDKIMKey(data []byte) (crypto.Signer, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("dkim: no PEM key found")
+	}
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, ErrDKIMKey
+	}
+	return signer, nil
+}
+
+// DKIMRecord returns the value of the TXT record publishing the public key of key.
+func DKIMRecord(key crypto.Signer) (string, error) {
+	switch pub := key.Public().(type) {
+	case *rsa.PublicKey:
+		der, err := x509.MarshalPKIXPublicKey(pub)
+		if err != nil {
+			return "", err
+		}
+		return "v=DKIM1; k=rsa; p=" + base64.StdEncoding.EncodeToString(der), nil
+	case ed25519.PublicKey:
+		return "v=DKIM1; k=ed25519; p=" + base64.StdEncoding.EncodeToString(pub), nil
+	}
+	return "", ErrDKIMKey
+}
+
+// WithDKIMHeaders sets the headers to sign, see DefaultDKIMHeaders.
+// From is always signed first.
+func WithDKIMHeaders(headers ...string) func(*DKIM) {
+	return func(d *DKIM) {
+		d.headers = headers
+	}
+}
+
+// Public returns the public key of the signer.
+func (d *DKIM) Public() crypto.PublicKey {
+	return d.signer.Public()
+}
+
+// Sign returns the raw RFC 5322 message prefixed with its DKIM-Signature header.
+// It can sign the output of any message builder, the line endings are converted to CRLF.
+func (d *DKIM) Sign(raw []byte) ([]byte, error) {
+	raw = toCRLF(raw)
+	signature, err := d.signature(raw)
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(dkimHeader+": "+signature+"\r\n"), raw...), nil
+}
+
+// signature returns the value of the DKIM-Signature header of the raw message.
+func (d *DKIM) signature(raw []byte) (string, error) {
+	header, body := splitMessage(raw)
+	fields := parseHeader(header)
+
+	signed := []string{"From"}
+	for _, name := range d.headers {
+		if strings.EqualFold(name, "From") {
+			continue
+		}
+		for _, f := range fields {
+			if strings.EqualFold(f.name, name) {
+				signed = append(signed, name)
+				break
+			}
+		}
+	}
+	if !hasHeader(fields, "From") {
+		return "", ErrDKIMNoFrom
+	}
+
+	bodyHash := sha256.Sum256(relaxedBody(body))
+	value := fmt.Sprintf("v=1; a=%s; c=relaxed/relaxed; d=%s; s=%s;\r\n\tt=%d; h=%s;\r\n\tbh=%s;\r\n\tb=",
+		d.algorithm,
+		d.domain,
+		d.selector,
+		d.now().Unix(),
+		strings.Join(signed, ":"),
+		base64.StdEncoding.EncodeToString(bodyHash[:]))
+
+	data, err := signedData(fields, signed, dkimHeader+": "+value)
+	if err != nil {
+		return "", err
+	}
+	digest := sha256.Sum256(data)
+
+	var sig []byte
+	if d.algorithm == "rsa-sha256" {
+		sig, err = d.signer.Sign(rand.Reader, digest[:], crypto.SHA256)
+	} else {
+		// ed25519-sha256 signs the SHA-256 hash of the data with PureEdDSA
+		sig, err = d.signer.Sign(rand.Reader, digest[:], crypto.Hash(0))
+	}
+	if err != nil {
+		return "", err
+	}
+	return value + foldBase64(base64.StdEncoding.EncodeToString(sig)), nil
+}
+
+// signMsg adds the DKIM-Signature header to msg. The boundaries of multipart messages
+// change every time go-mail writes them, so the rendered body of a multipart message
+// is frozen in a new message that is returned in place of msg.
+func (d *DKIM) signMsg(msg *mail.Msg) (*mail.Msg, error) {
+	buff := &bytes.Buffer{}
+	if _, err := msg.WriteTo(buff); err != nil {
+		return nil, err
+	}
+	header, body := splitMessage(buff.Bytes())
+	fields := parseHeader(header)
+
+	for _, f := range fields {
+		if !strings.EqualFold(f.name, "Content-Type") {
+			continue
+		}
+		contentType := unfold(f.value())
+		if strings.HasPrefix(strings.ToLower(contentType), "multipart/") {
+			msg = freezeMsg(msg, fields, contentType, append([]byte{}, body...))
+			buff.Reset()
+			if _, err := msg.WriteTo(buff); err != nil {
+				return nil, err
+			}
+		}
+		break
+	}
+
+	signature, err := d.signature(buff.Bytes())
+	if err != nil {
+		return nil, err
+	}
+	msg.SetGenHeaderPreformatted(dkimHeader, signature)
+	return msg, nil
+}
+
+// freezeMsg returns a copy of msg with the rendered multipart body as its single part.
+func freezeMsg(msg *mail.Msg, fields []headerField, contentType string, body []byte) *mail.Msg {
+	frozen := mail.NewMsg()
+	for _, h := range []mail.AddrHeader{mail.HeaderFrom, mail.HeaderTo, mail.HeaderCc, mail.HeaderBcc} {
+		addrs := msg.GetAddrHeaderString(h)
+		if len(addrs) > 0 {
+			// the addresses were already parsed by msg
+			_ = frozen.SetAddrHeader(h, addrs...)
+		}
+	}
+	for _, f := range fields {
+		values := msg.GetGenHeader(mail.Header(f.name))
+		if len(values) > 0 {
+			frozen.SetGenHeader(mail.Header(f.name), append([]string{}, values...)...)
+		}
+	}
+	// multipart bodies are 7bit or 8bit, their parts have their own encoding
+	frozen.SetBodyWriter(mail.ContentType(contentType), func(w io.Writer) (int64, error) {
+		n, err := w.Write(body)
+		return int64(n), err
+	}, mail.WithPartEncoding(mail.NoEncoding))
+	return frozen
+}
+
+// VerifyDKIM verifies the first DKIM-Signature of the raw message with the public key
+// of the signer. Only the relaxed/relaxed canonicalization is supported.
+func VerifyDKIM(raw []byte, key crypto.PublicKey) error {
+	raw = toCRLF(raw)
+	header, body := splitMessage(raw)
+	fields := parseHeader(header)
+
+	var signature *headerField
+	for i := range fields {
+		if strings.EqualFold(fields[i].name, dkimHeader) {
+			signature = &fields[i]
+			break
+		}
+	}
+	if signature == nil {
+		return ErrDKIMNoSignature
+	}
+
+	tags := map[string]string{}
+	for _, tag := range strings.Split(unfold(signature.value()), ";") {
+		k, v, ok := strings.Cut(tag, "=")
+		if ok {
+			tags[strings.TrimSpace(k)] = strings.Join(strings.Fields(v), "")
+		}
+	}
+	if tags["v"] != "1" {
+		return fmt.Errorf("%w: unsupported version %s", ErrDKIMInvalid, tags["v"])
+	}
+	if tags["c"] != "relaxed/relaxed" {
+		return fmt.Errorf("%w: unsupported canonicalization %s", ErrDKIMInvalid, tags["c"])
+	}
+	if _, ok := tags["l"]; ok {
+		return fmt.Errorf("%w: body length limits are not supported", ErrDKIMInvalid)
+	}
+	if expiration, ok := tags["x"]; ok {
+		x, err := strconv.ParseInt(expiration, 10, 64)
+		if err != nil || time.Now().Unix() > x {
+			return fmt.Errorf("%w: expired", ErrDKIMInvalid)
+		}
+	}
+
+	bodyHash := sha256.Sum256(relaxedBody(body))
+	if base64.StdEncoding.EncodeToString(bodyHash[:]) != tags["bh"] {
+		return fmt.Errorf("%w: body hash mismatch", ErrDKIMInvalid)
+	}
+
+	sig, err := base64.StdEncoding.DecodeString(tags["b"])
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrDKIMInvalid, err)
+	}
+	signed := strings.Split(tags["h"], ":")
+	data, err := signedData(fields, signed, dkimBTag.ReplaceAllString(signature.raw, "$1$2"))
+	if err != nil {
+		return err
+	}
+	digest := sha256.Sum256(data)
+
+	switch pub := key.(type) {
+	case *rsa.PublicKey:
+		if tags["a"] != "rsa-sha256" {
+			return fmt.Errorf("%w: unexpected algorithm %s", ErrDKIMInvalid, tags["a"])
+		}
+		if err := rsa.VerifyPKCS1v15(pub, crypto.SHA256, digest[:], sig); err != nil {
+			return ErrDKIMInvalid
+		}
+	case ed25519.PublicKey:
+		if tags["a"] != "ed25519-sha256" {
+			return fmt.Errorf("%w: unexpected algorithm %s", ErrDKIMInvalid, tags["a"])
+		}
+		if !ed25519.Verify(pub, digest[:], sig) {
+			return ErrDKIMInvalid
+		}
+	default:
+		return ErrDKIMKey
+	}
+	return nil
+}
+
+// signedData returns the canonicalized signed headers followed by the signature
+// header, with an empty b= tag and without its final CRLF.
+func signedData(fields []headerField, signed []string, signature string) ([]byte, error) {
+	data := &bytes.Buffer{}
+	used := make([]bool, len(fields))
+	hasFrom := false
+	for _, name := range signed {
+		name = strings.TrimSpace(name)
+		if strings.EqualFold(name, "From") {
+			hasFrom = true
+		}
+		// the instances of a header are signed from the bottom up,
+		// the missing ones are ignored
+		for i := len(fields) - 1; i >= 0; i-- {
+			if !used[i] && strings.EqualFold(fields[i].name, name) {
+				used[i] = true
+				data.WriteString(relaxedHeader(fields[i].raw))
+				break
+			}
+		}
+	}
+	if !hasFrom {
+		return nil, ErrDKIMNoFrom
+	}
+	data.WriteString(strings.TrimSuffix(relaxedHeader(signature), "\r\n"))
+	return data.Bytes(), nil
+}
+
+// relaxedHeader canonicalizes a header field with the relaxed algorithm of RFC 6376 section 3.4.2.
+func relaxedHeader(field string) string {
+	name, value, _ := strings.Cut(field, ":")
+	value = dkimWSP.ReplaceAllString(unfold(value), " ")
+	return strings.ToLower(strings.TrimSpace(name)) + ":" + strings.TrimSpace(value) + "\r\n"
+}
+
+// relaxedBody canonicalizes a body with the relaxed algorithm of RFC 6376 section 3.4.4.
+func relaxedBody(body []byte) []byte {
+	lines := strings.Split(string(body), "\r\n")
+	for i, line := range lines {
+		lines[i] = strings.TrimRight(dkimWSP.ReplaceAllString(line, " "), " ")
+	}
+	for len(lines) > 0 && lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	if len(lines) == 0 {
+		return []byte{}
+	}
+	return []byte(strings.Join(lines, "\r\n") + "\r\n")
+}
+
+// splitMessage splits a raw message between its header and its body.
+func splitMessage(raw []byte) ([]byte, []byte) {
+	if bytes.HasPrefix(raw, []byte("\r\n")) {
+		return nil, raw[2:]
+	}
+	header, body, found := bytes.Cut(raw, []byte("\r\n\r\n"))
+	if !found {
+		return raw, nil
+	}
+	return append(header, "\r\n"...), body
+}
+
+// parseHeader returns the fields of a raw header, in order.
+func parseHeader(header []byte) []headerField {
+	fields := []headerField{}
+	for _, line := range strings.SplitAfter(string(header), "\r\n") {
+		if line == "" {
+			continue
+		}
+		if (line[0] == ' ' || line[0] == '\t') && len(fields) > 0 {
+			fields[len(fields)-1].raw += line
+			continue
+		}
+		name, _, _ := strings.Cut(line, ":")
+		fields = append(fields, headerField{name: strings.TrimSpace(name), raw: line})
+	}
+	for i := range fields {
+		fields[i].raw = strings.TrimSuffix(fields[i].raw, "\r\n")
+	}
+	return fields
+}
+
+func hasHeader(fields []headerField, name string) bool {
+	for _, f := range fields {
+		if strings.EqualFold(f.name, name) {
+			return true
+		}
+	}
+	return false
+}
+
+func (f headerField) value() string {
+	_, value, _ := strings.Cut(f.raw, ":")
+	return value
+}
+
+func unfold(s string) string {
+	return strings.TrimSpace(strings.NewReplacer("\r\n", "", "\n", "").Replace(s))
+}
+
+// foldBase64 splits a base64 value on several lines of the header.
+func foldBase64(s string) string {
+	parts := []string{}
+	for len(s) > dkimLineWidth {
+		parts = append(parts, s[:dkimLineWidth])
+		s = s[dkimLineWidth:]
+	}
+	return strings.Join(append(parts, s), "\r\n\t")
+}
+
+func toCRLF(raw []byte) []byte {
+	if !bytes.Contains(raw, []byte("\n")) {
+		return raw
+	}
+	raw = bytes.ReplaceAll(raw, []byte("\r\n"), []byte("\n"))
+	return bytes.ReplaceAll(raw, []byte("\n"), []byte("\r\n"))
+}
@@ -0,0 +1,198 @@
+package email_test
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"io"
+	"mime"
+	"mime/multipart"
+	"strings"
+	"testing"
+
+	. "github.com/fdelbos/commons/email"
+	"github.com/fdelbos/commons/email/emailtest"
+	"github.com/stretchr/testify/assert"
+)
+
+// signed message of RFC 8463 appendix A
+const rfc8463Message = `DKIM-Signature: v=1; a=ed25519-sha256; c=relaxed/relaxed;
+ d=football.example.com; i=@football.example.com;
+ q=dns/txt; s=brisbane; t=1528637909; h=from : to :
+ subject : date : message-id : from : subject : date;
+ bh=2jUSOH9NhtVGCQWNr9BrIAPreKQjO6Sn7XIkfJVOzv8=;
+ b=/gCrinpcQOoIfuHNQIbq4pgh9kyIK3AQUdt9OdqQehSwhEIug4D11Bus
+ Fa3bT3FY5OsU7ZbnKELq+eXdp1Q1Dw==
+From: Joe SixPack <joe@football.example.com>
+To: Suzie Q <suzie@shopping.example.net>
+Subject: Is dinner ready?
+Date: Fri, 11 Jul 2003 21:00:37 -0700 (PDT)
+Message-ID: <20030712040037.46341.5F8J@football.example.com>
+
+Hi.
+
+We lost the game.  Are you hungry yet?
+
+Joe.
+`
+
+func TestVerifyDKIMVector(t *testing.T) {
+	pub, err := base64.StdEncoding.DecodeString("11qYAYKxCrfVS/7TyWQHOg7hcvPapiMlrwIaaPcHURo=")
+	assert.NoError(t, err)
+
+	assert.NoError(t, VerifyDKIM([]byte(rfc8463Message), ed25519.PublicKey(pub)))
+
+	tampered := strings.Replace(rfc8463Message, "hungry", "thirsty", 1)
+	assert.ErrorIs(t, VerifyDKIM([]byte(tampered), ed25519.PublicKey(pub)), ErrDKIMInvalid)
+	tampered = strings.Replace(rfc8463Message, "Is dinner ready?", "Is lunch ready?", 1)
+	assert.ErrorIs(t, VerifyDKIM([]byte(tampered), ed25519.PublicKey(pub)), ErrDKIMInvalid)
+}
+
+func dkimKeys(t *testing.T) map[string]*DKIM {
+	t.Helper()
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.NoError(t, err)
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	assert.NoError(t, err)
+
+	keys := map[string]*DKIM{}
+	keys["rsa"], err = NewDKIM("example.com", "rsa", rsaKey)
+	assert.NoError(t, err)
+	keys["ed25519"], err = NewDKIM("example.com", "ed", edKey)
+	assert.NoError(t, err)
+	return keys
+}
+
+func TestDKIMSign(t *testing.T) {
+	msg := &Message{
+		From:    "noreply@example.com",
+		To:      []string{"bob@example.com"},
+		Subject: "Héllo",
+		Text:    "hello bob",
+		HTML:    "<p>hello bob</p>",
+	}
+	raw := &bytes.Buffer{}
+	_, err := msg.WriteTo(raw)
+	assert.NoError(t, err)
+
+	for name, dkim := range dkimKeys(t) {
+		signed, err := dkim.Sign(raw.Bytes())
+		assert.NoError(t, err, name)
+		assert.True(t, bytes.HasPrefix(signed, []byte("DKIM-Signature: v=1; a="+name+"-sha256;")), name)
+		assert.NoError(t, VerifyDKIM(signed, dkim.Public()), name)
+
+		// the signature survives the relaxed changes of the relays
+		relayed := strings.ReplaceAll(string(signed), "Subject: ", "subject:   ")
+		assert.NoError(t, VerifyDKIM([]byte(relayed), dkim.Public()), name)
+		relayed = string(signed) + "\r\n\r\n"
+		assert.NoError(t, VerifyDKIM([]byte(relayed), dkim.Public()), name)
+
+		tampered := strings.Replace(string(signed), "bob@example.com", "eve@example.com", 1)
+		assert.ErrorIs(t, VerifyDKIM([]byte(tampered), dkim.Public()), ErrDKIMInvalid, name)
+	}
+
+	assert.ErrorIs(t, VerifyDKIM(raw.Bytes(), nil), ErrDKIMNoSignature)
+}
+
+func TestDKIMHeaders(t *testing.T) {
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	assert.NoError(t, err)
+	dkim, err := NewDKIM("example.com", "sel", key, WithDKIMHeaders("Subject", "X-Custom"))
+	assert.NoError(t, err)
+
+	raw := "From: noreply@example.com\nTo: bob@example.com\nSubject: hello\n\nhello\n"
+	signed, err := dkim.Sign([]byte(raw))
+	assert.NoError(t, err)
+	// From is always signed, missing headers are not
+	assert.Contains(t, string(signed), "h=From:Subject;")
+	assert.NoError(t, VerifyDKIM(signed, key.Public()))
+
+	// the unsigned headers can change
+	relayed := strings.Replace(string(signed), "To: bob@example.com", "To: eve@example.com", 1)
+	assert.NoError(t, VerifyDKIM([]byte(relayed), key.Public()))
+
+	_, err = dkim.Sign([]byte("Subject: hello\n\nhello\n"))
+	assert.ErrorIs(t, err, ErrDKIMNoFrom)
+}
+
+func TestDKIMKeys(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.NoError(t, err)
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	assert.NoError(t, err)
+
+	pkcs1 := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(rsaKey)})
+	key, err := ParseDKIMKey(pkcs1)
+	assert.NoError(t, err)
+	assert.True(t, rsaKey.Equal(key))
+
+	der, err := x509.MarshalPKCS8PrivateKey(edKey)
+	assert.NoError(t, err)
+	key, err = ParseDKIMKey(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}))
+	assert.NoError(t, err)
+	assert.True(t, edKey.Equal(key))
+
+	_, err = ParseDKIMKey([]byte("not a key"))
+	assert.Error(t, err)
+
+	record, err := DKIMRecord(rsaKey)
+	assert.NoError(t, err)
+	assert.True(t, strings.HasPrefix(record, "v=DKIM1; k=rsa; p=MII"))
+	record, err = DKIMRecord(edKey)
+	assert.NoError(t, err)
+	assert.Equal(t, "v=DKIM1; k=ed25519; p="+base64.StdEncoding.EncodeToString(edKey.Public().(ed25519.PublicKey)), record)
+}
+
+func TestSMTPDKIM(t *testing.T) {
+	server, err := emailtest.NewServer()
+	assert.NoError(t, err)
+	defer server.Close()
+
+	for name, dkim := range dkimKeys(t) {
+		smtp, err := NewSMTP(server.Host(), server.Port(),
+			WithFrom("noreply@example.com"),
+			WithOptionalTLS(true),
+			WithDKIM(dkim))
+		assert.NoError(t, err)
+
+		msg := &Message{
+			To:      []string{"bob@example.com"},
+			BCC:     []string{"audit@example.com"},
+			Subject: "Your invoice",
+			Text:    "hello bob",
+			HTML:    `<p>hello bob</p><img src="cid:logo">`,
+			Attachments: []Attachment{
+				{Name: "invoice.pdf", ContentType: "application/pdf", Data: []byte("%PDF")},
+				{Name: "logo.png", ContentType: "image/png", ContentID: "logo", Data: []byte("png")},
+			},
+		}
+		assert.NoError(t, smtp.SendMessage(context.Background(), msg), name)
+
+		received := server.Last()
+		assert.Equal(t, []string{"bob@example.com", "audit@example.com"}, received.To, name)
+		assert.NoError(t, VerifyDKIM(received.Data, dkim.Public()), name)
+
+		// the message is still a valid multipart message
+		parsed, err := received.Parse()
+		assert.NoError(t, err)
+		mediaType, params, err := mime.ParseMediaType(parsed.Header.Get("Content-Type"))
+		assert.NoError(t, err)
+		assert.Equal(t, "multipart/mixed", mediaType)
+		reader := multipart.NewReader(parsed.Body, params["boundary"])
+		parts := 0
+		for {
+			_, err := reader.NextPart()
+			if err == io.EOF {
+				break
+			}
+			assert.NoError(t, err)
+			parts++
+		}
+		assert.Equal(t, 2, parts, name)
+	}
+}
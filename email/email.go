@@ -2,21 +2,41 @@ package email
 
 import (
 	"context"
+	"crypto/tls"
 	"io"
 	"log"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/wneessen/go-mail"
 )
 
 type (
 	SMTPEmail struct {
-		client *mail.Client
+		host    string
+		options []mail.Option
 
 		// SMTP
 		smtpUser    string
 		smtpPass    string
 		smtpFrom    string
 		optionalTLS bool
+		tlsConfig   *tls.Config
+		dkim        *DKIM
+
+		// pool of connections, nil when every message dials a new connection
+		pool        chan struct{} // a slot is taken for each connection in use
+		poolSize    int
+		idleTimeout time.Duration
+		idleMut     sync.Mutex
+		idle        []*smtpConn
+		sending     map[*smtpConn]context.CancelFunc // the connections in use, with the cancel of their send
+		interrupted bool                             // set by Shutdown, the sends are canceled
+		closed      atomic.Bool
+
+		metrics smtpMetrics
 	}
 
 	ConsoleEmail struct{}
@@ -29,41 +49,86 @@ func NewSMTP(host string, port int, opts ...func(e *SMTPEmail)) (*SMTPEmail, err
 	}
 
 	var err error
-	tls := mail.TLSMandatory
+	tlsPolicy := mail.TLSMandatory
 	if email.optionalTLS {
-		tls = mail.TLSOpportunistic
+		tlsPolicy = mail.TLSOpportunistic
 	}
-	email.client, err = mail.NewClient(
-		host,
+	options := []mail.Option{
 		mail.WithPort(port),
 		mail.WithSMTPAuth(mail.SMTPAuthPlain),
-		mail.WithTLSPolicy(tls),
+		mail.WithTLSPolicy(tlsPolicy),
 		mail.WithUsername(email.smtpUser),
 		mail.WithPassword(email.smtpPass),
-	)
-	if err != nil {
+	}
+	if email.tlsConfig != nil {
+		options = append(options, mail.WithTLSConfig(email.tlsConfig))
+	}
+	email.host = host
+	email.options = options
+
+	// check the options once, the clients are created on demand
+	if _, err = email.newClient(); err != nil {
 		return nil, err
 	}
 
+	if email.poolSize > 0 {
+		email.pool = make(chan struct{}, email.poolSize)
+	}
+
 	return email, nil
 }
 
+func (e *SMTPEmail) newClient(opts ...mail.Option) (*mail.Client, error) {
+	options := append(append([]mail.Option{}, e.options...), opts...)
+	return mail.NewClient(e.host, options...)
+}
+
+// Send sends a simple email to a single recipient.
 func (e *SMTPEmail) Send(ctx context.Context, to, subject string, textReader, htmlReader io.Reader) error {
-	msg := mail.NewMsg()
+	msg, err := NewMessage(to, subject, textReader, htmlReader)
+	if err != nil {
+		return err
+	}
+	return e.SendMessage(ctx, msg)
+}
 
-	msg.From(e.smtpFrom)
-	msg.To(to)
-	msg.Subject(subject)
+// SendMessage sends a complete message.
+func (e *SMTPEmail) SendMessage(ctx context.Context, message *Message) error {
+	return e.SendBatch(ctx, []*Message{message})
+}
 
-	if raw, err := io.ReadAll(textReader); err == nil && len(raw) > 0 {
-		msg.SetBodyString(mail.TypeTextPlain, string(raw))
+// SendBatch sends several messages over a single connection.
+// The messages are all attempted, the returned error joins the errors of the failed ones.
+func (e *SMTPEmail) SendBatch(ctx context.Context, messages []*Message) error {
+	if e.closed.Load() {
+		return ErrClosed
 	}
-
-	if raw, err := io.ReadAll(htmlReader); err == nil && len(raw) > 0 {
-		msg.SetBodyString(mail.TypeTextHTML, string(raw))
+	msgs := make([]*mail.Msg, 0, len(messages))
+	for _, message := range messages {
+		msg, err := message.mailMsg(e.smtpFrom)
+		if err != nil {
+			return err
+		}
+		if e.dkim != nil {
+			if msg, err = e.dkim.signMsg(msg); err != nil {
+				return err
+			}
+		}
+		msgs = append(msgs, msg)
+	}
+	if len(msgs) == 0 {
+		return nil
 	}
 
-	return e.client.DialAndSendWithContext(ctx, msg)
+	start := time.Now()
+	var err error
+	if e.pool != nil {
+		err = e.sendPooled(ctx, msgs)
+	} else {
+		err = e.sendOnce(ctx, msgs)
+	}
+	e.metrics.record(msgs, err, time.Since(start))
+	return err
 }
 
 func WithPlainAuth(user, pass string) func(e *SMTPEmail) {
@@ -85,11 +150,53 @@ func WithOptionalTLS(optional bool) func(e *SMTPEmail) {
 	}
 }
 
+// WithPool keeps up to size authenticated connections open and reuses them to send
+// the messages, a connection unused for idleTimeout is reopened.
+// Close or Shutdown must be called to close the connections when done.
+func WithPool(size int, idleTimeout time.Duration) func(e *SMTPEmail) {
+	return func(e *SMTPEmail) {
+		e.poolSize = size
+		e.idleTimeout = idleTimeout
+	}
+}
+
+// WithTLSConfig sets the TLS configuration used for STARTTLS (ie: to trust a private CA).
+func WithTLSConfig(config *tls.Config) func(e *SMTPEmail) {
+	return func(e *SMTPEmail) {
+		e.tlsConfig = config
+	}
+}
+
+// WithDKIM signs the messages with DKIM.
+func WithDKIM(dkim *DKIM) func(e *SMTPEmail) {
+	return func(e *SMTPEmail) {
+		e.dkim = dkim
+	}
+}
+
 func (c ConsoleEmail) Send(ctx context.Context, to, subject string, textReader, htmlReader io.Reader) error {
-	if raw, err := io.ReadAll(textReader); err == nil {
-		log.Printf(`Sending email to="%s" subject="%s" body="%s"`, to, subject, string(raw))
-	} else {
-		log.Printf(`Sending email to="%s" subject="%s"`, to, subject)
+	msg, err := NewMessage(to, subject, textReader, htmlReader)
+	if err != nil {
+		return err
 	}
+	return c.SendMessage(ctx, msg)
+}
+
+func (c ConsoleEmail) SendMessage(ctx context.Context, msg *Message) error {
+	if err := msg.Validate(); err != nil {
+		return err
+	}
+	log.Printf(`Sending email to="%s" cc="%s" bcc="%s" subject="%s" attachments=%d body="%s"`,
+		strings.Join(msg.To, ","),
+		strings.Join(msg.CC, ","),
+		strings.Join(msg.BCC, ","),
+		msg.Subject,
+		len(msg.Attachments),
+		msg.Text)
+	return nil
+}
+
+// Close does nothing, the console has no connection.
+func (c ConsoleEmail) Close() error {
 	return nil
 }
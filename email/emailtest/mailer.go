@@ -0,0 +1,155 @@
+// Package emailtest provides utilities to test code sending emails:
+// a Mailer capturing the messages in memory and an in-process SMTP Server.
+package emailtest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/fdelbos/commons/auth"
+	"github.com/fdelbos/commons/email"
+)
+
+type (
+	// Mailer is an auth.Mailer and email.MessageSender storing the messages in memory.
+	Mailer struct {
+		mut      sync.Mutex
+		messages []*email.Message
+		err      error
+	}
+)
+
+var (
+	// CodePattern matches the codes generated by auth.Codes with the default number of digits.
+	CodePattern = CodePatternOf(auth.DefaultNbDigits)
+
+	ErrNoMessage = errors.New("no message found")
+	ErrNoCode    = errors.New("no code found in message")
+)
+
+// CodePatternOf returns the pattern of the codes of auth.Codes with nbDigits digits,
+// see auth.WithCodeNbDigits.
+func CodePatternOf(nbDigits int) *regexp.Regexp {
+	return regexp.MustCompile(fmt.Sprintf(`\b[%s]{%d}\b`, regexp.QuoteMeta(auth.Digits), nbDigits))
+}
+
+// NewMailer creates an empty capturing mailer.
+func NewMailer() *Mailer {
+	return &Mailer{}
+}
+
+func (m *Mailer) Send(ctx context.Context, to, subject string, textReader, htmlReader io.Reader) error {
+	msg, err := email.NewMessage(to, subject, textReader, htmlReader)
+	if err != nil {
+		return err
+	}
+	return m.SendMessage(ctx, msg)
+}
+
+func (m *Mailer) SendMessage(ctx context.Context, msg *email.Message) error {
+	m.mut.Lock()
+	defer m.mut.Unlock()
+
+	if m.err != nil {
+		return m.err
+	}
+	if err := msg.Validate(); err != nil {
+		return err
+	}
+	m.messages = append(m.messages, msg)
+	return nil
+}
+
+// FailWith makes all the following sends fail with err, use nil to succeed again.
+func (m *Mailer) FailWith(err error) {
+	m.mut.Lock()
+	defer m.mut.Unlock()
+	m.err = err
+}
+
+// Messages returns all the captured messages, in the order they were sent.
+func (m *Mailer) Messages() []*email.Message {
+	m.mut.Lock()
+	defer m.mut.Unlock()
+	return append([]*email.Message{}, m.messages...)
+}
+
+// Count returns the number of captured messages.
+func (m *Mailer) Count() int {
+	m.mut.Lock()
+	defer m.mut.Unlock()
+	return len(m.messages)
+}
+
+// Last returns the last captured message, or nil if there is none.
+func (m *Mailer) Last() *email.Message {
+	m.mut.Lock()
+	defer m.mut.Unlock()
+	if len(m.messages) == 0 {
+		return nil
+	}
+	return m.messages[len(m.messages)-1]
+}
+
+// LastTo returns the last message sent to addr (in To, CC or BCC), or nil if there is none.
+func (m *Mailer) LastTo(addr string) *email.Message {
+	m.mut.Lock()
+	defer m.mut.Unlock()
+
+	addr = strings.ToLower(strings.TrimSpace(addr))
+	for i := len(m.messages) - 1; i >= 0; i-- {
+		for _, rcpt := range m.messages[i].Recipients() {
+			if strings.ToLower(strings.TrimSpace(rcpt)) == addr {
+				return m.messages[i]
+			}
+		}
+	}
+	return nil
+}
+
+// LastCodeTo returns the code of the last message sent to addr, see ExtractCode.
+func (m *Mailer) LastCodeTo(addr string) (string, error) {
+	msg := m.LastTo(addr)
+	if msg == nil {
+		return "", ErrNoMessage
+	}
+	return ExtractCode(msg, CodePattern)
+}
+
+// Reset removes all the captured messages.
+func (m *Mailer) Reset() {
+	m.mut.Lock()
+	defer m.mut.Unlock()
+	m.messages = nil
+}
+
+// ExtractCode returns the first match of pattern with a digit in the text body of msg,
+// or in the HTML body if there is no text. Without such a match it's the first match.
+func ExtractCode(msg *email.Message, pattern *regexp.Regexp) (string, error) {
+	body := msg.Text
+	if body == "" {
+		text, err := email.HTMLToText(msg.HTML)
+		if err != nil {
+			return "", err
+		}
+		body = text
+	}
+
+	// a word of the body can match too (ie: "NOTE" for a 4 digits code),
+	// the first match with a digit is more likely the code
+	matches := pattern.FindAllString(body, -1)
+	if len(matches) == 0 {
+		return "", ErrNoCode
+	}
+	for _, match := range matches {
+		if strings.ContainsAny(match, "0123456789") {
+			return match, nil
+		}
+	}
+	return matches[0], nil
+}
@@ -0,0 +1,98 @@
+package emailtest_test
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/fdelbos/commons/auth"
+	"github.com/fdelbos/commons/email"
+	. "github.com/fdelbos/commons/email/emailtest"
+	"github.com/fdelbos/commons/internal/mocks"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+var _ auth.Mailer = (*Mailer)(nil)
+
+func TestMailer(t *testing.T) {
+	mailer := NewMailer()
+	ctx := context.Background()
+
+	assert.Nil(t, mailer.Last())
+	_, err := mailer.LastCodeTo("bob@example.com")
+	assert.ErrorIs(t, err, ErrNoMessage)
+
+	err = mailer.Send(ctx, "bob@example.com", "Hello", strings.NewReader("hello bob"), nil)
+	assert.NoError(t, err)
+	err = mailer.SendMessage(ctx, &email.Message{
+		To:      []string{"alice@example.com"},
+		CC:      []string{"Bob@Example.com"},
+		Subject: "Code",
+		HTML:    "<p>NOTE: your CODE is <b>A1B2C3D4</b>, see the HTML version</p>",
+	})
+	assert.NoError(t, err)
+
+	assert.Equal(t, 2, mailer.Count())
+	assert.Equal(t, "Code", mailer.Last().Subject)
+	assert.Equal(t, "Code", mailer.LastTo("bob@example.com").Subject)
+	assert.Nil(t, mailer.LastTo("carol@example.com"))
+
+	code, err := mailer.LastCodeTo("alice@example.com")
+	assert.NoError(t, err)
+	assert.Equal(t, "A1B2C3D4", code)
+
+	_, err = ExtractCode(mailer.Messages()[0], CodePattern)
+	assert.ErrorIs(t, err, ErrNoCode)
+
+	mailer.FailWith(errors.New("down"))
+	assert.Error(t, mailer.Send(ctx, "bob@example.com", "Hello", nil, nil))
+	mailer.FailWith(nil)
+
+	mailer.Reset()
+	assert.Equal(t, 0, mailer.Count())
+}
+
+func TestMailerWithCodes(t *testing.T) {
+	mailer := NewMailer()
+	store := mocks.NewAuthCodeStore(t)
+	store.On("NewCode", mock.Anything, mock.Anything).Return(nil).Once()
+
+	codes, err := auth.NewCodes(mailer, store)
+	assert.NoError(t, err)
+
+	ctx := context.Background()
+	assert.NoError(t, codes.Send(ctx, "bob@example.com"))
+
+	code, err := mailer.LastCodeTo("bob@example.com")
+	assert.NoError(t, err)
+	assert.Len(t, code, 8)
+}
+
+func TestCodePattern(t *testing.T) {
+	tc := []struct {
+		body string
+		code string
+	}{
+		{"Your code is A1B2C3D4", "A1B2C3D4"},
+		{"IMPORTANT NOTE: your CODE is 12345678.", "12345678"},
+		{"HTML CODE ABC", ""},
+		{"code: A1B2C3D4E5", ""},
+	}
+	for _, c := range tc {
+		t.Run(c.body, func(t *testing.T) {
+			code, err := ExtractCode(&email.Message{Text: c.body}, CodePattern)
+			if c.code == "" {
+				assert.ErrorIs(t, err, ErrNoCode)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, c.code, code)
+		})
+	}
+
+	code, err := ExtractCode(&email.Message{Text: "NOTE: your code is A1B2"}, CodePatternOf(4))
+	assert.NoError(t, err)
+	assert.Equal(t, "A1B2", code)
+}
@@ -0,0 +1,459 @@
+package emailtest
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"fmt"
+	"math/big"
+	"net"
+	"net/mail"
+	"net/textproto"
+	"strings"
+	"sync"
+	"time"
+)
+
+type (
+	// Server is an in-process SMTP server for integration tests.
+	// It listens on a random port of 127.0.0.1 and stores the messages it receives.
+	Server struct {
+		listener  net.Listener
+		tlsConfig *tls.Config
+		startTLS  bool
+		user      string
+		pass      string
+
+		mut      sync.Mutex
+		messages []*Received
+		reject   *Reply
+		conns    map[net.Conn]struct{}
+		accepted int
+		wg       sync.WaitGroup
+	}
+
+	// Received is a message received by the Server.
+	Received struct {
+		From string   // the envelope sender
+		To   []string // the envelope recipients
+		Data []byte   // the raw message
+		User string   // the authenticated user, if any
+		TLS  bool     // true if the message was received over STARTTLS
+	}
+
+	// Reply is an SMTP reply.
+	Reply struct {
+		Code    int
+		Message string
+	}
+
+	session struct {
+		server *Server
+		conn   net.Conn
+		text   *textproto.Conn
+		tls    bool
+		user   string
+		from   string
+		to     []string
+	}
+)
+
+// NewServer starts a new SMTP server, it must be closed when done.
+func NewServer(opts ...func(*Server)) (*Server, error) {
+	s := &Server{
+		conns: map[net.Conn]struct{}{},
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+
+	cert, err := selfSignedCert()
+	if err != nil {
+		return nil, err
+	}
+	s.tlsConfig = &tls.Config{Certificates: []tls.Certificate{cert}}
+
+	s.listener, err = net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+
+	s.wg.Add(1)
+	go s.serve()
+	return s, nil
+}
+
+// Host returns the host of the server.
+func (s *Server) Host() string {
+	return s.listener.Addr().(*net.TCPAddr).IP.String()
+}
+
+// Port returns the port of the server.
+func (s *Server) Port() int {
+	return s.listener.Addr().(*net.TCPAddr).Port
+}
+
+// ClientTLSConfig returns a TLS configuration trusting the certificate of the server.
+func (s *Server) ClientTLSConfig() *tls.Config {
+	pool := x509.NewCertPool()
+	pool.AddCert(s.tlsConfig.Certificates[0].Leaf)
+	return &tls.Config{
+		RootCAs:    pool,
+		ServerName: s.Host(),
+		MinVersion: tls.VersionTLS12,
+	}
+}
+
+// Close stops the server and its open sessions.
+func (s *Server) Close() error {
+	err := s.listener.Close()
+	s.Disconnect()
+	s.wg.Wait()
+	return err
+}
+
+// Disconnect closes the open connections, as a server would on an idle timeout.
+func (s *Server) Disconnect() {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	for conn := range s.conns {
+		conn.Close()
+	}
+}
+
+// Messages returns the received messages, in the order they were received.
+func (s *Server) Messages() []*Received {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	return append([]*Received{}, s.messages...)
+}
+
+// Last returns the last received message, or nil if there is none.
+func (s *Server) Last() *Received {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	if len(s.messages) == 0 {
+		return nil
+	}
+	return s.messages[len(s.messages)-1]
+}
+
+// Connections returns the number of connections accepted since the start of the server.
+func (s *Server) Connections() int {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	return s.accepted
+}
+
+// Reset removes the received messages.
+func (s *Server) Reset() {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	s.messages = nil
+}
+
+// Reject makes the server reply to all the following MAIL commands with the given
+// code and message (ie: 450 for a transient error, 550 for a permanent one).
+// Use a code of 0 to accept messages again.
+func (s *Server) Reject(code int, message string) {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	if code == 0 {
+		s.reject = nil
+		return
+	}
+	s.reject = &Reply{Code: code, Message: message}
+}
+
+// Parse parses the raw message.
+func (r *Received) Parse() (*mail.Message, error) {
+	return mail.ReadMessage(bytes.NewReader(r.Data))
+}
+
+// WithStartTLS makes the server offer STARTTLS.
+func WithStartTLS() func(*Server) {
+	return func(s *Server) {
+		s.startTLS = true
+	}
+}
+
+// WithAuth makes the server require authentication with the given credentials.
+func WithAuth(user, pass string) func(*Server) {
+	return func(s *Server) {
+		s.user = user
+		s.pass = pass
+	}
+}
+
+func (s *Server) serve() {
+	defer s.wg.Done()
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		s.mut.Lock()
+		s.conns[conn] = struct{}{}
+		s.accepted++
+		s.mut.Unlock()
+
+		s.wg.Add(1)
+		go func() {
+			defer func() {
+				s.mut.Lock()
+				delete(s.conns, conn)
+				s.mut.Unlock()
+				s.wg.Done()
+			}()
+			sess := &session{server: s}
+			sess.setConn(conn)
+			sess.handle()
+		}()
+	}
+}
+
+func (s *Server) rejection() *Reply {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	return s.reject
+}
+
+func (s *Server) store(msg *Received) {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	s.messages = append(s.messages, msg)
+}
+
+func (sess *session) setConn(conn net.Conn) {
+	sess.conn = conn
+	sess.text = textproto.NewConn(conn)
+}
+
+func (sess *session) reply(code int, format string, args ...any) bool {
+	return sess.text.PrintfLine("%d %s", code, fmt.Sprintf(format, args...)) == nil
+}
+
+func (sess *session) reset() {
+	sess.from = ""
+	sess.to = nil
+}
+
+func (sess *session) handle() {
+	defer sess.conn.Close()
+	sess.conn.SetDeadline(time.Now().Add(time.Minute))
+
+	if !sess.reply(220, "emailtest ESMTP ready") {
+		return
+	}
+
+	for {
+		line, err := sess.text.ReadLine()
+		if err != nil {
+			return
+		}
+		verb, arg, _ := strings.Cut(line, " ")
+		verb = strings.ToUpper(verb)
+
+		switch verb {
+		case "EHLO", "HELO":
+			sess.reset()
+			ext := []string{"emailtest", "8BITMIME", "AUTH PLAIN LOGIN"}
+			if sess.server.startTLS && !sess.tls {
+				ext = append(ext, "STARTTLS")
+			}
+			for i, e := range ext {
+				sep := "-"
+				if i == len(ext)-1 {
+					sep = " "
+				}
+				sess.text.PrintfLine("250%s%s", sep, e)
+			}
+
+		case "STARTTLS":
+			if !sess.server.startTLS || sess.tls {
+				sess.reply(502, "5.5.1 STARTTLS not available")
+				continue
+			}
+			sess.reply(220, "2.0.0 Ready to start TLS")
+			conn := tls.Server(sess.conn, sess.server.tlsConfig)
+			if err := conn.Handshake(); err != nil {
+				return
+			}
+			sess.setConn(conn)
+			sess.tls = true
+			sess.user = ""
+			sess.reset()
+
+		case "AUTH":
+			sess.auth(arg)
+
+		case "MAIL":
+			if sess.server.user != "" && sess.user == "" {
+				sess.reply(530, "5.7.0 Authentication required")
+				continue
+			}
+			if r := sess.server.rejection(); r != nil {
+				sess.reply(r.Code, "%s", r.Message)
+				continue
+			}
+			sess.reset()
+			sess.from = address(arg)
+			sess.reply(250, "2.1.0 Ok")
+
+		case "RCPT":
+			if sess.from == "" {
+				sess.reply(503, "5.5.1 Bad sequence of commands")
+				continue
+			}
+			sess.to = append(sess.to, address(arg))
+			sess.reply(250, "2.1.5 Ok")
+
+		case "DATA":
+			if len(sess.to) == 0 {
+				sess.reply(503, "5.5.1 Bad sequence of commands")
+				continue
+			}
+			sess.reply(354, "End data with <CR><LF>.<CR><LF>")
+			data, err := sess.text.ReadDotBytes()
+			if err != nil {
+				return
+			}
+			sess.server.store(&Received{
+				From: sess.from,
+				To:   sess.to,
+				Data: data,
+				User: sess.user,
+				TLS:  sess.tls,
+			})
+			sess.reset()
+			sess.reply(250, "2.0.0 Ok: queued")
+
+		case "RSET":
+			sess.reset()
+			sess.reply(250, "2.0.0 Ok")
+
+		case "NOOP":
+			sess.reply(250, "2.0.0 Ok")
+
+		case "QUIT":
+			sess.reply(221, "2.0.0 Bye")
+			return
+
+		default:
+			sess.reply(502, "5.5.2 Command not recognized")
+		}
+	}
+}
+
+func (sess *session) auth(arg string) {
+	mechanism, initial, _ := strings.Cut(arg, " ")
+
+	readResponse := func(challenge string) (string, bool) {
+		if !sess.reply(334, challenge) {
+			return "", false
+		}
+		line, err := sess.text.ReadLine()
+		if err != nil {
+			return "", false
+		}
+		raw, err := base64.StdEncoding.DecodeString(line)
+		return string(raw), err == nil
+	}
+
+	var user, pass string
+	switch strings.ToUpper(mechanism) {
+	case "PLAIN":
+		resp := ""
+		if initial != "" {
+			raw, err := base64.StdEncoding.DecodeString(initial)
+			if err != nil {
+				sess.reply(501, "5.5.2 Invalid response")
+				return
+			}
+			resp = string(raw)
+		} else {
+			var ok bool
+			if resp, ok = readResponse(""); !ok {
+				sess.reply(501, "5.5.2 Invalid response")
+				return
+			}
+		}
+		parts := strings.Split(resp, "\x00")
+		if len(parts) != 3 {
+			sess.reply(501, "5.5.2 Invalid response")
+			return
+		}
+		user, pass = parts[1], parts[2]
+
+	case "LOGIN":
+		var ok bool
+		if user, ok = readResponse(base64.StdEncoding.EncodeToString([]byte("Username:"))); !ok {
+			sess.reply(501, "5.5.2 Invalid response")
+			return
+		}
+		if pass, ok = readResponse(base64.StdEncoding.EncodeToString([]byte("Password:"))); !ok {
+			sess.reply(501, "5.5.2 Invalid response")
+			return
+		}
+
+	default:
+		sess.reply(504, "5.5.4 Unrecognized authentication type")
+		return
+	}
+
+	if sess.server.user != "" && (user != sess.server.user || pass != sess.server.pass) {
+		sess.reply(535, "5.7.8 Authentication credentials invalid")
+		return
+	}
+	sess.user = user
+	sess.reply(235, "2.7.0 Authentication successful")
+}
+
+// address extracts the address of a MAIL FROM:<addr> or RCPT TO:<addr> argument.
+func address(arg string) string {
+	start := strings.IndexByte(arg, '<')
+	end := strings.IndexByte(arg, '>')
+	if start < 0 || end < start {
+		_, addr, _ := strings.Cut(arg, ":")
+		return strings.TrimSpace(addr)
+	}
+	return arg[start+1 : end]
+}
+
+func selfSignedCert() (tls.Certificate, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "emailtest"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(24 * time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+		DNSNames:              []string{"localhost"},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	leaf, err := x509.ParseCertificate(der)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	return tls.Certificate{
+		Certificate: [][]byte{der},
+		PrivateKey:  key,
+		Leaf:        leaf,
+	}, nil
+}
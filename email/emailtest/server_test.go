@@ -0,0 +1,129 @@
+package emailtest_test
+
+import (
+	"context"
+	"io"
+	"mime"
+	"mime/multipart"
+	"strings"
+	"testing"
+
+	"github.com/fdelbos/commons/email"
+	. "github.com/fdelbos/commons/email/emailtest"
+	"github.com/stretchr/testify/assert"
+)
+
+func newServer(t *testing.T, opts ...func(*Server)) *Server {
+	server, err := NewServer(opts...)
+	assert.NoError(t, err)
+	t.Cleanup(func() { server.Close() })
+	return server
+}
+
+func TestServerSMTPEmail(t *testing.T) {
+	server := newServer(t, WithStartTLS(), WithAuth("user", "secret"))
+
+	smtp, err := email.NewSMTP(server.Host(), server.Port(),
+		email.WithPlainAuth("user", "secret"),
+		email.WithFrom("noreply@example.com"),
+		email.WithTLSConfig(server.ClientTLSConfig()))
+	assert.NoError(t, err)
+
+	ctx := context.Background()
+	err = smtp.Send(ctx, "bob@example.com", "Hello", strings.NewReader("hello bob"), strings.NewReader("<p>hello bob</p>"))
+	assert.NoError(t, err)
+
+	received := server.Last()
+	assert.NotNil(t, received)
+	assert.True(t, received.TLS)
+	assert.Equal(t, "user", received.User)
+	assert.Equal(t, "noreply@example.com", received.From)
+	assert.Equal(t, []string{"bob@example.com"}, received.To)
+
+	msg, err := received.Parse()
+	assert.NoError(t, err)
+	assert.Equal(t, "Hello", msg.Header.Get("Subject"))
+
+	mediaType, params, err := mime.ParseMediaType(msg.Header.Get("Content-Type"))
+	assert.NoError(t, err)
+	assert.Equal(t, "multipart/alternative", mediaType)
+
+	bodies := map[string]string{}
+	mr := multipart.NewReader(msg.Body, params["boundary"])
+	for {
+		part, err := mr.NextPart()
+		if err == io.EOF {
+			break
+		}
+		assert.NoError(t, err)
+		raw, err := io.ReadAll(part)
+		assert.NoError(t, err)
+		contentType, _, _ := mime.ParseMediaType(part.Header.Get("Content-Type"))
+		bodies[contentType] = strings.TrimSpace(string(raw))
+	}
+	assert.Equal(t, "hello bob", bodies["text/plain"])
+	assert.Equal(t, "<p>hello bob</p>", bodies["text/html"])
+
+	// bcc recipients are in the envelope but not in the headers
+	err = smtp.SendMessage(ctx, &email.Message{
+		To:      []string{"alice@example.com"},
+		BCC:     []string{"carol@example.com"},
+		Subject: "Hidden",
+		Text:    "hi",
+	})
+	assert.NoError(t, err)
+	received = server.Last()
+	assert.Equal(t, []string{"alice@example.com", "carol@example.com"}, received.To)
+	msg, err = received.Parse()
+	assert.NoError(t, err)
+	assert.Equal(t, "", msg.Header.Get("Bcc"))
+	assert.Len(t, server.Messages(), 2)
+}
+
+func TestServerTLSPolicy(t *testing.T) {
+	server := newServer(t)
+	ctx := context.Background()
+
+	// STARTTLS is mandatory by default
+	smtp, err := email.NewSMTP(server.Host(), server.Port(), email.WithFrom("noreply@example.com"))
+	assert.NoError(t, err)
+	err = smtp.Send(ctx, "bob@example.com", "Hello", strings.NewReader("hello"), nil)
+	assert.Error(t, err)
+	assert.Nil(t, server.Last())
+
+	smtp, err = email.NewSMTP(server.Host(), server.Port(),
+		email.WithFrom("noreply@example.com"),
+		email.WithOptionalTLS(true))
+	assert.NoError(t, err)
+	err = smtp.Send(ctx, "bob@example.com", "Hello", strings.NewReader("hello"), nil)
+	assert.NoError(t, err)
+	assert.False(t, server.Last().TLS)
+}
+
+func TestServerAuthAndReject(t *testing.T) {
+	server := newServer(t, WithStartTLS(), WithAuth("user", "secret"))
+	ctx := context.Background()
+
+	smtp, err := email.NewSMTP(server.Host(), server.Port(),
+		email.WithPlainAuth("user", "wrong"),
+		email.WithFrom("noreply@example.com"),
+		email.WithTLSConfig(server.ClientTLSConfig()))
+	assert.NoError(t, err)
+	err = smtp.Send(ctx, "bob@example.com", "Hello", strings.NewReader("hello"), nil)
+	assert.ErrorContains(t, err, "535")
+
+	smtp, err = email.NewSMTP(server.Host(), server.Port(),
+		email.WithPlainAuth("user", "secret"),
+		email.WithFrom("noreply@example.com"),
+		email.WithTLSConfig(server.ClientTLSConfig()))
+	assert.NoError(t, err)
+
+	server.Reject(550, "5.1.1 mailbox unavailable")
+	err = smtp.Send(ctx, "bob@example.com", "Hello", strings.NewReader("hello"), nil)
+	assert.ErrorContains(t, err, "550")
+
+	server.Reject(0, "")
+	err = smtp.Send(ctx, "bob@example.com", "Hello", strings.NewReader("hello"), nil)
+	assert.NoError(t, err)
+	assert.Len(t, server.Messages(), 1)
+}
@@ -0,0 +1,54 @@
+package email
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/wneessen/go-mail"
+)
+
+// IsPermanent returns true if err is a permanent delivery failure of the message itself,
+// that will fail again with any provider (ie: a 5xx SMTP reply to MAIL FROM, RCPT TO or DATA,
+// or a 4xx reply of an HTTP API). Network errors, temporary failures and errors that are
+// specific to a provider (ie: authentication, rate limiting or no default sender) are transient.
+// A joined error is permanent if all its errors are.
+func IsPermanent(err error) bool {
+	if err == nil {
+		return false
+	}
+	if joined, ok := err.(interface{ Unwrap() []error }); ok {
+		errs := joined.Unwrap()
+		for _, err := range errs {
+			if !IsPermanent(err) {
+				return false
+			}
+		}
+		return len(errs) > 0
+	}
+
+	if errors.Is(err, ErrNoRecipients) || errors.Is(err, ErrSuppressed) {
+		return true
+	}
+
+	httpErr := &HTTPError{}
+	if errors.As(err, &httpErr) {
+		switch httpErr.StatusCode {
+		case http.StatusUnauthorized, http.StatusForbidden, http.StatusRequestTimeout, http.StatusTooManyRequests:
+			return false
+		}
+		return httpErr.StatusCode >= 400 && httpErr.StatusCode < 500
+	}
+
+	sendErr := &mail.SendError{}
+	if errors.As(err, &sendErr) {
+		switch sendErr.Reason {
+		case mail.ErrGetSender, mail.ErrGetRcpts, mail.ErrNoUnencoded:
+			return true
+		case mail.ErrSMTPMailFrom, mail.ErrSMTPRcptTo, mail.ErrSMTPData, mail.ErrSMTPDataClose:
+			// the code of the SMTP reply, network errors have none and stay transient
+			code := sendErr.ErrorCode()
+			return code >= 500 && code < 600
+		}
+	}
+	return false
+}
@@ -0,0 +1,138 @@
+package email
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+)
+
+type (
+	// HTTPFormat builds the request sending a message to an HTTP email API.
+	HTTPFormat interface {
+		Request(ctx context.Context, endpoint string, msg *Message) (*http.Request, error)
+	}
+
+	// HTTPEmail sends emails through an HTTP transactional email API.
+	HTTPEmail struct {
+		client   *http.Client
+		endpoint string
+		format   HTTPFormat
+		from     string
+	}
+
+	// HTTPError is returned when the API replies with a non 2xx status.
+	HTTPError struct {
+		StatusCode int
+		Body       string
+	}
+)
+
+const (
+	DefaultHTTPTimeout = 30 * time.Second
+
+	// maximum size of an error body kept in HTTPError
+	maxErrorBody = 4096
+)
+
+// NewHTTP creates a sender posting the messages to endpoint in the given format.
+func NewHTTP(endpoint string, format HTTPFormat, opts ...func(*HTTPEmail)) *HTTPEmail {
+	e := &HTTPEmail{
+		client:   &http.Client{Timeout: DefaultHTTPTimeout},
+		endpoint: endpoint,
+		format:   format,
+	}
+	for _, opt := range opts {
+		opt(e)
+	}
+	return e
+}
+
+// Send sends a simple email to a single recipient.
+func (e *HTTPEmail) Send(ctx context.Context, to, subject string, textReader, htmlReader io.Reader) error {
+	msg, err := NewMessage(to, subject, textReader, htmlReader)
+	if err != nil {
+		return err
+	}
+	return e.SendMessage(ctx, msg)
+}
+
+// SendMessage sends a complete message.
+func (e *HTTPEmail) SendMessage(ctx context.Context, message *Message) error {
+	if err := message.Validate(); err != nil {
+		return err
+	}
+	msg := *message
+	if msg.From == "" {
+		msg.From = e.from
+	}
+	if msg.From == "" {
+		return ErrNoSender
+	}
+
+	req, err := e.format.Request(ctx, e.endpoint, &msg)
+	if err != nil {
+		return err
+	}
+
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, maxErrorBody))
+		return &HTTPError{StatusCode: resp.StatusCode, Body: string(body)}
+	}
+	_, err = io.Copy(io.Discard, resp.Body)
+	return err
+}
+
+// Close closes the idle connections of the HTTP client.
+func (e *HTTPEmail) Close() error {
+	e.client.CloseIdleConnections()
+	return nil
+}
+
+func (e *HTTPError) Error() string {
+	return fmt.Sprintf("email api replied with status %d: %s", e.StatusCode, e.Body)
+}
+
+// WithHTTPFrom sets the default sender address.
+func WithHTTPFrom(from string) func(*HTTPEmail) {
+	return func(e *HTTPEmail) {
+		e.from = from
+	}
+}
+
+// WithHTTPClient sets the HTTP client used to call the API.
+func WithHTTPClient(client *http.Client) func(*HTTPEmail) {
+	return func(e *HTTPEmail) {
+		e.client = client
+	}
+}
+
+// WithHTTPEndpoint overrides the base URL of the API (ie: for a regional endpoint or tests).
+func WithHTTPEndpoint(endpoint string) func(*HTTPEmail) {
+	return func(e *HTTPEmail) {
+		e.endpoint = endpoint
+	}
+}
+
+func newJSONRequest(ctx context.Context, url string, body any) (*http.Request, error) {
+	raw, err := json.Marshal(body)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(raw))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/json")
+	return req, nil
+}
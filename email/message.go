@@ -0,0 +1,179 @@
+package email
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/wneessen/go-mail"
+)
+
+type (
+	// Message is an email with all its recipients, headers and parts.
+	Message struct {
+		From        string            `json:"from,omitempty"`        // optional, the sender's default from address is used when empty
+		To          []string          `json:"to,omitempty"`          // the main recipients
+		CC          []string          `json:"cc,omitempty"`          // the carbon copy recipients
+		BCC         []string          `json:"bcc,omitempty"`         // the blind carbon copy recipients, they are never written in the headers
+		ReplyTo     string            `json:"reply_to,omitempty"`    // optional Reply-To address
+		Subject     string            `json:"subject"`               // the subject
+		Text        string            `json:"text,omitempty"`        // the plain text body
+		HTML        string            `json:"html,omitempty"`        // the HTML body, sent as an alternative of the text body when both are set
+		Headers     map[string]string `json:"headers,omitempty"`     // custom headers (ie: List-Unsubscribe)
+		Attachments []Attachment      `json:"attachments,omitempty"` // attached and inline files
+	}
+
+	// Attachment is a file sent with a Message.
+	// When ContentID is set the file is embedded inline and can be referenced from
+	// the HTML body with "cid:<ContentID>", ie: <img src="cid:logo">.
+	Attachment struct {
+		Name        string `json:"name"`                   // the file name
+		ContentType string `json:"content_type,omitempty"` // optional, guessed from the name when empty
+		ContentID   string `json:"content_id,omitempty"`   // optional, makes the attachment inline
+		Data        []byte `json:"data"`                   // the content of the file
+	}
+
+	// MessageSender sends complete messages.
+	MessageSender interface {
+		SendMessage(ctx context.Context, msg *Message) error
+	}
+)
+
+var (
+	ErrNoRecipients = errors.New("email message has no recipients")
+	ErrNoSender     = errors.New("email message has no sender")
+)
+
+// NewMessage creates a message from the arguments of auth.Mailer.Send, the readers can be nil.
+// An error is returned when a reader fails.
+func NewMessage(to, subject string, textReader, htmlReader io.Reader) (*Message, error) {
+	msg := &Message{
+		To:      []string{to},
+		Subject: subject,
+	}
+	if textReader != nil {
+		raw, err := io.ReadAll(textReader)
+		if err != nil {
+			return nil, fmt.Errorf("email: reading the text body: %w", err)
+		}
+		msg.Text = string(raw)
+	}
+	if htmlReader != nil {
+		raw, err := io.ReadAll(htmlReader)
+		if err != nil {
+			return nil, fmt.Errorf("email: reading the html body: %w", err)
+		}
+		msg.HTML = string(raw)
+	}
+	return msg, nil
+}
+
+// Recipients returns all the recipients of the message: To, CC and BCC.
+func (m *Message) Recipients() []string {
+	res := make([]string, 0, len(m.To)+len(m.CC)+len(m.BCC))
+	res = append(res, m.To...)
+	res = append(res, m.CC...)
+	res = append(res, m.BCC...)
+	return res
+}
+
+// Validate checks that the message can be sent.
+func (m *Message) Validate() error {
+	if len(m.Recipients()) == 0 {
+		return ErrNoRecipients
+	}
+	return nil
+}
+
+// Inline returns true if the attachment is embedded in the HTML body.
+func (a Attachment) Inline() bool {
+	return a.ContentID != ""
+}
+
+// mailMsg converts the message to a go-mail message, from is used when the message has no From.
+func (m *Message) mailMsg(from string) (*mail.Msg, error) {
+	if err := m.Validate(); err != nil {
+		return nil, err
+	}
+	if m.From != "" {
+		from = m.From
+	}
+	if from == "" {
+		return nil, ErrNoSender
+	}
+
+	msg := mail.NewMsg()
+	if err := msg.From(from); err != nil {
+		return nil, err
+	}
+	if len(m.To) > 0 {
+		if err := msg.To(m.To...); err != nil {
+			return nil, err
+		}
+	}
+	if len(m.CC) > 0 {
+		if err := msg.Cc(m.CC...); err != nil {
+			return nil, err
+		}
+	}
+	if len(m.BCC) > 0 {
+		if err := msg.Bcc(m.BCC...); err != nil {
+			return nil, err
+		}
+	}
+	if m.ReplyTo != "" {
+		if err := msg.ReplyTo(m.ReplyTo); err != nil {
+			return nil, err
+		}
+	}
+	msg.Subject(m.Subject)
+	for k, v := range m.Headers {
+		msg.SetGenHeader(mail.Header(k), v)
+	}
+
+	switch {
+	case m.Text != "" && m.HTML != "":
+		msg.SetBodyString(mail.TypeTextPlain, m.Text)
+		msg.AddAlternativeString(mail.TypeTextHTML, m.HTML)
+	case m.HTML != "":
+		msg.SetBodyString(mail.TypeTextHTML, m.HTML)
+	default:
+		msg.SetBodyString(mail.TypeTextPlain, m.Text)
+	}
+
+	for _, a := range m.Attachments {
+		if a.Name == "" {
+			return nil, fmt.Errorf("email: attachment without a name")
+		}
+		opts := []mail.FileOption{}
+		if a.ContentType != "" {
+			opts = append(opts, mail.WithFileContentType(mail.ContentType(a.ContentType)))
+		}
+		var err error
+		if a.Inline() {
+			cid := a.ContentID
+			opts = append(opts, func(f *mail.File) {
+				f.Header.Set(string(mail.HeaderContentID), "<"+cid+">")
+			})
+			err = msg.EmbedReader(a.Name, bytes.NewReader(a.Data), opts...)
+		} else {
+			err = msg.AttachReader(a.Name, bytes.NewReader(a.Data), opts...)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("email: attachment %s: %w", a.Name, err)
+		}
+	}
+
+	return msg, nil
+}
+
+// WriteTo writes the message in the RFC 5322 format, the message must have a From address.
+func (m *Message) WriteTo(w io.Writer) (int64, error) {
+	msg, err := m.mailMsg("")
+	if err != nil {
+		return 0, err
+	}
+	return msg.WriteTo(w)
+}
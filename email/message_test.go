@@ -0,0 +1,109 @@
+package email_test
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/mail"
+	"strings"
+	"testing"
+	"testing/iotest"
+
+	. "github.com/fdelbos/commons/email"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMessageWriteTo(t *testing.T) {
+	msg := &Message{
+		From:    "noreply@example.com",
+		To:      []string{"alice@example.com", "bob@example.com"},
+		CC:      []string{"carol@example.com"},
+		BCC:     []string{"dave@example.com"},
+		ReplyTo: "support@example.com",
+		Subject: "Hello",
+		Text:    "hello world",
+		HTML:    `<p>hello <img src="cid:logo"></p>`,
+		Headers: map[string]string{
+			"List-Unsubscribe": "<https://example.com/unsubscribe>",
+		},
+		Attachments: []Attachment{
+			{Name: "report.csv", ContentType: "text/csv", Data: []byte("a,b\n1,2\n")},
+			{Name: "logo.png", ContentType: "image/png", ContentID: "logo", Data: []byte{0x89, 'P', 'N', 'G'}},
+		},
+	}
+
+	buff := &bytes.Buffer{}
+	_, err := msg.WriteTo(buff)
+	assert.NoError(t, err)
+
+	parsed, err := mail.ReadMessage(buff)
+	assert.NoError(t, err)
+	assert.Equal(t, "Hello", parsed.Header.Get("Subject"))
+	assert.Equal(t, "<noreply@example.com>", parsed.Header.Get("From"))
+	assert.Contains(t, parsed.Header.Get("To"), "<alice@example.com>")
+	assert.Contains(t, parsed.Header.Get("To"), "<bob@example.com>")
+	assert.Equal(t, "<carol@example.com>", parsed.Header.Get("Cc"))
+	assert.Equal(t, "", parsed.Header.Get("Bcc"))
+	assert.Equal(t, "<support@example.com>", parsed.Header.Get("Reply-To"))
+	assert.Equal(t, "<https://example.com/unsubscribe>", parsed.Header.Get("List-Unsubscribe"))
+
+	mediaType, params, err := mime.ParseMediaType(parsed.Header.Get("Content-Type"))
+	assert.NoError(t, err)
+	assert.Equal(t, "multipart/mixed", mediaType)
+
+	contentTypes := []string{}
+	contentIDs := []string{}
+	var walk func(r io.Reader, boundary string)
+	walk = func(r io.Reader, boundary string) {
+		mr := multipart.NewReader(r, boundary)
+		for {
+			part, err := mr.NextPart()
+			if err == io.EOF {
+				return
+			}
+			assert.NoError(t, err)
+			mediaType, params, err := mime.ParseMediaType(part.Header.Get("Content-Type"))
+			assert.NoError(t, err)
+			if strings.HasPrefix(mediaType, "multipart/") {
+				walk(part, params["boundary"])
+				continue
+			}
+			contentTypes = append(contentTypes, mediaType)
+			if id := part.Header.Get("Content-ID"); id != "" {
+				contentIDs = append(contentIDs, id)
+			}
+		}
+	}
+	walk(parsed.Body, params["boundary"])
+
+	assert.ElementsMatch(t, []string{"text/plain", "text/html", "text/csv", "image/png"}, contentTypes)
+	assert.Equal(t, []string{"<logo>"}, contentIDs)
+}
+
+func TestMessageErrors(t *testing.T) {
+	_, err := (&Message{From: "noreply@example.com"}).WriteTo(io.Discard)
+	assert.ErrorIs(t, err, ErrNoRecipients)
+
+	_, err = (&Message{To: []string{"bob@example.com"}}).WriteTo(io.Discard)
+	assert.ErrorIs(t, err, ErrNoSender)
+
+	err = ConsoleEmail{}.SendMessage(context.Background(), &Message{})
+	assert.ErrorIs(t, err, ErrNoRecipients)
+}
+
+func TestNewMessage(t *testing.T) {
+	msg, err := NewMessage("bob@example.com", "hello", strings.NewReader("text"), nil)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"bob@example.com"}, msg.To)
+	assert.Equal(t, "text", msg.Text)
+	assert.Empty(t, msg.HTML)
+
+	// the failures of the readers are returned
+	_, err = NewMessage("bob@example.com", "hello", nil, iotest.ErrReader(io.ErrUnexpectedEOF))
+	assert.ErrorIs(t, err, io.ErrUnexpectedEOF)
+
+	err = ConsoleEmail{}.Send(context.Background(), "bob@example.com", "hello", iotest.ErrReader(io.ErrUnexpectedEOF), nil)
+	assert.ErrorIs(t, err, io.ErrUnexpectedEOF)
+}
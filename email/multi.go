@@ -0,0 +1,170 @@
+package email
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+	"strings"
+	"sync"
+
+	"github.com/fdelbos/commons/auth"
+)
+
+type (
+	// MultiStrategy selects how Multi uses its mailers.
+	MultiStrategy int
+
+	// Weighted is a mailer with its weight for NewRoundRobin.
+	Weighted struct {
+		Mailer auth.Mailer
+		Weight int
+	}
+
+	// Multi is a mailer sending through several mailers, see NewFailover,
+	// NewRoundRobin and NewShadow.
+	Multi struct {
+		strategy MultiStrategy
+		mailers  []auth.Mailer
+		weights  []int
+		mirrors  []auth.Mailer
+
+		mut     sync.Mutex
+		current []int // state of the smooth weighted round-robin
+	}
+)
+
+const (
+	Failover   MultiStrategy = iota // use the mailers in order
+	RoundRobin                      // spread the messages between the mailers by weight
+	Shadow                          // use the primary mailer and copy the messages to the mirrors
+)
+
+var (
+	ErrNoMailers          = errors.New("no mailers configured")
+	ErrUnsupportedMessage = errors.New("the mailer can only send simple messages")
+)
+
+// NewFailover creates a mailer sending with the first mailer, and trying the
+// next one when it fails with a transient error (see IsPermanent).
+func NewFailover(mailers ...auth.Mailer) *Multi {
+	return &Multi{
+		strategy: Failover,
+		mailers:  mailers,
+	}
+}
+
+// NewRoundRobin creates a mailer spreading the messages between the mailers
+// proportionally to their weight, with a failover to the other mailers on transient errors.
+// A mailer with a weight of 0 is only used for failover.
+func NewRoundRobin(mailers ...Weighted) *Multi {
+	m := &Multi{
+		strategy: RoundRobin,
+		current:  make([]int, len(mailers)),
+	}
+	for _, w := range mailers {
+		m.mailers = append(m.mailers, w.Mailer)
+		m.weights = append(m.weights, w.Weight)
+	}
+	return m
+}
+
+// NewShadow creates a mailer sending with primary and copying every message to
+// the mirrors (ie: a ConsoleEmail or an emailtest.Mailer). The errors of the mirrors
+// are logged and never returned.
+func NewShadow(primary auth.Mailer, mirrors ...auth.Mailer) *Multi {
+	return &Multi{
+		strategy: Shadow,
+		mailers:  []auth.Mailer{primary},
+		mirrors:  mirrors,
+	}
+}
+
+// Send sends a simple email to a single recipient.
+func (m *Multi) Send(ctx context.Context, to, subject string, textReader, htmlReader io.Reader) error {
+	msg, err := NewMessage(to, subject, textReader, htmlReader)
+	if err != nil {
+		return err
+	}
+	return m.SendMessage(ctx, msg)
+}
+
+// SendMessage sends a complete message. The mailers that are not MessageSender
+// can only send simple messages, to a single recipient without attachments.
+func (m *Multi) SendMessage(ctx context.Context, msg *Message) error {
+	if err := msg.Validate(); err != nil {
+		return err
+	}
+	if len(m.mailers) == 0 {
+		return ErrNoMailers
+	}
+
+	errs := []error{}
+	for _, mailer := range m.order() {
+		err := sendWith(ctx, mailer, msg)
+		if err == nil {
+			errs = nil
+			break
+		}
+		errs = append(errs, err)
+		if IsPermanent(err) || m.strategy == Shadow {
+			break
+		}
+		log.Printf("email/multi sending failed, trying the next mailer: %v", err)
+	}
+
+	for _, mirror := range m.mirrors {
+		if err := sendWith(ctx, mirror, msg); err != nil {
+			log.Printf("email/multi mirror failed: %v", err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// order returns the mailers in the order they must be tried.
+func (m *Multi) order() []auth.Mailer {
+	if m.strategy != RoundRobin {
+		return m.mailers
+	}
+	start := m.next()
+	return append(append([]auth.Mailer{}, m.mailers[start:]...), m.mailers[:start]...)
+}
+
+// next selects the first mailer with the smooth weighted round-robin of nginx:
+// every weight is added to its counter, the highest counter is selected and
+// decreased by the total weight.
+func (m *Multi) next() int {
+	m.mut.Lock()
+	defer m.mut.Unlock()
+
+	total, selected := 0, 0
+	for i, weight := range m.weights {
+		if weight <= 0 {
+			continue
+		}
+		m.current[i] += weight
+		total += weight
+		if m.current[i] > m.current[selected] || m.weights[selected] <= 0 {
+			selected = i
+		}
+	}
+	m.current[selected] -= total
+	return selected
+}
+
+// sendWith sends msg with mailer, as a simple email if it is not a MessageSender.
+func sendWith(ctx context.Context, mailer auth.Mailer, msg *Message) error {
+	if sender, ok := mailer.(MessageSender); ok {
+		return sender.SendMessage(ctx, msg)
+	}
+	if len(msg.To) != 1 || len(msg.CC) > 0 || len(msg.BCC) > 0 || msg.From != "" ||
+		msg.ReplyTo != "" || len(msg.Headers) > 0 || len(msg.Attachments) > 0 {
+		return ErrUnsupportedMessage
+	}
+
+	var html io.Reader
+	if msg.HTML != "" {
+		html = strings.NewReader(msg.HTML)
+	}
+	return mailer.Send(ctx, msg.To[0], msg.Subject, strings.NewReader(msg.Text), html)
+}
@@ -0,0 +1,145 @@
+package email_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	. "github.com/fdelbos/commons/email"
+	"github.com/fdelbos/commons/email/emailtest"
+	"github.com/stretchr/testify/assert"
+	"github.com/wneessen/go-mail"
+)
+
+// simpleMailer only implements auth.Mailer.
+type simpleMailer struct {
+	sent []string
+}
+
+func (m *simpleMailer) Send(ctx context.Context, to, subject string, textReader, htmlReader io.Reader) error {
+	m.sent = append(m.sent, to)
+	return nil
+}
+
+func TestIsPermanent(t *testing.T) {
+	server, err := emailtest.NewServer()
+	assert.NoError(t, err)
+	defer server.Close()
+
+	smtp, err := NewSMTP(server.Host(), server.Port(), WithFrom("noreply@example.com"), WithOptionalTLS(true))
+	assert.NoError(t, err)
+	ctx := context.Background()
+
+	server.Reject(550, "5.1.1 mailbox unavailable")
+	err = smtp.Send(ctx, "bob@example.com", "hello", strings.NewReader("hello"), nil)
+	assert.Error(t, err)
+	assert.True(t, IsPermanent(err))
+	sendErr := &mail.SendError{}
+	assert.ErrorAs(t, err, &sendErr)
+	assert.Equal(t, 550, sendErr.ErrorCode())
+
+	server.Reject(450, "4.2.1 try again later")
+	err = smtp.Send(ctx, "bob@example.com", "hello", strings.NewReader("hello"), nil)
+	assert.Error(t, err)
+	assert.False(t, IsPermanent(err))
+
+	assert.False(t, IsPermanent(nil))
+	assert.False(t, IsPermanent(errors.New("connection refused")))
+	assert.True(t, IsPermanent(ErrNoRecipients))
+	assert.False(t, IsPermanent(ErrNoSender))
+	assert.True(t, IsPermanent(&HTTPError{StatusCode: http.StatusUnprocessableEntity}))
+	assert.False(t, IsPermanent(&HTTPError{StatusCode: http.StatusTooManyRequests}))
+	assert.False(t, IsPermanent(&HTTPError{StatusCode: http.StatusUnauthorized}))
+	assert.False(t, IsPermanent(&HTTPError{StatusCode: http.StatusBadGateway}))
+	assert.True(t, IsPermanent(errors.Join(ErrNoRecipients, &HTTPError{StatusCode: 400})))
+	assert.False(t, IsPermanent(errors.Join(ErrNoRecipients, errors.New("timeout"))))
+}
+
+func TestFailover(t *testing.T) {
+	primary, secondary := emailtest.NewMailer(), emailtest.NewMailer()
+	multi := NewFailover(primary, secondary)
+	ctx := context.Background()
+
+	assert.NoError(t, multi.Send(ctx, "bob@example.com", "hello", strings.NewReader("hello"), nil))
+	assert.Equal(t, 1, primary.Count())
+	assert.Equal(t, 0, secondary.Count())
+
+	// transient errors fail over
+	primary.FailWith(&HTTPError{StatusCode: http.StatusServiceUnavailable})
+	assert.NoError(t, multi.Send(ctx, "bob@example.com", "hello", strings.NewReader("hello"), nil))
+	assert.Equal(t, 1, primary.Count())
+	assert.Equal(t, 1, secondary.Count())
+
+	// permanent errors don't
+	primary.FailWith(&HTTPError{StatusCode: http.StatusUnprocessableEntity})
+	err := multi.Send(ctx, "bob@example.com", "hello", strings.NewReader("hello"), nil)
+	assert.True(t, IsPermanent(err))
+	assert.Equal(t, 1, secondary.Count())
+
+	// all the errors are returned when every mailer failed
+	primary.FailWith(errors.New("primary down"))
+	secondary.FailWith(errors.New("secondary down"))
+	err = multi.Send(ctx, "bob@example.com", "hello", strings.NewReader("hello"), nil)
+	assert.ErrorContains(t, err, "primary down")
+	assert.ErrorContains(t, err, "secondary down")
+
+	assert.ErrorIs(t, NewFailover().Send(ctx, "bob@example.com", "hello", nil, nil), ErrNoMailers)
+}
+
+func TestFailoverSimpleMailer(t *testing.T) {
+	simple := &simpleMailer{}
+	capture := emailtest.NewMailer()
+	multi := NewFailover(simple, capture)
+	ctx := context.Background()
+
+	assert.NoError(t, multi.Send(ctx, "bob@example.com", "hello", strings.NewReader("hello"), nil))
+	assert.Equal(t, []string{"bob@example.com"}, simple.sent)
+
+	// a simple mailer can't send a complete message, the next mailer is used
+	err := multi.SendMessage(ctx, &Message{To: []string{"bob@example.com"}, CC: []string{"carol@example.com"}, Subject: "hello"})
+	assert.NoError(t, err)
+	assert.Len(t, simple.sent, 1)
+	assert.Equal(t, 1, capture.Count())
+}
+
+func TestRoundRobin(t *testing.T) {
+	a, b, backup := emailtest.NewMailer(), emailtest.NewMailer(), emailtest.NewMailer()
+	multi := NewRoundRobin(
+		Weighted{Mailer: a, Weight: 3},
+		Weighted{Mailer: b, Weight: 1},
+		Weighted{Mailer: backup, Weight: 0})
+	ctx := context.Background()
+
+	for i := 0; i < 8; i++ {
+		assert.NoError(t, multi.Send(ctx, "bob@example.com", "hello", strings.NewReader("hello"), nil))
+	}
+	assert.Equal(t, 6, a.Count())
+	assert.Equal(t, 2, b.Count())
+	assert.Equal(t, 0, backup.Count())
+
+	a.FailWith(errors.New("down"))
+	b.FailWith(errors.New("down"))
+	assert.NoError(t, multi.Send(ctx, "bob@example.com", "hello", strings.NewReader("hello"), nil))
+	assert.Equal(t, 1, backup.Count())
+}
+
+func TestShadow(t *testing.T) {
+	primary, mirror := emailtest.NewMailer(), emailtest.NewMailer()
+	failing := emailtest.NewMailer()
+	failing.FailWith(errors.New("down"))
+	multi := NewShadow(primary, failing, mirror)
+	ctx := context.Background()
+
+	assert.NoError(t, multi.Send(ctx, "bob@example.com", "hello", strings.NewReader("hello"), nil))
+	assert.Equal(t, 1, primary.Count())
+	assert.Equal(t, 1, mirror.Count())
+
+	// the errors of the primary are returned, the message is still mirrored
+	primary.FailWith(errors.New("primary down"))
+	err := multi.Send(ctx, "bob@example.com", "hello", strings.NewReader("hello"), nil)
+	assert.ErrorContains(t, err, "primary down")
+	assert.Equal(t, 2, mirror.Count())
+}
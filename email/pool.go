@@ -0,0 +1,374 @@
+package email
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/wneessen/go-mail"
+)
+
+type (
+	// smtpConn is a connection of the pool, the client is nil until the connection is dialed.
+	smtpConn struct {
+		client   *mail.Client
+		conn     *ctxConn
+		ctx      context.Context // context of the current send
+		lastUsed time.Time
+	}
+
+	// ctxConn is the network connection of a client, its reads and writes are
+	// interrupted when the context of the current send is done.
+	ctxConn struct {
+		net.Conn
+		mut      sync.Mutex
+		deadline time.Time // deadline of the current send, zero when there is none
+		stop     chan struct{}
+		stopped  chan struct{}
+	}
+
+	smtpMetrics struct {
+		sent    atomic.Int64
+		failed  atomic.Int64
+		dials   atomic.Int64
+		latency atomic.Int64 // cumulated latency of the sent messages, in nanoseconds
+	}
+
+	// SMTPMetrics are the counters of an SMTPEmail since its creation.
+	SMTPMetrics struct {
+		Sent    int64         // number of messages sent
+		Failed  int64         // number of messages that failed
+		Dials   int64         // number of connections opened
+		Latency time.Duration // cumulated time spent sending the messages
+	}
+)
+
+// CloseTimeout is how long Close waits for the sends in progress.
+const CloseTimeout = 30 * time.Second
+
+// ErrClosed is returned by the sends of a closed SMTPEmail.
+var ErrClosed = errors.New("the smtp sender is closed")
+
+// AverageLatency returns the average time spent to send a message.
+func (m SMTPMetrics) AverageLatency() time.Duration {
+	total := m.Sent + m.Failed
+	if total == 0 {
+		return 0
+	}
+	return m.Latency / time.Duration(total)
+}
+
+func (m *smtpMetrics) record(msgs []*mail.Msg, err error, latency time.Duration) {
+	failed := int64(0)
+	for _, msg := range msgs {
+		if msg.HasSendError() {
+			failed++
+		}
+	}
+	if err != nil && failed == 0 {
+		// the connection failed before any message could be attempted
+		failed = int64(len(msgs))
+	}
+	m.sent.Add(int64(len(msgs)) - failed)
+	m.failed.Add(failed)
+	m.latency.Add(int64(latency))
+}
+
+// Metrics returns the counters of the sender.
+func (e *SMTPEmail) Metrics() SMTPMetrics {
+	return SMTPMetrics{
+		Sent:    e.metrics.sent.Load(),
+		Failed:  e.metrics.failed.Load(),
+		Dials:   e.metrics.dials.Load(),
+		Latency: time.Duration(e.metrics.latency.Load()),
+	}
+}
+
+// Close closes the pooled connections, the following sends return ErrClosed.
+// It waits up to CloseTimeout for the sends in progress, then interrupts them.
+func (e *SMTPEmail) Close() error {
+	ctx, cancel := context.WithTimeout(context.Background(), CloseTimeout)
+	defer cancel()
+	e.Shutdown(ctx)
+	return nil
+}
+
+// Shutdown closes the pooled connections, the following sends return ErrClosed.
+// It waits for the sends in progress until ctx is done, then interrupts them and
+// returns the error of ctx.
+func (e *SMTPEmail) Shutdown(ctx context.Context) error {
+	e.closed.Store(true)
+	if e.pool == nil {
+		return nil
+	}
+
+	var err error
+	for i := 0; i < e.poolSize; i++ {
+		select {
+		case e.pool <- struct{}{}:
+			continue
+		case <-ctx.Done():
+		}
+		if err == nil {
+			err = ctx.Err()
+			e.interrupt()
+		}
+		// the interrupted sends release their connection right away
+		e.pool <- struct{}{}
+	}
+	defer func() {
+		for i := 0; i < e.poolSize; i++ {
+			<-e.pool
+		}
+	}()
+
+	e.idleMut.Lock()
+	defer e.idleMut.Unlock()
+	for _, conn := range e.idle {
+		conn.close()
+	}
+	e.idle = nil
+	return err
+}
+
+// interrupt cancels the sends in progress, and the sends starting after it.
+func (e *SMTPEmail) interrupt() {
+	e.idleMut.Lock()
+	defer e.idleMut.Unlock()
+	e.interrupted = true
+	for _, cancel := range e.sending {
+		cancel()
+	}
+}
+
+// acquire waits for a free slot of the pool and returns the most recently used connection,
+// so the connections in excess can expire. cancel interrupts the send when the pool is shut down.
+func (e *SMTPEmail) acquire(ctx context.Context, cancel context.CancelFunc) (*smtpConn, error) {
+	select {
+	case e.pool <- struct{}{}:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	if e.closed.Load() {
+		<-e.pool
+		return nil, ErrClosed
+	}
+
+	e.idleMut.Lock()
+	defer e.idleMut.Unlock()
+	if e.interrupted {
+		cancel()
+	}
+	conn := &smtpConn{}
+	if len(e.idle) > 0 {
+		conn = e.idle[len(e.idle)-1]
+		e.idle = e.idle[:len(e.idle)-1]
+	}
+	if e.sending == nil {
+		e.sending = map[*smtpConn]context.CancelFunc{}
+	}
+	e.sending[conn] = cancel
+	return conn, nil
+}
+
+func (e *SMTPEmail) release(conn *smtpConn) {
+	conn.lastUsed = time.Now()
+	e.idleMut.Lock()
+	delete(e.sending, conn)
+	if conn.client != nil {
+		e.idle = append(e.idle, conn)
+	}
+	e.idleMut.Unlock()
+	<-e.pool
+}
+
+func (e *SMTPEmail) sendPooled(ctx context.Context, msgs []*mail.Msg) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	conn, err := e.acquire(ctx, cancel)
+	if err != nil {
+		return err
+	}
+	defer e.release(conn)
+
+	if conn.client != nil && e.idleTimeout > 0 && time.Since(conn.lastUsed) > e.idleTimeout {
+		conn.close()
+	}
+	return e.send(ctx, conn, msgs)
+}
+
+// sendOnce sends the messages on a new connection, closed when done.
+func (e *SMTPEmail) sendOnce(ctx context.Context, msgs []*mail.Msg) error {
+	conn := &smtpConn{}
+	defer conn.close()
+	return e.send(ctx, conn, msgs)
+}
+
+// send sends the messages on conn, dialed if needed. The dial and the send
+// are interrupted when ctx is done, the connection is then closed.
+func (e *SMTPEmail) send(ctx context.Context, conn *smtpConn, msgs []*mail.Msg) error {
+	conn.bind(ctx)
+	defer conn.unbind()
+
+	for attempt := 0; ; attempt++ {
+		if conn.client == nil {
+			client, err := e.newClient(mail.WithDialContextFunc(conn.dial))
+			if err != nil {
+				return err
+			}
+			e.metrics.dials.Add(1)
+			if err := client.DialWithContext(ctx); err != nil {
+				// the client can't be closed when the dial failed
+				conn.close()
+				return err
+			}
+			conn.client = client
+		}
+
+		err := conn.client.Send(msgs...)
+		if err == nil {
+			return nil
+		}
+		if ctx.Err() != nil {
+			// interrupted in the middle of a command
+			conn.close()
+			return err
+		}
+
+		// the kept alive connection was closed by the server, reconnect once
+		sendErr := &mail.SendError{}
+		if attempt == 0 && errors.As(err, &sendErr) && sendErr.Reason == mail.ErrConnCheck {
+			conn.close()
+			continue
+		}
+
+		if !reusable(err) {
+			conn.close()
+		}
+		return err
+	}
+}
+
+// reusable returns true if the send errors are only rejections of the MAIL or RCPT commands,
+// in which case the transaction was reset and the connection can still be used.
+func reusable(err error) bool {
+	if joined, ok := err.(interface{ Unwrap() []error }); ok {
+		for _, err := range joined.Unwrap() {
+			if !reusable(err) {
+				return false
+			}
+		}
+		return true
+	}
+
+	sendErr := &mail.SendError{}
+	if !errors.As(err, &sendErr) {
+		return false
+	}
+	return sendErr.Reason == mail.ErrSMTPMailFrom || sendErr.Reason == mail.ErrSMTPRcptTo
+}
+
+func (c *smtpConn) dial(ctx context.Context, network, address string) (net.Conn, error) {
+	nc, err := (&net.Dialer{}).DialContext(ctx, network, address)
+	if err != nil {
+		return nil, err
+	}
+	c.conn = &ctxConn{Conn: nc}
+	if c.ctx != nil {
+		c.conn.bind(c.ctx)
+	}
+	return c.conn, nil
+}
+
+// bind interrupts the connection when ctx is done, until unbind.
+func (c *smtpConn) bind(ctx context.Context) {
+	c.ctx = ctx
+	if c.conn != nil {
+		c.conn.bind(ctx)
+	}
+}
+
+func (c *smtpConn) unbind() {
+	c.ctx = nil
+	if c.conn != nil {
+		c.conn.unbind()
+	}
+}
+
+func (c *smtpConn) close() {
+	if c.client != nil {
+		c.client.Close()
+		c.client = nil
+	}
+	if c.conn != nil {
+		// the client doesn't close a broken connection
+		c.conn.unbind()
+		c.conn.Conn.Close()
+		c.conn = nil
+	}
+}
+
+func (c *ctxConn) bind(ctx context.Context) {
+	c.mut.Lock()
+	c.deadline, _ = ctx.Deadline()
+	c.Conn.SetDeadline(c.deadline)
+	c.mut.Unlock()
+
+	if ctx.Done() == nil {
+		return
+	}
+	c.stop = make(chan struct{})
+	c.stopped = make(chan struct{})
+	go func(stop, stopped chan struct{}) {
+		defer close(stopped)
+		select {
+		case <-ctx.Done():
+			// a deadline in the past interrupts the blocked reads and writes
+			c.mut.Lock()
+			c.deadline = time.Unix(1, 0)
+			c.Conn.SetDeadline(c.deadline)
+			c.mut.Unlock()
+		case <-stop:
+		}
+	}(c.stop, c.stopped)
+}
+
+func (c *ctxConn) unbind() {
+	if c.stop != nil {
+		close(c.stop)
+		<-c.stopped
+		c.stop = nil
+	}
+	c.mut.Lock()
+	c.deadline = time.Time{}
+	c.mut.Unlock()
+}
+
+// SetDeadline sets the deadline of the client, limited to the deadline of the send.
+func (c *ctxConn) SetDeadline(t time.Time) error {
+	c.mut.Lock()
+	defer c.mut.Unlock()
+	return c.Conn.SetDeadline(c.limit(t))
+}
+
+func (c *ctxConn) SetReadDeadline(t time.Time) error {
+	c.mut.Lock()
+	defer c.mut.Unlock()
+	return c.Conn.SetReadDeadline(c.limit(t))
+}
+
+func (c *ctxConn) SetWriteDeadline(t time.Time) error {
+	c.mut.Lock()
+	defer c.mut.Unlock()
+	return c.Conn.SetWriteDeadline(c.limit(t))
+}
+
+func (c *ctxConn) limit(t time.Time) time.Time {
+	if !c.deadline.IsZero() && (t.IsZero() || c.deadline.Before(t)) {
+		return c.deadline
+	}
+	return t
+}
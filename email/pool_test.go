@@ -0,0 +1,193 @@
+package email_test
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	. "github.com/fdelbos/commons/email"
+	"github.com/fdelbos/commons/email/emailtest"
+	"github.com/stretchr/testify/assert"
+)
+
+func newPooledSMTP(t *testing.T, idle time.Duration) (*SMTPEmail, *emailtest.Server) {
+	server, err := emailtest.NewServer(emailtest.WithStartTLS(), emailtest.WithAuth("user", "secret"))
+	assert.NoError(t, err)
+	t.Cleanup(func() { server.Close() })
+
+	smtp, err := NewSMTP(server.Host(), server.Port(),
+		WithPlainAuth("user", "secret"),
+		WithFrom("noreply@example.com"),
+		WithTLSConfig(server.ClientTLSConfig()),
+		WithPool(2, idle))
+	assert.NoError(t, err)
+	t.Cleanup(func() { smtp.Close() })
+	return smtp, server
+}
+
+func TestSMTPPool(t *testing.T) {
+	smtp, server := newPooledSMTP(t, time.Minute)
+	ctx := context.Background()
+
+	for i := 0; i < 5; i++ {
+		err := smtp.Send(ctx, fmt.Sprintf("user%d@example.com", i), "hello", strings.NewReader("hello"), nil)
+		assert.NoError(t, err)
+	}
+	assert.Len(t, server.Messages(), 5)
+	assert.Equal(t, 1, server.Connections())
+
+	batch := []*Message{}
+	for i := 0; i < 3; i++ {
+		batch = append(batch, &Message{To: []string{fmt.Sprintf("batch%d@example.com", i)}, Subject: "batch", Text: "hello"})
+	}
+	assert.NoError(t, smtp.SendBatch(ctx, batch))
+	assert.Len(t, server.Messages(), 8)
+	assert.Equal(t, 1, server.Connections())
+
+	// a rejection doesn't close the connection
+	server.Reject(550, "5.1.1 mailbox unavailable")
+	err := smtp.Send(ctx, "bob@example.com", "hello", strings.NewReader("hello"), nil)
+	assert.Error(t, err)
+	server.Reject(0, "")
+	assert.NoError(t, smtp.Send(ctx, "bob@example.com", "hello", strings.NewReader("hello"), nil))
+	assert.Equal(t, 1, server.Connections())
+
+	// reconnects when the server closed the connection
+	server.Disconnect()
+	assert.NoError(t, smtp.Send(ctx, "bob@example.com", "hello", strings.NewReader("hello"), nil))
+	assert.Equal(t, 2, server.Connections())
+
+	metrics := smtp.Metrics()
+	assert.Equal(t, int64(10), metrics.Sent)
+	assert.Equal(t, int64(1), metrics.Failed)
+	assert.Equal(t, int64(2), metrics.Dials)
+	assert.Greater(t, metrics.AverageLatency(), time.Duration(0))
+}
+
+func TestSMTPPoolConcurrent(t *testing.T) {
+	smtp, server := newPooledSMTP(t, time.Minute)
+	ctx := context.Background()
+
+	wg := sync.WaitGroup{}
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			err := smtp.Send(ctx, fmt.Sprintf("user%d@example.com", i), "hello", strings.NewReader("hello"), nil)
+			assert.NoError(t, err)
+		}(i)
+	}
+	wg.Wait()
+
+	assert.Len(t, server.Messages(), 20)
+	assert.LessOrEqual(t, server.Connections(), 2)
+}
+
+func TestSMTPPoolIdle(t *testing.T) {
+	smtp, server := newPooledSMTP(t, 10*time.Millisecond)
+	ctx := context.Background()
+
+	assert.NoError(t, smtp.Send(ctx, "bob@example.com", "hello", strings.NewReader("hello"), nil))
+	time.Sleep(20 * time.Millisecond)
+	assert.NoError(t, smtp.Send(ctx, "bob@example.com", "hello", strings.NewReader("hello"), nil))
+	assert.Equal(t, 2, server.Connections())
+}
+
+func TestSMTPWithoutPool(t *testing.T) {
+	server, err := emailtest.NewServer()
+	assert.NoError(t, err)
+	defer server.Close()
+
+	smtp, err := NewSMTP(server.Host(), server.Port(), WithFrom("noreply@example.com"), WithOptionalTLS(true))
+	assert.NoError(t, err)
+
+	ctx := context.Background()
+	for i := 0; i < 3; i++ {
+		assert.NoError(t, smtp.Send(ctx, "bob@example.com", "hello", strings.NewReader("hello"), nil))
+	}
+	assert.Equal(t, 3, server.Connections())
+	assert.Equal(t, int64(3), smtp.Metrics().Dials)
+}
+
+func TestSMTPPoolCanceled(t *testing.T) {
+	// a server accepting the connections but never answering
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	defer listener.Close()
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+		}
+	}()
+
+	addr := listener.Addr().(*net.TCPAddr)
+	smtp, err := NewSMTP("127.0.0.1", addr.Port, WithFrom("noreply@example.com"), WithPool(1, time.Minute))
+	assert.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	err = smtp.Send(ctx, "bob@example.com", "hello", strings.NewReader("hello"), nil)
+	assert.Error(t, err)
+	assert.Less(t, time.Since(start), time.Second)
+
+	assert.NoError(t, smtp.Close())
+	err = smtp.Send(context.Background(), "bob@example.com", "hello", strings.NewReader("hello"), nil)
+	assert.ErrorIs(t, err, ErrClosed)
+}
+
+func TestSMTPPoolClosed(t *testing.T) {
+	smtp, server := newPooledSMTP(t, time.Minute)
+	ctx := context.Background()
+
+	assert.NoError(t, smtp.Send(ctx, "bob@example.com", "hello", strings.NewReader("hello"), nil))
+	assert.NoError(t, smtp.Close())
+	err := smtp.Send(ctx, "bob@example.com", "hello", strings.NewReader("hello"), nil)
+	assert.ErrorIs(t, err, ErrClosed)
+	assert.Equal(t, 1, server.Connections())
+}
+
+func TestSMTPPoolShutdown(t *testing.T) {
+	// a server accepting the connections but never answering
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	defer listener.Close()
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+		}
+	}()
+
+	addr := listener.Addr().(*net.TCPAddr)
+	smtp, err := NewSMTP("127.0.0.1", addr.Port, WithFrom("noreply@example.com"), WithPool(1, time.Minute))
+	assert.NoError(t, err)
+
+	sent := make(chan error)
+	go func() {
+		sent <- smtp.Send(context.Background(), "bob@example.com", "hello", strings.NewReader("hello"), nil)
+	}()
+	time.Sleep(50 * time.Millisecond)
+
+	// the send without deadline is interrupted when the shutdown context is done
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	assert.ErrorIs(t, smtp.Shutdown(ctx), context.DeadlineExceeded)
+	assert.Less(t, time.Since(start), time.Second)
+	assert.Error(t, <-sent)
+
+	err = smtp.Send(context.Background(), "bob@example.com", "hello", strings.NewReader("hello"), nil)
+	assert.ErrorIs(t, err, ErrClosed)
+}
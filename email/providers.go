@@ -0,0 +1,354 @@
+package email
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+type (
+	// WebhookFormat posts the Message as JSON, with optional extra headers (ie: Authorization).
+	WebhookFormat struct {
+		Headers map[string]string
+	}
+
+	// PostmarkFormat uses the Postmark email API.
+	PostmarkFormat struct {
+		ServerToken string
+	}
+
+	// MailgunFormat uses the Mailgun messages API.
+	MailgunFormat struct {
+		Domain string
+		APIKey string
+	}
+
+	// SESFormat uses the Amazon SES v2 API with raw messages, signed with AWS Signature V4.
+	// The messages are also signed with DKIM when DKIM is set, see WithSESDKIM.
+	SESFormat struct {
+		Region    string
+		AccessKey string
+		SecretKey string
+		DKIM      *DKIM
+	}
+
+	postmarkHeader struct {
+		Name  string
+		Value string
+	}
+
+	postmarkAttachment struct {
+		Name        string
+		Content     string
+		ContentType string
+		ContentID   string `json:",omitempty"`
+	}
+
+	postmarkEmail struct {
+		From        string
+		To          string
+		Cc          string `json:",omitempty"`
+		Bcc         string `json:",omitempty"`
+		ReplyTo     string `json:",omitempty"`
+		Subject     string
+		TextBody    string               `json:",omitempty"`
+		HtmlBody    string               `json:",omitempty"`
+		Headers     []postmarkHeader     `json:",omitempty"`
+		Attachments []postmarkAttachment `json:",omitempty"`
+	}
+)
+
+const (
+	PostmarkEndpoint  = "https://api.postmarkapp.com"
+	MailgunEndpoint   = "https://api.mailgun.net"
+	MailgunEUEndpoint = "https://api.eu.mailgun.net"
+)
+
+// NewWebhook creates a sender posting the messages as JSON to url.
+func NewWebhook(url string, headers map[string]string, opts ...func(*HTTPEmail)) *HTTPEmail {
+	return NewHTTP(url, &WebhookFormat{Headers: headers}, opts...)
+}
+
+// NewPostmark creates a sender using the Postmark API.
+func NewPostmark(serverToken string, opts ...func(*HTTPEmail)) *HTTPEmail {
+	return NewHTTP(PostmarkEndpoint, &PostmarkFormat{ServerToken: serverToken}, opts...)
+}
+
+// NewMailgun creates a sender using the Mailgun API for the given domain.
+func NewMailgun(domain, apiKey string, opts ...func(*HTTPEmail)) *HTTPEmail {
+	return NewHTTP(MailgunEndpoint, &MailgunFormat{Domain: domain, APIKey: apiKey}, opts...)
+}
+
+// NewSES creates a sender using the Amazon SES v2 API in the given region.
+func NewSES(region, accessKey, secretKey string, opts ...func(*HTTPEmail)) *HTTPEmail {
+	format := &SESFormat{Region: region, AccessKey: accessKey, SecretKey: secretKey}
+	endpoint := fmt.Sprintf("https://email.%s.amazonaws.com", region)
+	return NewHTTP(endpoint, format, opts...)
+}
+
+// WithSESDKIM signs the messages of a SES sender with DKIM, it's ignored by the other providers.
+func WithSESDKIM(dkim *DKIM) func(*HTTPEmail) {
+	return func(e *HTTPEmail) {
+		if f, ok := e.format.(*SESFormat); ok {
+			f.DKIM = dkim
+		}
+	}
+}
+
+func (f *WebhookFormat) Request(ctx context.Context, endpoint string, msg *Message) (*http.Request, error) {
+	req, err := newJSONRequest(ctx, endpoint, msg)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range f.Headers {
+		req.Header.Set(k, v)
+	}
+	return req, nil
+}
+
+func (f *PostmarkFormat) Request(ctx context.Context, endpoint string, msg *Message) (*http.Request, error) {
+	body := postmarkEmail{
+		From:     msg.From,
+		To:       strings.Join(msg.To, ","),
+		Cc:       strings.Join(msg.CC, ","),
+		Bcc:      strings.Join(msg.BCC, ","),
+		ReplyTo:  msg.ReplyTo,
+		Subject:  msg.Subject,
+		TextBody: msg.Text,
+		HtmlBody: msg.HTML,
+	}
+	for _, k := range sortedKeys(msg.Headers) {
+		body.Headers = append(body.Headers, postmarkHeader{Name: k, Value: msg.Headers[k]})
+	}
+	for _, a := range msg.Attachments {
+		attachment := postmarkAttachment{
+			Name:        a.Name,
+			Content:     base64.StdEncoding.EncodeToString(a.Data),
+			ContentType: a.ContentType,
+		}
+		if attachment.ContentType == "" {
+			attachment.ContentType = "application/octet-stream"
+		}
+		if a.Inline() {
+			attachment.ContentID = "cid:" + a.ContentID
+		}
+		body.Attachments = append(body.Attachments, attachment)
+	}
+
+	req, err := newJSONRequest(ctx, strings.TrimSuffix(endpoint, "/")+"/email", body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("X-Postmark-Server-Token", f.ServerToken)
+	return req, nil
+}
+
+func (f *MailgunFormat) Request(ctx context.Context, endpoint string, msg *Message) (*http.Request, error) {
+	buff := &bytes.Buffer{}
+	form := multipart.NewWriter(buff)
+
+	fields := [][2]string{{"from", msg.From}, {"subject", msg.Subject}}
+	for _, to := range msg.To {
+		fields = append(fields, [2]string{"to", to})
+	}
+	for _, cc := range msg.CC {
+		fields = append(fields, [2]string{"cc", cc})
+	}
+	for _, bcc := range msg.BCC {
+		fields = append(fields, [2]string{"bcc", bcc})
+	}
+	if msg.Text != "" {
+		fields = append(fields, [2]string{"text", msg.Text})
+	}
+	if msg.HTML != "" {
+		fields = append(fields, [2]string{"html", msg.HTML})
+	}
+	if msg.ReplyTo != "" {
+		fields = append(fields, [2]string{"h:Reply-To", msg.ReplyTo})
+	}
+	for _, k := range sortedKeys(msg.Headers) {
+		fields = append(fields, [2]string{"h:" + k, msg.Headers[k]})
+	}
+	for _, field := range fields {
+		if err := form.WriteField(field[0], field[1]); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, a := range msg.Attachments {
+		// mailgun uses the file name as the content id of inline files
+		field, name := "attachment", a.Name
+		if a.Inline() {
+			field, name = "inline", a.ContentID
+		}
+		w, err := form.CreateFormFile(field, name)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := w.Write(a.Data); err != nil {
+			return nil, err
+		}
+	}
+	if err := form.Close(); err != nil {
+		return nil, err
+	}
+
+	url := fmt.Sprintf("%s/v3/%s/messages", strings.TrimSuffix(endpoint, "/"), f.Domain)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, buff)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", form.FormDataContentType())
+	req.SetBasicAuth("api", f.APIKey)
+	return req, nil
+}
+
+func (f *SESFormat) Request(ctx context.Context, endpoint string, msg *Message) (*http.Request, error) {
+	raw := &bytes.Buffer{}
+	if _, err := msg.WriteTo(raw); err != nil {
+		return nil, err
+	}
+	data := raw.Bytes()
+	if f.DKIM != nil {
+		signed, err := f.DKIM.Sign(data)
+		if err != nil {
+			return nil, err
+		}
+		data = signed
+	}
+
+	destination := map[string][]string{"ToAddresses": msg.To}
+	if len(msg.CC) > 0 {
+		destination["CcAddresses"] = msg.CC
+	}
+	if len(msg.BCC) > 0 {
+		destination["BccAddresses"] = msg.BCC
+	}
+	body := map[string]any{
+		"FromEmailAddress": msg.From,
+		"Destination":      destination,
+		"Content": map[string]any{
+			"Raw": map[string]string{"Data": base64.StdEncoding.EncodeToString(data)},
+		},
+	}
+
+	req, err := newJSONRequest(ctx, strings.TrimSuffix(endpoint, "/")+"/v2/email/outbound-emails", body)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := signV4(req, f.Region, "ses", f.AccessKey, f.SecretKey, time.Now().UTC()); err != nil {
+		return nil, err
+	}
+	return req, nil
+}
+
+// signV4 signs the request with the AWS Signature Version 4.
+func signV4(req *http.Request, region, service, accessKey, secretKey string, now time.Time) error {
+	body := []byte{}
+	if req.GetBody != nil {
+		r, err := req.GetBody()
+		if err != nil {
+			return err
+		}
+		buff := &bytes.Buffer{}
+		if _, err := buff.ReadFrom(r); err != nil {
+			return err
+		}
+		body = buff.Bytes()
+	}
+
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+	payloadHash := sha256Hex(body)
+
+	req.Header.Set("Host", req.URL.Host)
+	req.Header.Set("X-Amz-Date", amzDate)
+
+	headers := []string{}
+	for k := range req.Header {
+		headers = append(headers, strings.ToLower(k))
+	}
+	sort.Strings(headers)
+
+	canonicalHeaders := &strings.Builder{}
+	for _, h := range headers {
+		canonicalHeaders.WriteString(h + ":" + strings.TrimSpace(req.Header.Get(h)) + "\n")
+	}
+	signedHeaders := strings.Join(headers, ";")
+
+	path := req.URL.EscapedPath()
+	if path == "" {
+		path = "/"
+	}
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		path,
+		canonicalQuery(req.URL.Query()),
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	scope := fmt.Sprintf("%s/%s/%s/aws4_request", date, region, service)
+	stringToSign := strings.Join([]string{
+		"AWS4-HMAC-SHA256",
+		amzDate,
+		scope,
+		sha256Hex([]byte(canonicalRequest)),
+	}, "\n")
+
+	key := hmacSHA256([]byte("AWS4"+secretKey), date)
+	key = hmacSHA256(key, region)
+	key = hmacSHA256(key, service)
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Del("Host")
+	req.Header.Set("Authorization", fmt.Sprintf(
+		"AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		accessKey, scope, signedHeaders, signature))
+	return nil
+}
+
+func canonicalQuery(values url.Values) string {
+	parts := []string{}
+	for _, k := range sortedKeys(values) {
+		vs := append([]string{}, values[k]...)
+		sort.Strings(vs)
+		for _, v := range vs {
+			parts = append(parts, url.QueryEscape(k)+"="+url.QueryEscape(v))
+		}
+	}
+	return strings.ReplaceAll(strings.Join(parts, "&"), "+", "%20")
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+func sortedKeys[T any](m map[string]T) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,375 @@
+package email
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/fdelbos/commons/db"
+	"github.com/fdelbos/commons/internal/lease"
+	"github.com/fdelbos/commons/utils"
+)
+
+type (
+	// QueueStatus is the delivery status of a queued email.
+	QueueStatus string
+
+	// QueuedEmail is a row of the email_queue table.
+	QueuedEmail struct {
+		ID         int64       `db:"id"`
+		Status     QueueStatus `db:"status"`
+		Message    string      `db:"message"` // the JSON encoded Message
+		Attempts   int         `db:"attempts"`
+		LastError  *string     `db:"last_error"`
+		ClaimToken *string     `db:"claim_token"` // set while claimed, see lease.Table
+		RunAt      time.Time   `db:"run_at"`
+		CreatedAt  time.Time   `db:"created_at"`
+		UpdatedAt  time.Time   `db:"updated_at"`
+	}
+
+	// Queue is a durable outbound email queue stored in the database.
+	// Send and SendMessage only enqueue the email, so it can replace any
+	// auth.Mailer, while Run delivers the queued emails in the background
+	// with the wrapped sender, retrying failures with an exponential backoff.
+	// Permanent failures (see IsPermanent) are not retried, and the sent
+	// emails are deleted after the retention period.
+	//
+	// The email_queue table must exist, see QueuePgSchema, QueueSQLiteSchema and Migrate.
+	Queue struct {
+		db          db.DB
+		dialect     db.Dialect
+		sender      MessageSender
+		workers     int
+		batchSize   int
+		poll        time.Duration
+		lease       time.Duration
+		maxAttempts int
+		minBackoff  time.Duration
+		maxBackoff  time.Duration
+		retention   time.Duration
+	}
+)
+
+const (
+	QueuePending QueueStatus = "pending" // waiting to be sent
+	QueueSending QueueStatus = "sending" // claimed by a worker
+	QueueSent    QueueStatus = "sent"    // delivered to the sender
+	QueueDead    QueueStatus = "dead"    // failed too many times, see Requeue
+
+	DefaultQueueWorkers     = 1
+	DefaultQueueBatchSize   = 10
+	DefaultQueuePoll        = 5 * time.Second
+	DefaultQueueLease       = 5 * time.Minute
+	DefaultQueueMaxAttempts = 8
+	DefaultQueueMinBackoff  = 30 * time.Second
+	DefaultQueueMaxBackoff  = 2 * time.Hour
+	DefaultQueueRetention   = 7 * 24 * time.Hour
+
+	// delay between two cleanups of the sent emails
+	queueCleanupInterval = time.Hour
+
+	QueuePgSchema = `
+CREATE TABLE IF NOT EXISTS email_queue (
+	id BIGSERIAL PRIMARY KEY,
+	status TEXT NOT NULL DEFAULT 'pending',
+	message TEXT NOT NULL,
+	attempts INTEGER NOT NULL DEFAULT 0,
+	last_error TEXT,
+	claim_token TEXT,
+	run_at TIMESTAMPTZ NOT NULL,
+	created_at TIMESTAMPTZ NOT NULL,
+	updated_at TIMESTAMPTZ NOT NULL
+);
+CREATE INDEX IF NOT EXISTS email_queue_run_at_idx ON email_queue (run_at) WHERE status IN ('pending', 'sending');`
+
+	QueueSQLiteSchema = `
+CREATE TABLE IF NOT EXISTS email_queue (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	status TEXT NOT NULL DEFAULT 'pending',
+	message TEXT NOT NULL,
+	attempts INTEGER NOT NULL DEFAULT 0,
+	last_error TEXT,
+	claim_token TEXT,
+	run_at DATETIME NOT NULL,
+	created_at DATETIME NOT NULL,
+	updated_at DATETIME NOT NULL
+);
+CREATE INDEX IF NOT EXISTS email_queue_run_at_idx ON email_queue (run_at) WHERE status IN ('pending', 'sending');`
+
+	queueInsert = `
+INSERT INTO email_queue (status, message, attempts, run_at, created_at, updated_at)
+VALUES ($1, $2, 0, $3, $3, $3)
+RETURNING id`
+
+	queueCleanup = `
+DELETE FROM email_queue
+WHERE status = 'sent' AND updated_at < $1`
+)
+
+var (
+	queueTable = lease.Table{
+		Table:   "email_queue",
+		Claimed: string(QueueSending),
+		OrderBy: "run_at, id",
+	}
+)
+
+// NewQueue creates a queue storing the emails in database and delivering them with sender.
+func NewQueue(database db.DB, sender MessageSender, opts ...func(*Queue)) *Queue {
+	q := &Queue{
+		db:          database,
+		dialect:     db.DialectOf(database),
+		sender:      sender,
+		workers:     DefaultQueueWorkers,
+		batchSize:   DefaultQueueBatchSize,
+		poll:        DefaultQueuePoll,
+		lease:       DefaultQueueLease,
+		maxAttempts: DefaultQueueMaxAttempts,
+		minBackoff:  DefaultQueueMinBackoff,
+		maxBackoff:  DefaultQueueMaxBackoff,
+		retention:   DefaultQueueRetention,
+	}
+	for _, opt := range opts {
+		opt(q)
+	}
+	return q
+}
+
+// Migrate creates the email_queue table if it doesn't exist.
+func (q *Queue) Migrate(ctx context.Context) error {
+	schema := QueuePgSchema
+	if q.dialect == db.SQLite {
+		schema = QueueSQLiteSchema
+	}
+	return q.db.Query(ctx).Exec(schema)
+}
+
+// Send enqueues a simple email to a single recipient.
+func (q *Queue) Send(ctx context.Context, to, subject string, textReader, htmlReader io.Reader) error {
+	msg, err := NewMessage(to, subject, textReader, htmlReader)
+	if err != nil {
+		return err
+	}
+	return q.SendMessage(ctx, msg)
+}
+
+// SendMessage enqueues a complete message.
+func (q *Queue) SendMessage(ctx context.Context, msg *Message) error {
+	_, err := q.Enqueue(ctx, msg)
+	return err
+}
+
+// Enqueue stores the message in the queue and returns its id.
+// When ctx carries a transaction the email is only queued if the transaction commits.
+func (q *Queue) Enqueue(ctx context.Context, msg *Message) (int64, error) {
+	if err := msg.Validate(); err != nil {
+		return 0, err
+	}
+	raw, err := json.Marshal(msg)
+	if err != nil {
+		return 0, err
+	}
+
+	var id int64
+	now := time.Now().UTC()
+	err = q.db.Query(ctx).Get(&id, queueInsert, QueuePending, string(raw), now)
+	return id, err
+}
+
+// Run starts the workers and blocks until ctx is canceled, and deletes the old sent emails.
+// The emails being sent when ctx is canceled are completed before Run returns.
+func (q *Queue) Run(ctx context.Context) {
+	wg := sync.WaitGroup{}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		utils.Cron(ctx, queueCleanupInterval, func() {
+			if _, err := q.Cleanup(ctx); err != nil {
+				log.Printf("email/queue error while cleaning up the emails: %v", err)
+			}
+		})
+	}()
+
+	for i := 0; i < q.workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			q.work(ctx)
+		}()
+	}
+	wg.Wait()
+}
+
+func (q *Queue) work(ctx context.Context) {
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-timer.C:
+		}
+
+		n, err := q.Process(ctx)
+		if err != nil {
+			log.Printf("email/queue error while processing the queue: %v", err)
+		}
+		if n > 0 && err == nil {
+			// there may be more emails waiting
+			timer.Reset(0)
+		} else {
+			timer.Reset(q.poll)
+		}
+	}
+}
+
+// Process claims a batch of emails, sends them and returns the number of emails processed.
+// A failure to record the result of an email doesn't stop the batch, the errors are returned together.
+func (q *Queue) Process(ctx context.Context) (int, error) {
+	// the sends must complete before the end of the lease, or the emails could be sent twice
+	deadline := time.Now().Add(q.lease)
+	batch, err := q.claim(ctx)
+	if err != nil || len(batch) == 0 {
+		return 0, err
+	}
+
+	// complete the batch even if ctx is canceled, so no email stays claimed until the end of its lease.
+	sendCtx, cancel := context.WithDeadline(context.Background(), deadline)
+	defer cancel()
+
+	n := 0
+	errs := []error{}
+	for _, row := range batch {
+		if err := q.deliver(sendCtx, row); err != nil {
+			errs = append(errs, fmt.Errorf("email %d: %w", row.ID, err))
+			continue
+		}
+		n++
+	}
+	return n, errors.Join(errs...)
+}
+
+func (q *Queue) claim(ctx context.Context) ([]QueuedEmail, error) {
+	batch := []QueuedEmail{}
+	err := queueTable.Claim(ctx, q.db, &batch, q.batchSize, q.lease)
+	return batch, err
+}
+
+// deliver sends a claimed email with sendCtx and records the result.
+func (q *Queue) deliver(sendCtx context.Context, row QueuedEmail) error {
+	msg := &Message{}
+	err := json.Unmarshal([]byte(row.Message), msg)
+	if err == nil {
+		err = q.sender.SendMessage(sendCtx, msg)
+	}
+
+	// the result is recorded even when the send timed out
+	ctx := context.Background()
+
+	if err == nil {
+		return lostLease(row, queueTable.Update(ctx, q.db, row.ID, row.ClaimToken, string(QueueSent), nil, time.Now()))
+	}
+
+	if IsPermanent(err) {
+		lastError := err.Error()
+		recorded := queueTable.Update(ctx, q.db, row.ID, row.ClaimToken, string(QueueDead), &lastError, time.Now())
+		if recorded != nil {
+			return lostLease(row, recorded)
+		}
+		log.Printf("email/queue email %d is dead after a permanent failure: %v", row.ID, err)
+		return nil
+	}
+
+	delay, recorded := queueTable.Retry(ctx, q.db, row.ID, row.ClaimToken, err,
+		row.Attempts, q.maxAttempts, q.minBackoff, q.maxBackoff)
+	if recorded != nil {
+		return lostLease(row, recorded)
+	}
+	if row.Attempts >= q.maxAttempts {
+		log.Printf("email/queue email %d is dead after %d attempts: %v", row.ID, row.Attempts, err)
+	} else {
+		log.Printf("email/queue email %d failed, retrying in %s: %v", row.ID, delay, err)
+	}
+	return nil
+}
+
+// lostLease discards the result of an email whose lease was lost, it's sent again.
+func lostLease(row QueuedEmail, err error) error {
+	if errors.Is(err, lease.ErrLost) {
+		log.Printf("email/queue email %d lost its lease, the result is discarded", row.ID)
+		return nil
+	}
+	return err
+}
+
+// Dead returns the most recent dead emails.
+func (q *Queue) Dead(ctx context.Context, limit int) ([]QueuedEmail, error) {
+	res := []QueuedEmail{}
+	err := queueTable.Dead(ctx, q.db, &res, limit)
+	return res, err
+}
+
+// Requeue sends a dead email again.
+// db.ErrNotAffected is returned when there is no dead email with this id.
+func (q *Queue) Requeue(ctx context.Context, id int64) error {
+	return queueTable.Requeue(ctx, q.db, id)
+}
+
+// Cleanup deletes the emails sent before the retention period, and returns their number.
+func (q *Queue) Cleanup(ctx context.Context) (int64, error) {
+	res, err := q.db.Query(ctx).ExecResult(queueCleanup, time.Now().UTC().Add(-q.retention))
+	return res.RowsAffected, err
+}
+
+// WithQueueWorkers sets the number of concurrent workers. Default is 1.
+func WithQueueWorkers(workers int) func(*Queue) {
+	return func(q *Queue) {
+		q.workers = workers
+	}
+}
+
+// WithQueueBatchSize sets the number of emails claimed at once by a worker. Default is 10.
+func WithQueueBatchSize(size int) func(*Queue) {
+	return func(q *Queue) {
+		q.batchSize = size
+	}
+}
+
+// WithQueuePoll sets the delay between two polls of an empty queue. Default is 5 seconds.
+func WithQueuePoll(poll time.Duration) func(*Queue) {
+	return func(q *Queue) {
+		q.poll = poll
+	}
+}
+
+// WithQueueLease sets how long a claimed email is reserved to a worker,
+// after that it's considered lost and sent again. The sends of a batch are
+// canceled at the end of the lease. Default is 5 minutes.
+func WithQueueLease(lease time.Duration) func(*Queue) {
+	return func(q *Queue) {
+		q.lease = lease
+	}
+}
+
+// WithQueueRetries sets the maximum number of attempts and the backoff between attempts.
+// Defaults are 8 attempts, with a backoff from 30 seconds to 2 hours.
+func WithQueueRetries(maxAttempts int, minBackoff, maxBackoff time.Duration) func(*Queue) {
+	return func(q *Queue) {
+		q.maxAttempts = maxAttempts
+		q.minBackoff = minBackoff
+		q.maxBackoff = maxBackoff
+	}
+}
+
+// WithQueueRetention sets how long the sent emails are kept. Default is 7 days.
+func WithQueueRetention(retention time.Duration) func(*Queue) {
+	return func(q *Queue) {
+		q.retention = retention
+	}
+}
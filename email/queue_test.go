@@ -0,0 +1,201 @@
+package email_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/fdelbos/commons/auth"
+	"github.com/fdelbos/commons/db"
+	. "github.com/fdelbos/commons/email"
+	"github.com/fdelbos/commons/internal/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+var _ auth.Mailer = (*Queue)(nil)
+
+type flakySender struct {
+	mut      sync.Mutex
+	failures map[string]int // recipient -> number of failures before success
+	sent     []*Message
+}
+
+func (s *flakySender) SendMessage(ctx context.Context, msg *Message) error {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	to := msg.To[0]
+	if s.failures[to] > 0 {
+		s.failures[to]--
+		return errors.New("server unavailable")
+	}
+	s.sent = append(s.sent, msg)
+	return nil
+}
+
+func TestQueue(t *testing.T) {
+	conn := testutil.SQLite(t)
+	sender := &flakySender{
+		failures: map[string]int{
+			"flaky@example.com": 1,
+			"dead@example.com":  10,
+		},
+	}
+
+	queue := NewQueue(conn, sender, WithQueueRetries(3, 0, 0))
+	ctx := context.Background()
+	assert.NoError(t, queue.Migrate(ctx))
+
+	err := queue.Send(ctx, "ok@example.com", "hello", strings.NewReader("hello"), nil)
+	assert.NoError(t, err)
+	err = queue.SendMessage(ctx, &Message{To: []string{"flaky@example.com"}, Subject: "flaky"})
+	assert.NoError(t, err)
+	deadID, err := queue.Enqueue(ctx, &Message{To: []string{"dead@example.com"}, Subject: "dead"})
+	assert.NoError(t, err)
+
+	_, err = queue.Enqueue(ctx, &Message{Subject: "nobody"})
+	assert.ErrorIs(t, err, ErrNoRecipients)
+
+	// nothing is sent before processing
+	assert.Empty(t, sender.sent)
+
+	n, err := queue.Process(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, n)
+	assert.Len(t, sender.sent, 1)
+	assert.Equal(t, "hello", sender.sent[0].Text)
+
+	for i := 0; i < 2; i++ {
+		n, err = queue.Process(ctx)
+		assert.NoError(t, err)
+		assert.Equal(t, 2-i, n)
+	}
+	assert.Len(t, sender.sent, 2)
+
+	n, err = queue.Process(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, n)
+
+	dead, err := queue.Dead(ctx, 10)
+	assert.NoError(t, err)
+	assert.Len(t, dead, 1)
+	assert.Equal(t, deadID, dead[0].ID)
+	assert.Equal(t, 3, dead[0].Attempts)
+	assert.Equal(t, "server unavailable", *dead[0].LastError)
+
+	// requeue the dead letter once the server is back
+	sender.failures["dead@example.com"] = 0
+	assert.NoError(t, queue.Requeue(ctx, deadID))
+	n, err = queue.Process(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, n)
+	assert.Len(t, sender.sent, 3)
+
+	// only the dead emails can be requeued
+	assert.ErrorIs(t, queue.Requeue(ctx, deadID), db.ErrNotAffected)
+	assert.ErrorIs(t, queue.Requeue(ctx, 1234), db.ErrNotAffected)
+
+	// the sent emails are kept during the retention
+	deleted, err := queue.Cleanup(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(0), deleted)
+	deleted, err = NewQueue(conn, sender, WithQueueRetention(0)).Cleanup(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(3), deleted)
+}
+
+// hangingSender blocks until ctx is done for the recipients in hang.
+type hangingSender struct {
+	flakySender
+	hang string
+}
+
+func (s *hangingSender) SendMessage(ctx context.Context, msg *Message) error {
+	if msg.To[0] == s.hang {
+		<-ctx.Done()
+		return ctx.Err()
+	}
+	return s.flakySender.SendMessage(ctx, msg)
+}
+
+func TestQueueSendTimeout(t *testing.T) {
+	conn := testutil.SQLite(t)
+	sender := &hangingSender{hang: "hang@example.com"}
+	queue := NewQueue(conn, sender, WithQueueLease(50*time.Millisecond), WithQueueRetries(3, time.Hour, time.Hour))
+	ctx := context.Background()
+	assert.NoError(t, queue.Migrate(ctx))
+
+	_, err := queue.Enqueue(ctx, &Message{To: []string{"ok@example.com"}, Subject: "hello"})
+	assert.NoError(t, err)
+	_, err = queue.Enqueue(ctx, &Message{To: []string{"hang@example.com"}, Subject: "hello"})
+	assert.NoError(t, err)
+
+	// the hung send is canceled at the end of the lease, and retried later
+	n, err := queue.Process(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, n)
+	assert.Len(t, sender.sent, 1)
+
+	n, err = queue.Process(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, n)
+}
+
+func TestQueueRun(t *testing.T) {
+	conn := testutil.SQLite(t)
+	sender := &flakySender{}
+
+	queue := NewQueue(conn, sender, WithQueuePoll(10*time.Millisecond))
+	ctx, cancel := context.WithCancel(context.Background())
+	assert.NoError(t, queue.Migrate(ctx))
+
+	done := make(chan struct{})
+	go func() {
+		queue.Run(ctx)
+		close(done)
+	}()
+
+	for i := 0; i < 5; i++ {
+		err := queue.Send(ctx, fmt.Sprintf("user%d@example.com", i), "hello", strings.NewReader("hello"), nil)
+		assert.NoError(t, err)
+	}
+
+	assert.Eventually(t, func() bool {
+		sender.mut.Lock()
+		defer sender.mut.Unlock()
+		return len(sender.sent) == 5
+	}, time.Second, 10*time.Millisecond)
+
+	cancel()
+	<-done
+}
+
+type rejectingSender struct{}
+
+func (rejectingSender) SendMessage(ctx context.Context, msg *Message) error {
+	return &HTTPError{StatusCode: 422, Body: "invalid recipient"}
+}
+
+func TestQueuePermanentFailure(t *testing.T) {
+	conn := testutil.SQLite(t)
+	queue := NewQueue(conn, rejectingSender{}, WithQueueRetries(3, 0, 0))
+	ctx := context.Background()
+	assert.NoError(t, queue.Migrate(ctx))
+
+	id, err := queue.Enqueue(ctx, &Message{To: []string{"invalid@example.com"}, Subject: "hello"})
+	assert.NoError(t, err)
+
+	n, err := queue.Process(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, n)
+
+	// not retried
+	dead, err := queue.Dead(ctx, 10)
+	assert.NoError(t, err)
+	assert.Len(t, dead, 1)
+	assert.Equal(t, id, dead[0].ID)
+	assert.Equal(t, 1, dead[0].Attempts)
+}
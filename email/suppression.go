@@ -0,0 +1,265 @@
+package email
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/fdelbos/commons/auth"
+	"github.com/fdelbos/commons/db"
+	"github.com/fdelbos/commons/utils"
+)
+
+type (
+	// SuppressionReason is the reason why an address is suppressed.
+	SuppressionReason string
+
+	// Suppression is a row of the email_suppressions table.
+	Suppression struct {
+		Email     string            `db:"email"` // normalized with utils.NormalizeEmail
+		Reason    SuppressionReason `db:"reason"`
+		Detail    string            `db:"detail"`
+		CreatedAt time.Time         `db:"created_at"`
+	}
+
+	// Suppressions is a list of addresses that must not receive emails anymore,
+	// because they bounced or complained, stored in the database.
+	//
+	// The email_suppressions table must exist, see SuppressionPgSchema,
+	// SuppressionSQLiteSchema and Migrate.
+	Suppressions struct {
+		db      db.DB
+		dialect db.Dialect
+	}
+
+	// SuppressionMailer is a mailer that doesn't send to the suppressed addresses.
+	SuppressionMailer struct {
+		mailer       auth.Mailer
+		suppressions *Suppressions
+	}
+)
+
+const (
+	SuppressionBounce    SuppressionReason = "bounce"    // permanent delivery failure
+	SuppressionComplaint SuppressionReason = "complaint" // marked as spam by the recipient
+	SuppressionManual    SuppressionReason = "manual"    // ie: unsubscribed
+
+	SuppressionPgSchema = `
+CREATE TABLE IF NOT EXISTS email_suppressions (
+	email TEXT PRIMARY KEY,
+	reason TEXT NOT NULL,
+	detail TEXT NOT NULL DEFAULT '',
+	created_at TIMESTAMPTZ NOT NULL
+);`
+
+	SuppressionSQLiteSchema = `
+CREATE TABLE IF NOT EXISTS email_suppressions (
+	email TEXT PRIMARY KEY,
+	reason TEXT NOT NULL,
+	detail TEXT NOT NULL DEFAULT '',
+	created_at DATETIME NOT NULL
+);`
+
+	suppressionInsert = `
+INSERT INTO email_suppressions (email, reason, detail, created_at)
+VALUES ($1, $2, $3, $4)
+ON CONFLICT (email) DO UPDATE SET reason = excluded.reason, detail = excluded.detail`
+
+	suppressionGet = `
+SELECT * FROM email_suppressions
+WHERE email = $1`
+
+	suppressionDelete = `
+DELETE FROM email_suppressions
+WHERE email = $1`
+
+	suppressionList = `
+SELECT * FROM email_suppressions
+ORDER BY created_at DESC, email
+LIMIT $1`
+
+	suppressionIn = `
+SELECT email FROM email_suppressions
+WHERE email IN (%s)`
+)
+
+// ErrSuppressed is returned when all the recipients of a message are suppressed.
+var ErrSuppressed = errors.New("the recipients are suppressed")
+
+// NewSuppressions creates a suppression list stored in database.
+func NewSuppressions(database db.DB) *Suppressions {
+	return &Suppressions{
+		db:      database,
+		dialect: db.DialectOf(database),
+	}
+}
+
+// Migrate creates the email_suppressions table if it doesn't exist.
+func (s *Suppressions) Migrate(ctx context.Context) error {
+	schema := SuppressionPgSchema
+	if s.dialect == db.SQLite {
+		schema = SuppressionSQLiteSchema
+	}
+	return s.db.Query(ctx).Exec(schema)
+}
+
+// Add suppresses an address, or updates the reason of a suppressed address.
+func (s *Suppressions) Add(ctx context.Context, email string, reason SuppressionReason, detail string) error {
+	email, err := utils.NormalizeEmail(email)
+	if err != nil {
+		return err
+	}
+	return s.db.Query(ctx).Exec(suppressionInsert, email, reason, detail, time.Now().UTC())
+}
+
+// Remove removes an address from the list, ie: when a user fixed their mailbox.
+func (s *Suppressions) Remove(ctx context.Context, email string) error {
+	return s.db.Query(ctx).Exec(suppressionDelete, normalizeEmail(email))
+}
+
+// Get returns the suppression of an address, or db.ErrNoRows if it is not suppressed.
+func (s *Suppressions) Get(ctx context.Context, email string) (*Suppression, error) {
+	res := &Suppression{}
+	if err := s.db.Query(ctx).Get(res, suppressionGet, normalizeEmail(email)); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// List returns the most recent suppressions.
+func (s *Suppressions) List(ctx context.Context, limit int) ([]Suppression, error) {
+	res := []Suppression{}
+	err := s.db.Query(ctx).Select(&res, suppressionList, limit)
+	return res, err
+}
+
+// IsSuppressed returns true if the address is suppressed.
+func (s *Suppressions) IsSuppressed(ctx context.Context, email string) (bool, error) {
+	suppressed, err := s.Suppressed(ctx, email)
+	return len(suppressed) > 0, err
+}
+
+// Suppressed returns the given addresses that are suppressed, as they were given.
+// It reads the primary, so an address suppressed by a bounce isn't sent to again.
+func (s *Suppressions) Suppressed(ctx context.Context, emails ...string) ([]string, error) {
+	if len(emails) == 0 {
+		return nil, nil
+	}
+	placeholders := make([]string, len(emails))
+	args := make([]any, len(emails))
+	for i, email := range emails {
+		placeholders[i] = fmt.Sprintf("$%d", i+1)
+		args[i] = normalizeEmail(email)
+	}
+
+	found := []string{}
+	query := fmt.Sprintf(suppressionIn, strings.Join(placeholders, ", "))
+	if err := s.db.Query(db.WithPrimary(ctx)).Select(&found, query, args...); err != nil {
+		return nil, err
+	}
+
+	res := []string{}
+	for _, email := range emails {
+		for _, f := range found {
+			if normalizeEmail(email) == f {
+				res = append(res, email)
+				break
+			}
+		}
+	}
+	return res, nil
+}
+
+// HandleBounce parses a bounce or complaint report (see ParseBounce) and suppresses
+// its recipients that failed permanently or complained.
+func (s *Suppressions) HandleBounce(ctx context.Context, r io.Reader) (*Bounce, error) {
+	bounce, err := ParseBounce(r)
+	if err != nil {
+		return nil, err
+	}
+	for _, rcpt := range bounce.Recipients {
+		switch {
+		case bounce.Complaint:
+			err = s.Add(ctx, rcpt.Email, SuppressionComplaint, rcpt.Diagnostic)
+		case rcpt.Permanent():
+			err = s.Add(ctx, rcpt.Email, SuppressionBounce, strings.TrimSpace(rcpt.Status+" "+rcpt.Diagnostic))
+		default:
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return bounce, nil
+}
+
+// NewSuppressionMailer creates a mailer removing the suppressed addresses from the recipients
+// of the messages before sending them with mailer. ErrSuppressed is returned when no
+// recipient is left.
+func NewSuppressionMailer(mailer auth.Mailer, suppressions *Suppressions) *SuppressionMailer {
+	return &SuppressionMailer{
+		mailer:       mailer,
+		suppressions: suppressions,
+	}
+}
+
+// Send sends a simple email to a single recipient.
+func (m *SuppressionMailer) Send(ctx context.Context, to, subject string, textReader, htmlReader io.Reader) error {
+	msg, err := NewMessage(to, subject, textReader, htmlReader)
+	if err != nil {
+		return err
+	}
+	return m.SendMessage(ctx, msg)
+}
+
+// SendMessage sends a complete message to its recipients that are not suppressed.
+func (m *SuppressionMailer) SendMessage(ctx context.Context, msg *Message) error {
+	if err := msg.Validate(); err != nil {
+		return err
+	}
+	suppressed, err := m.suppressions.Suppressed(ctx, msg.Recipients()...)
+	if err != nil {
+		return err
+	}
+	if len(suppressed) == 0 {
+		return sendWith(ctx, m.mailer, msg)
+	}
+
+	filtered := *msg
+	filtered.To = without(msg.To, suppressed)
+	filtered.CC = without(msg.CC, suppressed)
+	filtered.BCC = without(msg.BCC, suppressed)
+	if len(filtered.Recipients()) == 0 {
+		return fmt.Errorf("%w: %s", ErrSuppressed, strings.Join(suppressed, ", "))
+	}
+	return sendWith(ctx, m.mailer, &filtered)
+}
+
+// normalizeEmail normalizes email with utils.NormalizeEmail, or only lowercases
+// it when it is invalid.
+func normalizeEmail(email string) string {
+	if normalized, err := utils.NormalizeEmail(email); err == nil {
+		return normalized
+	}
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+func without(emails, removed []string) []string {
+	res := []string{}
+	for _, email := range emails {
+		keep := true
+		for _, r := range removed {
+			if email == r {
+				keep = false
+				break
+			}
+		}
+		if keep {
+			res = append(res, email)
+		}
+	}
+	return res
+}
@@ -0,0 +1,111 @@
+package email_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/fdelbos/commons/db"
+	. "github.com/fdelbos/commons/email"
+	"github.com/fdelbos/commons/email/emailtest"
+	"github.com/fdelbos/commons/internal/testutil"
+	"github.com/fdelbos/commons/utils"
+	"github.com/stretchr/testify/assert"
+)
+
+func newSuppressions(t *testing.T) *Suppressions {
+	suppressions := NewSuppressions(testutil.SQLite(t))
+	assert.NoError(t, suppressions.Migrate(context.Background()))
+	return suppressions
+}
+
+func TestSuppressions(t *testing.T) {
+	suppressions := newSuppressions(t)
+	ctx := context.Background()
+
+	assert.NoError(t, suppressions.Add(ctx, " Bob@Example.com", SuppressionManual, "unsubscribed"))
+	assert.ErrorIs(t, suppressions.Add(ctx, "not an email", SuppressionManual, ""), utils.ErrInvalidEmail)
+
+	suppressed, err := suppressions.IsSuppressed(ctx, "bob@example.com")
+	assert.NoError(t, err)
+	assert.True(t, suppressed)
+
+	found, err := suppressions.Suppressed(ctx, "alice@example.com", "BOB@example.com")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"BOB@example.com"}, found)
+
+	// adding again updates the reason
+	assert.NoError(t, suppressions.Add(ctx, "bob@example.com", SuppressionBounce, "550 5.1.1"))
+	suppression, err := suppressions.Get(ctx, "bob@example.com")
+	assert.NoError(t, err)
+	assert.Equal(t, "bob@example.com", suppression.Email)
+	assert.Equal(t, SuppressionBounce, suppression.Reason)
+	assert.Equal(t, "550 5.1.1", suppression.Detail)
+
+	list, err := suppressions.List(ctx, 10)
+	assert.NoError(t, err)
+	assert.Len(t, list, 1)
+
+	assert.NoError(t, suppressions.Remove(ctx, "Bob@example.com"))
+	_, err = suppressions.Get(ctx, "bob@example.com")
+	assert.True(t, db.IsErrNoRows(err))
+}
+
+func TestSuppressedReplica(t *testing.T) {
+	conn := testutil.NewReplica(t)
+	suppressions := NewSuppressions(conn)
+	ctx := context.Background()
+	assert.NoError(t, suppressions.Migrate(ctx))
+
+	// the suppressions checked before a send are read on the primary
+	assert.NoError(t, suppressions.Add(ctx, "bob@example.com", SuppressionBounce, "550 5.1.1"))
+	suppressed, err := suppressions.IsSuppressed(ctx, "bob@example.com")
+	assert.NoError(t, err)
+	assert.True(t, suppressed)
+	assert.Equal(t, int64(0), conn.Reads())
+}
+
+func TestHandleBounce(t *testing.T) {
+	suppressions := newSuppressions(t)
+	ctx := context.Background()
+
+	_, err := suppressions.HandleBounce(ctx, strings.NewReader(dsnBounce))
+	assert.NoError(t, err)
+	_, err = suppressions.HandleBounce(ctx, strings.NewReader(arfComplaint))
+	assert.NoError(t, err)
+
+	bob, err := suppressions.Get(ctx, "bob@example.com")
+	assert.NoError(t, err)
+	assert.Equal(t, SuppressionBounce, bob.Reason)
+	assert.Contains(t, bob.Detail, "5.1.1")
+
+	dave, err := suppressions.Get(ctx, "dave@isp.example.net")
+	assert.NoError(t, err)
+	assert.Equal(t, SuppressionComplaint, dave.Reason)
+
+	// delayed deliveries are not suppressed
+	suppressed, err := suppressions.IsSuppressed(ctx, "carol@example.com")
+	assert.NoError(t, err)
+	assert.False(t, suppressed)
+}
+
+func TestSuppressionMailer(t *testing.T) {
+	suppressions := newSuppressions(t)
+	capture := emailtest.NewMailer()
+	mailer := NewSuppressionMailer(capture, suppressions)
+	ctx := context.Background()
+
+	assert.NoError(t, suppressions.Add(ctx, "bob@example.com", SuppressionBounce, ""))
+
+	err := mailer.Send(ctx, "Bob@Example.com", "hello", strings.NewReader("hello"), nil)
+	assert.ErrorIs(t, err, ErrSuppressed)
+	assert.True(t, IsPermanent(err))
+	assert.Equal(t, 0, capture.Count())
+
+	msg := &Message{To: []string{"alice@example.com"}, CC: []string{"bob@example.com"}, Subject: "hello"}
+	assert.NoError(t, mailer.SendMessage(ctx, msg))
+	assert.Equal(t, []string{"alice@example.com"}, capture.Last().To)
+	assert.Empty(t, capture.Last().CC)
+	// the message of the caller is not modified
+	assert.Equal(t, []string{"bob@example.com"}, msg.CC)
+}
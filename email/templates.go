@@ -0,0 +1,300 @@
+package email
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	tmplHTML "html/template"
+	"io/fs"
+	"path"
+	"strings"
+	tmplText "text/template"
+
+	"github.com/fdelbos/commons/auth"
+)
+
+type (
+	// Templates renders and sends named emails loaded from a fs.FS.
+	//
+	// Each directory at the root of the fs is a template (ie: "welcome", "reset_password")
+	// containing a subject.txt file and a body.txt and/or a body.html file.
+	// The layouts and partials directories are reserved: their *.txt and *.html files are
+	// shared by all the text and HTML templates, so a body can render a layout
+	// with {{template "layout.html" .}} and fill its blocks with {{define "content"}}.
+	Templates struct {
+		mailer    auth.Mailer
+		funcs     map[string]any
+		inlineCSS bool
+		templates map[string]*emailTemplate
+	}
+
+	emailTemplate struct {
+		subject *tmplText.Template
+		text    *tmplText.Template
+		html    *tmplHTML.Template
+	}
+
+	// Template is a typed handle on a named template of Templates.
+	Template[T any] struct {
+		templates *Templates
+		name      string
+	}
+
+	// Rendered is a rendered email, ready to be sent.
+	Rendered struct {
+		Subject string
+		Text    string
+		HTML    string
+	}
+)
+
+const (
+	SubjectFile = "subject.txt"
+	TextFile    = "body.txt"
+	HTMLFile    = "body.html"
+	LayoutsDir  = "layouts"
+	PartialsDir = "partials"
+)
+
+var (
+	ErrTemplateNotFound = errors.New("email template not found")
+	ErrNoMailer         = errors.New("no mailer configured")
+)
+
+// NewTemplates loads all the templates of fsys.
+// The mailer is used by Send and can be nil if the templates are only rendered.
+func NewTemplates(fsys fs.FS, mailer auth.Mailer, opts ...func(*Templates)) (*Templates, error) {
+	t := &Templates{
+		mailer:    mailer,
+		funcs:     map[string]any{},
+		inlineCSS: true,
+		templates: map[string]*emailTemplate{},
+	}
+	for _, opt := range opts {
+		opt(t)
+	}
+
+	if err := t.load(fsys); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+func (t *Templates) load(fsys fs.FS) error {
+	baseText := tmplText.New("").Funcs(t.funcs)
+	baseHTML := tmplHTML.New("").Funcs(t.funcs)
+
+	for _, dir := range []string{LayoutsDir, PartialsDir} {
+		if err := parseShared(fsys, dir, ".txt", func(name, content string) error {
+			_, err := baseText.New(name).Parse(content)
+			return err
+		}); err != nil {
+			return err
+		}
+		if err := parseShared(fsys, dir, ".html", func(name, content string) error {
+			_, err := baseHTML.New(name).Parse(content)
+			return err
+		}); err != nil {
+			return err
+		}
+	}
+
+	entries, err := fs.ReadDir(fsys, ".")
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		name := entry.Name()
+		if !entry.IsDir() || name == LayoutsDir || name == PartialsDir {
+			continue
+		}
+
+		tmpl, err := t.parseTemplate(fsys, name, baseText, baseHTML)
+		if err != nil {
+			return fmt.Errorf("email: invalid template %s: %w", name, err)
+		}
+		t.templates[name] = tmpl
+	}
+	return nil
+}
+
+func parseShared(fsys fs.FS, dir, ext string, parse func(name, content string) error) error {
+	files, err := fs.Glob(fsys, path.Join(dir, "*"+ext))
+	if err != nil {
+		return err
+	}
+	for _, file := range files {
+		raw, err := fs.ReadFile(fsys, file)
+		if err != nil {
+			return err
+		}
+		if err := parse(path.Base(file), string(raw)); err != nil {
+			return fmt.Errorf("email: invalid template %s: %w", file, err)
+		}
+	}
+	return nil
+}
+
+func (t *Templates) parseTemplate(fsys fs.FS, name string, baseText *tmplText.Template, baseHTML *tmplHTML.Template) (*emailTemplate, error) {
+	readFile := func(file string) (string, bool, error) {
+		raw, err := fs.ReadFile(fsys, path.Join(name, file))
+		if errors.Is(err, fs.ErrNotExist) {
+			return "", false, nil
+		}
+		return string(raw), err == nil, err
+	}
+
+	subject, found, err := readFile(SubjectFile)
+	if err != nil {
+		return nil, err
+	}
+	if !found {
+		return nil, fmt.Errorf("missing %s", SubjectFile)
+	}
+
+	res := &emailTemplate{}
+	res.subject, err = tmplText.New("subject").Funcs(t.funcs).Parse(strings.TrimSpace(subject))
+	if err != nil {
+		return nil, err
+	}
+
+	if text, found, err := readFile(TextFile); err != nil {
+		return nil, err
+	} else if found {
+		clone, err := baseText.Clone()
+		if err != nil {
+			return nil, err
+		}
+		if res.text, err = clone.New(TextFile).Parse(text); err != nil {
+			return nil, err
+		}
+	}
+
+	if html, found, err := readFile(HTMLFile); err != nil {
+		return nil, err
+	} else if found {
+		clone, err := baseHTML.Clone()
+		if err != nil {
+			return nil, err
+		}
+		if res.html, err = clone.New(HTMLFile).Parse(html); err != nil {
+			return nil, err
+		}
+	}
+
+	if res.text == nil && res.html == nil {
+		return nil, fmt.Errorf("missing %s or %s", TextFile, HTMLFile)
+	}
+	return res, nil
+}
+
+// Names returns the names of the loaded templates.
+func (t *Templates) Names() []string {
+	names := make([]string, 0, len(t.templates))
+	for name := range t.templates {
+		names = append(names, name)
+	}
+	return names
+}
+
+// Render renders the named template with data.
+// The CSS of the HTML part is inlined, and the text part is generated from the HTML
+// part when the template has no body.txt.
+func (t *Templates) Render(name string, data any) (*Rendered, error) {
+	tmpl, ok := t.templates[name]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrTemplateNotFound, name)
+	}
+
+	res := &Rendered{}
+	buff := &bytes.Buffer{}
+	if err := tmpl.subject.Execute(buff, data); err != nil {
+		return nil, err
+	}
+	res.Subject = buff.String()
+
+	if tmpl.html != nil {
+		buff.Reset()
+		if err := tmpl.html.Execute(buff, data); err != nil {
+			return nil, err
+		}
+		res.HTML = buff.String()
+
+		if t.inlineCSS {
+			html, err := InlineCSS(res.HTML)
+			if err != nil {
+				return nil, err
+			}
+			res.HTML = html
+		}
+	}
+
+	if tmpl.text != nil {
+		buff.Reset()
+		if err := tmpl.text.Execute(buff, data); err != nil {
+			return nil, err
+		}
+		res.Text = buff.String()
+	} else {
+		text, err := HTMLToText(res.HTML)
+		if err != nil {
+			return nil, err
+		}
+		res.Text = text
+	}
+
+	return res, nil
+}
+
+// Send renders the named template with data and sends it to the given email.
+func (t *Templates) Send(ctx context.Context, name, to string, data any) error {
+	if t.mailer == nil {
+		return ErrNoMailer
+	}
+	rendered, err := t.Render(name, data)
+	if err != nil {
+		return err
+	}
+	return t.mailer.Send(
+		ctx,
+		to,
+		rendered.Subject,
+		strings.NewReader(rendered.Text),
+		strings.NewReader(rendered.HTML))
+}
+
+// Typed returns a handle on the named template that only accepts data of type T.
+func Typed[T any](t *Templates, name string) (*Template[T], error) {
+	if _, ok := t.templates[name]; !ok {
+		return nil, fmt.Errorf("%w: %s", ErrTemplateNotFound, name)
+	}
+	return &Template[T]{templates: t, name: name}, nil
+}
+
+// Render renders the template with data.
+func (t *Template[T]) Render(data T) (*Rendered, error) {
+	return t.templates.Render(t.name, data)
+}
+
+// Send renders the template with data and sends it to the given email.
+func (t *Template[T]) Send(ctx context.Context, to string, data T) error {
+	return t.templates.Send(ctx, t.name, to, data)
+}
+
+// WithTemplateFuncs adds functions available in all the templates.
+func WithTemplateFuncs(funcs map[string]any) func(*Templates) {
+	return func(t *Templates) {
+		for k, v := range funcs {
+			t.funcs[k] = v
+		}
+	}
+}
+
+// WithCSSInlining enables or disables the inlining of the CSS of the HTML part.
+// Default is enabled.
+func WithCSSInlining(enabled bool) func(*Templates) {
+	return func(t *Templates) {
+		t.inlineCSS = enabled
+	}
+}
@@ -0,0 +1,105 @@
+package email_test
+
+import (
+	"context"
+	"io"
+	"testing"
+	"testing/fstest"
+
+	. "github.com/fdelbos/commons/email"
+	"github.com/fdelbos/commons/internal/mocks"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+type welcomeData struct {
+	Name string
+	URL  string
+}
+
+var templatesFS = fstest.MapFS{
+	"layouts/layout.html": {Data: []byte(`<html><head><style>
+p { color: red }
+.note { font-size: 12px }
+a:hover { color: blue }
+@media (max-width: 600px) { p { color: green } }
+</style></head><body>{{template "content" .}}{{template "footer.html"}}</body></html>`)},
+	"partials/footer.html": {Data: []byte(`<p id="footer" style="margin: 0">The team</p>`)},
+	"partials/footer.txt":  {Data: []byte(`The team`)},
+
+	"welcome/subject.txt": {Data: []byte("Welcome {{.Name}}\n")},
+	"welcome/body.html": {Data: []byte(`{{template "layout.html" .}}{{define "content"}}<h1>Hello {{.Name}}</h1>` +
+		`<p class="note">Please <a href="{{.URL}}">confirm</a> your email.</p><ul><li>one</li><li>two</li></ul>{{end}}`)},
+
+	"reset/subject.txt": {Data: []byte("Reset")},
+	"reset/body.txt":    {Data: []byte("Go to {{.URL}}\n{{template \"footer.txt\"}}")},
+}
+
+func TestTemplatesRender(t *testing.T) {
+	tmpls, err := NewTemplates(templatesFS, nil)
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, []string{"welcome", "reset"}, tmpls.Names())
+
+	welcome, err := Typed[welcomeData](tmpls, "welcome")
+	assert.NoError(t, err)
+
+	rendered, err := welcome.Render(welcomeData{Name: "Bob", URL: "https://example.com/confirm"})
+	assert.NoError(t, err)
+	assert.Equal(t, "Welcome Bob", rendered.Subject)
+	assert.Contains(t, rendered.HTML, `<p class="note" style="color: red; font-size: 12px;">`)
+	assert.Contains(t, rendered.HTML, `<p id="footer" style="color: red; margin: 0;">`)
+	assert.Contains(t, rendered.HTML, `a:hover {`)
+	assert.Contains(t, rendered.HTML, `@media (max-width: 600px)`)
+	assert.Equal(t,
+		"Hello Bob\n\nPlease confirm (https://example.com/confirm) your email.\n\n- one\n- two\n\nThe team",
+		rendered.Text)
+
+	rendered, err = tmpls.Render("reset", welcomeData{URL: "https://example.com/reset"})
+	assert.NoError(t, err)
+	assert.Equal(t, "Go to https://example.com/reset\nThe team", rendered.Text)
+	assert.Equal(t, "", rendered.HTML)
+
+	_, err = tmpls.Render("unknown", nil)
+	assert.ErrorIs(t, err, ErrTemplateNotFound)
+
+	_, err = Typed[welcomeData](tmpls, "unknown")
+	assert.ErrorIs(t, err, ErrTemplateNotFound)
+}
+
+func TestTemplatesSend(t *testing.T) {
+	mailer := mocks.NewAuthMailer(t)
+	tmpls, err := NewTemplates(templatesFS, mailer, WithCSSInlining(false))
+	assert.NoError(t, err)
+
+	ctx := context.Background()
+	mailer.
+		On("Send", ctx, "bob@example.com", "Welcome Bob", mock.Anything, mock.Anything).
+		Return(func(ctx context.Context, to, subject string, text, html io.Reader) error {
+			raw, err := io.ReadAll(html)
+			assert.NoError(t, err)
+			assert.Contains(t, string(raw), "<style>")
+			return nil
+		}).
+		Once()
+
+	err = tmpls.Send(ctx, "welcome", "bob@example.com", welcomeData{Name: "Bob"})
+	assert.NoError(t, err)
+}
+
+func TestTemplatesErrors(t *testing.T) {
+	_, err := NewTemplates(fstest.MapFS{
+		"welcome/body.txt": {Data: []byte("hello")},
+	}, nil)
+	assert.Error(t, err)
+
+	_, err = NewTemplates(fstest.MapFS{
+		"welcome/subject.txt": {Data: []byte("hello")},
+	}, nil)
+	assert.Error(t, err)
+
+	_, err = NewTemplates(fstest.MapFS{
+		"welcome/subject.txt": {Data: []byte("hello")},
+		"welcome/body.html":   {Data: []byte("{{.Name")},
+	}, nil)
+	assert.Error(t, err)
+}
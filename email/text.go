@@ -0,0 +1,111 @@
+package email
+
+import (
+	"regexp"
+	"strings"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+var (
+	textSpaces   = regexp.MustCompile(`[ \t\r\f\v]+`)
+	textNewlines = regexp.MustCompile(`\n{3,}`)
+
+	textSkipped = map[atom.Atom]bool{
+		atom.Head:   true,
+		atom.Title:  true,
+		atom.Style:  true,
+		atom.Script: true,
+	}
+
+	textBlocks = map[atom.Atom]bool{
+		atom.Address:    true,
+		atom.Article:    true,
+		atom.Blockquote: true,
+		atom.Div:        true,
+		atom.Footer:     true,
+		atom.H1:         true,
+		atom.H2:         true,
+		atom.H3:         true,
+		atom.H4:         true,
+		atom.H5:         true,
+		atom.H6:         true,
+		atom.Header:     true,
+		atom.Hr:         true,
+		atom.Ol:         true,
+		atom.P:          true,
+		atom.Pre:        true,
+		atom.Section:    true,
+		atom.Table:      true,
+		atom.Tr:         true,
+		atom.Ul:         true,
+	}
+)
+
+// HTMLToText generates a plain text version of an HTML document, to be used as the
+// text part of an email when no text template is provided.
+// Links are rendered as "label (url)" and list items are prefixed with "- ".
+func HTMLToText(doc string) (string, error) {
+	root, err := html.Parse(strings.NewReader(doc))
+	if err != nil {
+		return "", err
+	}
+
+	buff := &strings.Builder{}
+	writeText(buff, root)
+
+	lines := strings.Split(buff.String(), "\n")
+	for i, line := range lines {
+		lines[i] = strings.TrimSpace(textSpaces.ReplaceAllString(line, " "))
+	}
+	text := strings.Join(lines, "\n")
+	text = textNewlines.ReplaceAllString(text, "\n\n")
+	return strings.TrimSpace(text), nil
+}
+
+func writeText(buff *strings.Builder, n *html.Node) {
+	switch n.Type {
+	case html.TextNode:
+		buff.WriteString(strings.ReplaceAll(n.Data, "\n", " "))
+		return
+
+	case html.ElementNode:
+		if textSkipped[n.DataAtom] {
+			return
+		}
+		switch n.DataAtom {
+		case atom.Br:
+			buff.WriteString("\n")
+			return
+		case atom.Img:
+			buff.WriteString(attr(n, "alt"))
+			return
+		case atom.Li:
+			buff.WriteString("\n- ")
+		case atom.Td, atom.Th:
+			buff.WriteString(" ")
+		}
+		if textBlocks[n.DataAtom] {
+			buff.WriteString("\n\n")
+		}
+	}
+
+	start := buff.Len()
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		writeText(buff, c)
+	}
+
+	if n.Type == html.ElementNode {
+		if n.DataAtom == atom.A {
+			href := attr(n, "href")
+			label := strings.TrimSpace(buff.String()[start:])
+			if href != "" && href != label && !strings.HasPrefix(href, "#") {
+				buff.WriteString(" (" + href + ")")
+			}
+		}
+		if textBlocks[n.DataAtom] {
+			buff.WriteString("\n\n")
+		}
+	}
+}
@@ -0,0 +1,143 @@
+// Package lease claims the rows of the work queue tables for the jobs and the email queue.
+package lease
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/fdelbos/commons/db"
+	"github.com/fdelbos/commons/utils"
+)
+
+type (
+	// Table claims the rows of a work queue table for a lease: a claimed row is
+	// reserved to its worker until the end of the lease from its claim (its updated_at),
+	// then it can be claimed again (ie: the worker crashed). The run_at of the claimed
+	// rows is kept, so the claims can be sorted in the order of OrderBy. Each claim sets a new token in the claim_token column,
+	// and the results are only recorded while the row still holds the token of the worker.
+	//
+	// The table must have the id, status, attempts, last_error, run_at, updated_at and
+	// claim_token columns, the claimable rows are pending and the failed rows are dead.
+	Table struct {
+		Table   string // the name of the table
+		Claimed string // the status of the claimed rows, ie: "running"
+		OrderBy string // the order of the claims, ie: "priority DESC, run_at, id"
+	}
+)
+
+const (
+	Pending = "pending" // the status of the rows waiting to be claimed
+	Dead    = "dead"    // the status of the rows that failed too many times
+
+	// a row is claimable when it is pending, or when a worker claimed it and
+	// didn't complete before the end of its lease.
+	leaseClaim = `
+UPDATE %[1]s
+SET status = $1, attempts = attempts + 1, claim_token = $2, updated_at = $3
+WHERE id IN (
+	SELECT id FROM %[1]s
+	WHERE (status = 'pending' AND run_at <= $3) OR (status = $1 AND updated_at <= $4)
+	ORDER BY %[2]s
+	LIMIT $5 %[3]s
+)
+RETURNING *`
+
+	leaseUpdate = `
+UPDATE %s
+SET status = $1, last_error = $2, run_at = $3, updated_at = $4, claim_token = NULL
+WHERE id = $5 AND claim_token = $6`
+
+	leaseDelete = `DELETE FROM %s WHERE id = $1 AND claim_token = $2`
+
+	// a row claimed but not started
+	leaseRelease = `
+UPDATE %s
+SET status = 'pending', attempts = attempts - 1, updated_at = $1, claim_token = NULL
+WHERE id = $2 AND claim_token = $3`
+
+	leaseRequeue = `
+UPDATE %s
+SET status = 'pending', attempts = 0, run_at = $1, updated_at = $1
+WHERE id = $2 AND status = 'dead'`
+
+	leaseDeadRows = `
+SELECT * FROM %s
+WHERE status = 'dead'
+ORDER BY updated_at DESC, id DESC
+LIMIT $1`
+)
+
+var (
+	// ErrLost is returned when the result of a claimed row can't be recorded
+	// because its lease ended and another worker claimed it.
+	ErrLost = errors.New("the lease of the row was lost")
+)
+
+// Claim claims up to limit rows for lease into dest, it skips the rows locked
+// by the other workers on postgres.
+func (t Table) Claim(ctx context.Context, database db.DB, dest any, limit int, lease time.Duration) error {
+	lock := "FOR UPDATE SKIP LOCKED"
+	if db.DialectOf(database) == db.SQLite {
+		lock = ""
+	}
+
+	now := time.Now().UTC()
+	return database.Query(ctx).Select(dest,
+		fmt.Sprintf(leaseClaim, t.Table, t.OrderBy, lock),
+		t.Claimed,
+		utils.RandomHex(16),
+		now,
+		now.Add(-lease),
+		limit)
+}
+
+// Update records the status of a claimed row and releases its claim.
+func (t Table) Update(ctx context.Context, database db.DB, id int64, token *string, status string, lastError *string, runAt time.Time) error {
+	now := time.Now().UTC()
+	return lost(database.Query(ctx).ExecResult(fmt.Sprintf(leaseUpdate, t.Table),
+		status, lastError, runAt.UTC(), now, id, token))
+}
+
+// Retry records the failure of a claimed row: the row is pending again after an exponential
+// backoff from the number of attempts, or dead after maxAttempts. It returns the backoff delay
+// of a pending row.
+func (t Table) Retry(ctx context.Context, database db.DB, id int64, token *string, cause error, attempts, maxAttempts int, minBackoff, maxBackoff time.Duration) (time.Duration, error) {
+	lastError := cause.Error()
+	if attempts >= maxAttempts {
+		return 0, t.Update(ctx, database, id, token, Dead, &lastError, time.Now())
+	}
+	delay := utils.Backoff(attempts, minBackoff, maxBackoff)
+	return delay, t.Update(ctx, database, id, token, Pending, &lastError, time.Now().Add(delay))
+}
+
+// Delete deletes a claimed row.
+func (t Table) Delete(ctx context.Context, database db.DB, id int64, token *string) error {
+	return lost(database.Query(ctx).ExecResult(fmt.Sprintf(leaseDelete, t.Table), id, token))
+}
+
+// Release makes a claimed row pending again without counting the attempt, at its place in the queue.
+func (t Table) Release(ctx context.Context, database db.DB, id int64, token *string) error {
+	return lost(database.Query(ctx).ExecResult(fmt.Sprintf(leaseRelease, t.Table), time.Now().UTC(), id, token))
+}
+
+// Requeue makes a dead row pending again, with no attempt.
+// db.ErrNotAffected is returned when there is no dead row with this id.
+func (t Table) Requeue(ctx context.Context, database db.DB, id int64) error {
+	return db.Affected(database.Query(ctx).ExecResult(fmt.Sprintf(leaseRequeue, t.Table), time.Now().UTC(), id))
+}
+
+// Dead scans the most recent dead rows into dest, read on the primary so they can be requeued.
+func (t Table) Dead(ctx context.Context, database db.DB, dest any, limit int) error {
+	return database.Query(db.WithPrimary(ctx)).Select(dest, fmt.Sprintf(leaseDeadRows, t.Table), limit)
+}
+
+// lost returns ErrLost when the row didn't hold the token anymore.
+func lost(res db.Result, err error) error {
+	err = db.Affected(res, err)
+	if errors.Is(err, db.ErrNotAffected) {
+		return ErrLost
+	}
+	return err
+}
@@ -0,0 +1,74 @@
+package testutil
+
+import (
+	"context"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/fdelbos/commons/db"
+	"github.com/fdelbos/commons/db/sqlite"
+)
+
+type (
+	// Replica is a sqlite database with a read replica, routed like a postgres pool with
+	// replicas: the SELECT of Select, Get and Each go to the replica unless db.WithPrimary.
+	// The replica is an empty database, so a read of the replica fails instead of
+	// returning stale rows.
+	Replica struct {
+		*sqlite.SqlConn
+		replica *sqlite.SqlConn
+		reads   atomic.Int64
+	}
+
+	replicaQuery struct {
+		db.Query
+		replica db.Query
+		reads   *atomic.Int64
+	}
+)
+
+// NewReplica returns a database with a replica, see SQLite.
+func NewReplica(t *testing.T) *Replica {
+	return &Replica{
+		SqlConn: SQLite(t),
+		replica: SQLite(t),
+	}
+}
+
+func (r *Replica) Query(ctx context.Context) db.Query {
+	primary := r.SqlConn.Query(ctx)
+	if db.UsePrimary(ctx) {
+		return primary
+	}
+	return &replicaQuery{
+		Query:   primary,
+		replica: r.replica.Query(ctx),
+		reads:   &r.reads,
+	}
+}
+
+// Reads returns the number of reads sent to the replica.
+func (r *Replica) Reads() int64 {
+	return r.reads.Load()
+}
+
+func (q *replicaQuery) Select(dest interface{}, sql string, args ...interface{}) error {
+	return q.route(sql).Select(dest, sql, args...)
+}
+
+func (q *replicaQuery) Get(dest interface{}, sql string, args ...interface{}) error {
+	return q.route(sql).Get(dest, sql, args...)
+}
+
+func (q *replicaQuery) Each(dest interface{}, sql string, args []interface{}, fn func() error) error {
+	return q.route(sql).Each(dest, sql, args, fn)
+}
+
+func (q *replicaQuery) route(sql string) db.Query {
+	if !strings.HasPrefix(strings.ToUpper(strings.TrimSpace(sql)), "SELECT") {
+		return q.Query
+	}
+	q.reads.Add(1)
+	return q.replica
+}
@@ -0,0 +1,24 @@
+// Package testutil holds the fixtures shared by the tests of the packages.
+package testutil
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/fdelbos/commons/db/sqlite"
+	"github.com/stretchr/testify/assert"
+)
+
+// SQLite returns a connection to a new sqlite database in a temporary directory,
+// both are removed at the end of the test.
+func SQLite(t *testing.T) *sqlite.SqlConn {
+	dname, err := os.MkdirTemp("", "")
+	assert.NoError(t, err)
+	t.Cleanup(func() { os.RemoveAll(dname) })
+
+	conn, err := sqlite.NewConn(filepath.Join(dname, "db.sqlite3"))
+	assert.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
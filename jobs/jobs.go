@@ -0,0 +1,460 @@
+// Package jobs is a durable background job queue stored in the database.
+package jobs
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/fdelbos/commons/db"
+	"github.com/fdelbos/commons/internal/lease"
+)
+
+type (
+	// Status is the status of a job.
+	Status string
+
+	// Job is a row of the jobs table.
+	Job struct {
+		ID          int64           `db:"id"`
+		Kind        string          `db:"kind"`
+		Key         *string         `db:"unique_key"`
+		Payload     json.RawMessage `db:"payload"`
+		Priority    int             `db:"priority"`
+		Status      Status          `db:"status"`
+		Attempts    int             `db:"attempts"`
+		MaxAttempts int             `db:"max_attempts"`
+		LastError   *string         `db:"last_error"`
+		ClaimToken  *string         `db:"claim_token"` // set while claimed, see lease.Table
+		RunAt       time.Time       `db:"run_at"`
+		CreatedAt   time.Time       `db:"created_at"`
+		UpdatedAt   time.Time       `db:"updated_at"`
+	}
+
+	// HandlerFunc runs a job, an error schedules a retry.
+	HandlerFunc func(ctx context.Context, job *Job) error
+
+	// Queue runs the jobs stored in the database with the handler of their kind.
+	// The jobs run by priority then by date, a failed job is retried with an
+	// exponential backoff and a successful job is deleted.
+	//
+	// The jobs table must exist, see PgSchema, SQLiteSchema and Migrate.
+	Queue struct {
+		db              db.DB
+		dialect         db.Dialect
+		handlers        map[string]HandlerFunc
+		workers         int
+		batchSize       int
+		poll            time.Duration
+		lease           time.Duration
+		maxAttempts     int
+		minBackoff      time.Duration
+		maxBackoff      time.Duration
+		shutdownTimeout time.Duration
+	}
+)
+
+const (
+	StatusPending Status = "pending" // waiting to run
+	StatusRunning Status = "running" // claimed by a worker
+	StatusDead    Status = "dead"    // failed too many times, see Requeue
+
+	DefaultWorkers         = 1
+	DefaultBatchSize       = 1
+	DefaultPoll            = time.Second
+	DefaultLease           = 5 * time.Minute
+	DefaultMaxAttempts     = 10
+	DefaultMinBackoff      = 10 * time.Second
+	DefaultMaxBackoff      = time.Hour
+	DefaultShutdownTimeout = 30 * time.Second
+
+	PgSchema = `
+CREATE TABLE IF NOT EXISTS jobs (
+	id BIGSERIAL PRIMARY KEY,
+	kind TEXT NOT NULL,
+	unique_key TEXT,
+	payload JSONB NOT NULL,
+	priority INTEGER NOT NULL DEFAULT 0,
+	status TEXT NOT NULL DEFAULT 'pending',
+	attempts INTEGER NOT NULL DEFAULT 0,
+	max_attempts INTEGER NOT NULL,
+	last_error TEXT,
+	claim_token TEXT,
+	run_at TIMESTAMPTZ NOT NULL,
+	created_at TIMESTAMPTZ NOT NULL,
+	updated_at TIMESTAMPTZ NOT NULL
+);
+CREATE INDEX IF NOT EXISTS jobs_run_at_idx ON jobs (priority DESC, run_at) WHERE status IN ('pending', 'running');
+CREATE UNIQUE INDEX IF NOT EXISTS jobs_unique_key_idx ON jobs (unique_key) WHERE status IN ('pending', 'running');`
+
+	SQLiteSchema = `
+CREATE TABLE IF NOT EXISTS jobs (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	kind TEXT NOT NULL,
+	unique_key TEXT,
+	payload BLOB NOT NULL,
+	priority INTEGER NOT NULL DEFAULT 0,
+	status TEXT NOT NULL DEFAULT 'pending',
+	attempts INTEGER NOT NULL DEFAULT 0,
+	max_attempts INTEGER NOT NULL,
+	last_error TEXT,
+	claim_token TEXT,
+	run_at DATETIME NOT NULL,
+	created_at DATETIME NOT NULL,
+	updated_at DATETIME NOT NULL
+);
+CREATE INDEX IF NOT EXISTS jobs_run_at_idx ON jobs (priority DESC, run_at) WHERE status IN ('pending', 'running');
+CREATE UNIQUE INDEX IF NOT EXISTS jobs_unique_key_idx ON jobs (unique_key) WHERE status IN ('pending', 'running');`
+
+	jobInsert = `
+INSERT INTO jobs (kind, unique_key, payload, priority, max_attempts, run_at, created_at, updated_at)
+VALUES ($1, $2, $3, $4, $5, $6, $7, $7)
+ON CONFLICT (unique_key) WHERE status IN ('pending', 'running') DO NOTHING
+RETURNING id`
+)
+
+var (
+	jobsTable = lease.Table{
+		Table:   "jobs",
+		Claimed: string(StatusRunning),
+		OrderBy: "priority DESC, run_at, id",
+	}
+
+	// ErrDuplicate is returned by Enqueue when a pending or running job has the same key.
+	ErrDuplicate = errors.New("a job with the same key is already queued")
+	// ErrNoHandler is the error of the jobs without a handler for their kind.
+	ErrNoHandler = errors.New("no handler for the job kind")
+)
+
+// New creates a queue storing the jobs in database.
+func New(database db.DB, opts ...func(*Queue)) *Queue {
+	q := &Queue{
+		db:              database,
+		dialect:         db.DialectOf(database),
+		handlers:        map[string]HandlerFunc{},
+		workers:         DefaultWorkers,
+		batchSize:       DefaultBatchSize,
+		poll:            DefaultPoll,
+		lease:           DefaultLease,
+		maxAttempts:     DefaultMaxAttempts,
+		minBackoff:      DefaultMinBackoff,
+		maxBackoff:      DefaultMaxBackoff,
+		shutdownTimeout: DefaultShutdownTimeout,
+	}
+	for _, opt := range opts {
+		opt(q)
+	}
+	return q
+}
+
+// Handle registers the handler of the jobs of a kind, with the payload decoded into T.
+// The handlers must be registered before Run.
+func Handle[T any](q *Queue, kind string, fn func(ctx context.Context, payload T) error) {
+	q.HandleJob(kind, func(ctx context.Context, job *Job) error {
+		var payload T
+		if err := json.Unmarshal(job.Payload, &payload); err != nil {
+			return err
+		}
+		return fn(ctx, payload)
+	})
+}
+
+// HandleJob registers the handler of the jobs of a kind.
+// The handlers must be registered before Run.
+func (q *Queue) HandleJob(kind string, fn HandlerFunc) {
+	q.handlers[kind] = fn
+}
+
+// Migrate creates the jobs table if it doesn't exist.
+func (q *Queue) Migrate(ctx context.Context) error {
+	schema := PgSchema
+	if q.dialect == db.SQLite {
+		schema = SQLiteSchema
+	}
+	return q.db.Query(ctx).Exec(schema)
+}
+
+// Enqueue stores a job with the JSON encoded payload and returns its id.
+// When ctx carries a transaction the job is only queued if the transaction commits.
+func (q *Queue) Enqueue(ctx context.Context, kind string, payload any, opts ...func(*Job)) (int64, error) {
+	raw, err := json.Marshal(payload)
+	if err != nil {
+		return 0, err
+	}
+
+	now := time.Now().UTC()
+	job := &Job{
+		Kind:        kind,
+		Payload:     raw,
+		MaxAttempts: q.maxAttempts,
+		RunAt:       now,
+	}
+	for _, opt := range opts {
+		opt(job)
+	}
+
+	var id int64
+	err = q.db.Query(ctx).Get(&id, jobInsert,
+		job.Kind,
+		job.Key,
+		[]byte(job.Payload),
+		job.Priority,
+		job.MaxAttempts,
+		job.RunAt.UTC(),
+		now)
+	if errors.Is(err, db.ErrNoRows) {
+		return 0, ErrDuplicate
+	}
+	return id, err
+}
+
+// Run starts the workers and blocks until ctx is canceled. The running jobs can
+// complete during the shutdown timeout, then their context is canceled.
+func (q *Queue) Run(ctx context.Context) {
+	jobsCtx, cancelJobs := context.WithCancel(context.Background())
+	defer cancelJobs()
+	go func() {
+		select {
+		case <-jobsCtx.Done():
+			return
+		case <-ctx.Done():
+		}
+		timer := time.NewTimer(q.shutdownTimeout)
+		defer timer.Stop()
+		select {
+		case <-jobsCtx.Done():
+		case <-timer.C:
+			log.Printf("jobs shutdown timeout, canceling the running jobs")
+			cancelJobs()
+		}
+	}()
+
+	wg := sync.WaitGroup{}
+	for i := 0; i < q.workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			q.work(ctx, jobsCtx)
+		}()
+	}
+	wg.Wait()
+}
+
+func (q *Queue) work(ctx, jobsCtx context.Context) {
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-timer.C:
+		}
+
+		n, err := q.process(ctx, jobsCtx)
+		if err != nil && ctx.Err() == nil {
+			log.Printf("jobs error while processing the queue: %v", err)
+		}
+		if n > 0 && err == nil {
+			// there may be more jobs waiting
+			timer.Reset(0)
+		} else {
+			timer.Reset(q.poll)
+		}
+	}
+}
+
+// Process claims a batch of jobs, runs them and returns the number of jobs processed.
+func (q *Queue) Process(ctx context.Context) (int, error) {
+	return q.process(ctx, ctx)
+}
+
+// process claims the jobs with ctx and runs them with jobsCtx.
+// The jobs not started when ctx is canceled are released.
+func (q *Queue) process(ctx, jobsCtx context.Context) (int, error) {
+	batch, err := q.claim(ctx)
+	if err != nil || len(batch) == 0 {
+		return 0, err
+	}
+
+	for i := range batch {
+		if ctx.Err() != nil {
+			return i, q.release(batch[i:])
+		}
+		// record the result even if the job context is canceled
+		if err := q.run(jobsCtx, context.Background(), &batch[i]); err != nil {
+			return i, err
+		}
+	}
+	return len(batch), nil
+}
+
+func (q *Queue) claim(ctx context.Context) ([]Job, error) {
+	batch := []Job{}
+	err := jobsTable.Claim(ctx, q.db, &batch, q.batchSize, q.lease)
+
+	// RETURNING doesn't keep the order of the subquery, sort as jobsTable.OrderBy
+	sort.Slice(batch, func(i, j int) bool {
+		if batch[i].Priority != batch[j].Priority {
+			return batch[i].Priority > batch[j].Priority
+		}
+		if !batch[i].RunAt.Equal(batch[j].RunAt) {
+			return batch[i].RunAt.Before(batch[j].RunAt)
+		}
+		return batch[i].ID < batch[j].ID
+	})
+	return batch, err
+}
+
+func (q *Queue) release(jobs []Job) error {
+	for _, job := range jobs {
+		err := jobsTable.Release(context.Background(), q.db, job.ID, job.ClaimToken)
+		if err != nil && !errors.Is(err, lease.ErrLost) {
+			return err
+		}
+	}
+	return nil
+}
+
+// run runs a claimed job with jobCtx and records the result with ctx.
+func (q *Queue) run(jobCtx, ctx context.Context, job *Job) error {
+	err := ErrNoHandler
+	if handler, ok := q.handlers[job.Kind]; ok {
+		err = safeRun(jobCtx, handler, job)
+	}
+
+	if err == nil {
+		return lostLease(job, jobsTable.Delete(ctx, q.db, job.ID, job.ClaimToken))
+	}
+
+	delay, recorded := jobsTable.Retry(ctx, q.db, job.ID, job.ClaimToken, err,
+		job.Attempts, job.MaxAttempts, q.minBackoff, q.maxBackoff)
+	if recorded != nil {
+		return lostLease(job, recorded)
+	}
+	if job.Attempts >= job.MaxAttempts {
+		log.Printf("jobs %s job %d is dead after %d attempts: %v", job.Kind, job.ID, job.Attempts, err)
+	} else {
+		log.Printf("jobs %s job %d failed, retrying in %s: %v", job.Kind, job.ID, delay, err)
+	}
+	return nil
+}
+
+// lostLease discards the result of a job whose lease was lost, it runs again.
+func lostLease(job *Job, err error) error {
+	if errors.Is(err, lease.ErrLost) {
+		log.Printf("jobs %s job %d lost its lease, the result is discarded", job.Kind, job.ID)
+		return nil
+	}
+	return err
+}
+
+// safeRun runs the handler, a panic is returned as an error.
+func safeRun(ctx context.Context, handler HandlerFunc, job *Job) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("job panicked: %v", r)
+		}
+	}()
+	return handler(ctx, job)
+}
+
+// Dead returns the most recent dead jobs.
+func (q *Queue) Dead(ctx context.Context, limit int) ([]Job, error) {
+	res := []Job{}
+	err := jobsTable.Dead(ctx, q.db, &res, limit)
+	return res, err
+}
+
+// Requeue runs a dead job again.
+// db.ErrNotAffected is returned when there is no dead job with this id.
+func (q *Queue) Requeue(ctx context.Context, id int64) error {
+	return jobsTable.Requeue(ctx, q.db, id)
+}
+
+// WithWorkers sets the number of concurrent workers. Default is 1.
+func WithWorkers(workers int) func(*Queue) {
+	return func(q *Queue) {
+		q.workers = workers
+	}
+}
+
+// WithBatchSize sets the number of jobs claimed at once by a worker. Default is 1.
+func WithBatchSize(size int) func(*Queue) {
+	return func(q *Queue) {
+		q.batchSize = size
+	}
+}
+
+// WithPoll sets the delay between two polls of an empty queue. Default is 1 second.
+func WithPoll(poll time.Duration) func(*Queue) {
+	return func(q *Queue) {
+		q.poll = poll
+	}
+}
+
+// WithLease sets how long a claimed job is reserved to a worker, after that it's
+// considered lost and runs again. It must be longer than the longest job. Default is 5 minutes.
+func WithLease(lease time.Duration) func(*Queue) {
+	return func(q *Queue) {
+		q.lease = lease
+	}
+}
+
+// WithRetries sets the default maximum number of attempts of a job and the backoff between attempts.
+// Defaults are 10 attempts, with a backoff from 10 seconds to 1 hour.
+func WithRetries(maxAttempts int, minBackoff, maxBackoff time.Duration) func(*Queue) {
+	return func(q *Queue) {
+		q.maxAttempts = maxAttempts
+		q.minBackoff = minBackoff
+		q.maxBackoff = maxBackoff
+	}
+}
+
+// WithShutdownTimeout sets how long the running jobs can complete once Run is canceled. Default is 30 seconds.
+func WithShutdownTimeout(timeout time.Duration) func(*Queue) {
+	return func(q *Queue) {
+		q.shutdownTimeout = timeout
+	}
+}
+
+// WithJobRunAt schedules the job at a date.
+func WithJobRunAt(at time.Time) func(*Job) {
+	return func(j *Job) {
+		j.RunAt = at
+	}
+}
+
+// WithJobDelay schedules the job after a delay.
+func WithJobDelay(delay time.Duration) func(*Job) {
+	return func(j *Job) {
+		j.RunAt = time.Now().Add(delay)
+	}
+}
+
+// WithJobPriority sets the priority of the job, the highest priorities run first. Default is 0.
+func WithJobPriority(priority int) func(*Job) {
+	return func(j *Job) {
+		j.Priority = priority
+	}
+}
+
+// WithJobKey sets a unique key: the job is not queued while another job with the key
+// is pending or running, see ErrDuplicate.
+func WithJobKey(key string) func(*Job) {
+	return func(j *Job) {
+		j.Key = &key
+	}
+}
+
+// WithJobMaxAttempts overrides the maximum number of attempts of the job.
+func WithJobMaxAttempts(maxAttempts int) func(*Job) {
+	return func(j *Job) {
+		j.MaxAttempts = maxAttempts
+	}
+}
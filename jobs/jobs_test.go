@@ -0,0 +1,308 @@
+package jobs_test
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/fdelbos/commons/db"
+	"github.com/fdelbos/commons/internal/testutil"
+	. "github.com/fdelbos/commons/jobs"
+	"github.com/stretchr/testify/assert"
+)
+
+type greeting struct {
+	Name string `json:"name"`
+}
+
+func TestJobs(t *testing.T) {
+	conn := testutil.SQLite(t)
+	queue := New(conn, WithRetries(3, 0, 0), WithBatchSize(10))
+	ctx := context.Background()
+	assert.NoError(t, queue.Migrate(ctx))
+
+	greeted := []string{}
+	failures := map[string]int{"flaky": 1, "dead": 10}
+	Handle(queue, "greet", func(ctx context.Context, payload greeting) error {
+		if failures[payload.Name] > 0 {
+			failures[payload.Name]--
+			return errors.New("failed")
+		}
+		if payload.Name == "panic" {
+			panic("boom")
+		}
+		greeted = append(greeted, payload.Name)
+		return nil
+	})
+
+	for _, name := range []string{"low", "flaky", "dead"} {
+		_, err := queue.Enqueue(ctx, "greet", greeting{Name: name})
+		assert.NoError(t, err)
+	}
+	_, err := queue.Enqueue(ctx, "greet", greeting{Name: "high"}, WithJobPriority(10))
+	assert.NoError(t, err)
+	_, err = queue.Enqueue(ctx, "greet", greeting{Name: "later"}, WithJobDelay(time.Hour))
+	assert.NoError(t, err)
+
+	n, err := queue.Process(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, 4, n)
+	assert.Equal(t, []string{"high", "low"}, greeted)
+
+	for i := 0; i < 3; i++ {
+		_, err = queue.Process(ctx)
+		assert.NoError(t, err)
+	}
+	assert.Equal(t, []string{"high", "low", "flaky"}, greeted)
+
+	dead, err := queue.Dead(ctx, 10)
+	assert.NoError(t, err)
+	assert.Len(t, dead, 1)
+	assert.Equal(t, `{"name":"dead"}`, string(dead[0].Payload))
+	assert.Equal(t, 3, dead[0].Attempts)
+	assert.Equal(t, "failed", *dead[0].LastError)
+
+	assert.NoError(t, queue.Requeue(ctx, dead[0].ID))
+	assert.ErrorIs(t, queue.Requeue(ctx, dead[0].ID), db.ErrNotAffected)
+	failures["dead"] = 0
+	n, err = queue.Process(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, n)
+	assert.Equal(t, []string{"high", "low", "flaky", "dead"}, greeted)
+
+	// the delayed job is still waiting
+	n, err = queue.Process(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, n)
+}
+
+func TestJobsOrder(t *testing.T) {
+	conn := testutil.SQLite(t)
+	queue := New(conn, WithBatchSize(10))
+	ctx := context.Background()
+	assert.NoError(t, queue.Migrate(ctx))
+
+	greeted := []string{}
+	Handle(queue, "greet", func(ctx context.Context, payload greeting) error {
+		greeted = append(greeted, payload.Name)
+		return nil
+	})
+
+	// by priority, then by run_at even when the ids are in another order
+	now := time.Now()
+	_, err := queue.Enqueue(ctx, "greet", greeting{Name: "third"}, WithJobRunAt(now.Add(-time.Minute)))
+	assert.NoError(t, err)
+	_, err = queue.Enqueue(ctx, "greet", greeting{Name: "second"}, WithJobRunAt(now.Add(-2*time.Minute)))
+	assert.NoError(t, err)
+	_, err = queue.Enqueue(ctx, "greet", greeting{Name: "first"}, WithJobPriority(10))
+	assert.NoError(t, err)
+
+	n, err := queue.Process(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, n)
+	assert.Equal(t, []string{"first", "second", "third"}, greeted)
+}
+
+func TestJobsReplica(t *testing.T) {
+	conn := testutil.NewReplica(t)
+	queue := New(conn, WithRetries(1, 0, 0))
+	ctx := context.Background()
+	assert.NoError(t, queue.Migrate(ctx))
+
+	fail := true
+	Handle(queue, "greet", func(ctx context.Context, payload greeting) error {
+		if fail {
+			return errors.New("failed")
+		}
+		return nil
+	})
+
+	// the claims, the dead jobs and the requeues go to the primary
+	_, err := queue.Enqueue(ctx, "greet", greeting{Name: "bob"})
+	assert.NoError(t, err)
+	n, err := queue.Process(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, n)
+
+	dead, err := queue.Dead(ctx, 10)
+	assert.NoError(t, err)
+	assert.Len(t, dead, 1)
+	assert.NoError(t, queue.Requeue(ctx, dead[0].ID))
+	fail = false
+	n, err = queue.Process(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, n)
+	assert.Equal(t, int64(0), conn.Reads())
+}
+
+func TestJobsFailures(t *testing.T) {
+	conn := testutil.SQLite(t)
+	queue := New(conn, WithRetries(1, 0, 0))
+	ctx := context.Background()
+	assert.NoError(t, queue.Migrate(ctx))
+
+	queue.HandleJob("panic", func(ctx context.Context, job *Job) error {
+		panic("boom")
+	})
+	_, err := queue.Enqueue(ctx, "panic", nil)
+	assert.NoError(t, err)
+	_, err = queue.Enqueue(ctx, "unknown", nil, WithJobMaxAttempts(1))
+	assert.NoError(t, err)
+
+	for i := 0; i < 2; i++ {
+		n, err := queue.Process(ctx)
+		assert.NoError(t, err)
+		assert.Equal(t, 1, n)
+	}
+
+	dead, err := queue.Dead(ctx, 10)
+	assert.NoError(t, err)
+	assert.Len(t, dead, 2)
+	lastErrors := []string{*dead[0].LastError, *dead[1].LastError}
+	assert.Contains(t, lastErrors, "job panicked: boom")
+	assert.Contains(t, lastErrors, ErrNoHandler.Error())
+}
+
+func TestJobsLeaseLost(t *testing.T) {
+	conn := testutil.SQLite(t)
+	lease := 50 * time.Millisecond
+	queue := New(conn, WithLease(lease), WithRetries(1, 0, 0))
+	ctx := context.Background()
+	assert.NoError(t, queue.Migrate(ctx))
+
+	runs := 0
+	queue.HandleJob("slow", func(ctx context.Context, job *Job) error {
+		runs++
+		if runs == 1 {
+			// another worker claims the job once the lease is over and completes it
+			time.Sleep(2 * lease)
+			n, err := queue.Process(ctx)
+			assert.NoError(t, err)
+			assert.Equal(t, 1, n)
+			return errors.New("failed")
+		}
+		return nil
+	})
+	_, err := queue.Enqueue(ctx, "slow", nil)
+	assert.NoError(t, err)
+
+	// the failure of the first worker doesn't kill the completed job
+	n, err := queue.Process(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, n)
+	assert.Equal(t, 2, runs)
+
+	dead, err := queue.Dead(ctx, 10)
+	assert.NoError(t, err)
+	assert.Empty(t, dead)
+	count := 0
+	assert.NoError(t, conn.Query(ctx).Get(&count, "SELECT count(*) FROM jobs"))
+	assert.Equal(t, 0, count)
+}
+
+func TestJobsUniqueKey(t *testing.T) {
+	conn := testutil.SQLite(t)
+	queue := New(conn)
+	ctx := context.Background()
+	assert.NoError(t, queue.Migrate(ctx))
+
+	runs := 0
+	queue.HandleJob("sync", func(ctx context.Context, job *Job) error {
+		runs++
+		return nil
+	})
+
+	id, err := queue.Enqueue(ctx, "sync", nil, WithJobKey("user-1"))
+	assert.NoError(t, err)
+	assert.NotZero(t, id)
+	_, err = queue.Enqueue(ctx, "sync", nil, WithJobKey("user-1"))
+	assert.ErrorIs(t, err, ErrDuplicate)
+	_, err = queue.Enqueue(ctx, "sync", nil, WithJobKey("user-2"))
+	assert.NoError(t, err)
+
+	// the key can be used again once the job is done
+	n, err := queue.Process(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, n)
+	_, err = queue.Enqueue(ctx, "sync", nil, WithJobKey("user-1"))
+	assert.NoError(t, err)
+
+	// the job is only queued if the transaction commits
+	errRollback := errors.New("rollback")
+	err = conn.Tx(ctx, func(ctx context.Context) error {
+		_, err := queue.Enqueue(ctx, "sync", nil, WithJobKey("user-3"))
+		assert.NoError(t, err)
+		return errRollback
+	})
+	assert.ErrorIs(t, err, errRollback)
+
+	for {
+		n, err := queue.Process(ctx)
+		assert.NoError(t, err)
+		if n == 0 {
+			break
+		}
+	}
+	assert.Equal(t, 3, runs)
+}
+
+func TestJobsRun(t *testing.T) {
+	conn := testutil.SQLite(t)
+	queue := New(conn, WithWorkers(2), WithPoll(10*time.Millisecond), WithShutdownTimeout(50*time.Millisecond))
+	ctx, cancel := context.WithCancel(context.Background())
+	assert.NoError(t, queue.Migrate(ctx))
+
+	mut := sync.Mutex{}
+	done := []int{}
+	started := make(chan struct{})
+	Handle(queue, "count", func(ctx context.Context, i int) error {
+		mut.Lock()
+		defer mut.Unlock()
+		done = append(done, i)
+		return nil
+	})
+	queue.HandleJob("block", func(ctx context.Context, job *Job) error {
+		close(started)
+		<-ctx.Done()
+		return ctx.Err()
+	})
+
+	stopped := make(chan struct{})
+	go func() {
+		queue.Run(ctx)
+		close(stopped)
+	}()
+
+	for i := 0; i < 5; i++ {
+		_, err := queue.Enqueue(ctx, "count", i)
+		assert.NoError(t, err)
+	}
+	assert.Eventually(t, func() bool {
+		mut.Lock()
+		defer mut.Unlock()
+		return len(done) == 5
+	}, time.Second, 10*time.Millisecond)
+
+	// the blocked job is canceled after the shutdown timeout
+	_, err := queue.Enqueue(ctx, "block", nil)
+	assert.NoError(t, err)
+	<-started
+	cancel()
+	select {
+	case <-stopped:
+	case <-time.After(time.Second):
+		t.Fatal("Run didn't return after the shutdown timeout")
+	}
+
+	// the canceled job is retried later
+	job := struct {
+		Status    Status  `db:"status"`
+		LastError *string `db:"last_error"`
+	}{}
+	err = conn.Query(context.Background()).Get(&job, "SELECT status, last_error FROM jobs WHERE kind = 'block'")
+	assert.NoError(t, err)
+	assert.Equal(t, StatusPending, job.Status)
+	assert.Equal(t, context.Canceled.Error(), *job.LastError)
+}
@@ -0,0 +1,343 @@
+// Package outbox implements the transactional outbox pattern: the events are written
+// to an outbox table in the transaction changing the data, so they are published if and
+// only if the transaction commits, then a relay delivers them to a Publisher.
+package outbox
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/fdelbos/commons/db"
+	"github.com/fdelbos/commons/utils"
+)
+
+type (
+	// Status is the delivery status of an event.
+	Status string
+
+	// Event is a row of the outbox_events table.
+	Event struct {
+		ID        int64           `db:"id" json:"id"`
+		Topic     string          `db:"topic" json:"topic"`
+		Key       string          `db:"idempotency_key" json:"key"`
+		Payload   json.RawMessage `db:"payload" json:"payload"`
+		Status    Status          `db:"status" json:"-"`
+		Attempts  int             `db:"attempts" json:"-"`
+		LastError *string         `db:"last_error" json:"-"`
+		RunAt     time.Time       `db:"run_at" json:"-"`
+		CreatedAt time.Time       `db:"created_at" json:"created_at"`
+		UpdatedAt time.Time       `db:"updated_at" json:"-"`
+	}
+
+	// Outbox stores the events in the database and relays them to a publisher.
+	// The events are delivered at least once, and a failing event is retried with an
+	// exponential backoff while the next events wait until it's published or dead.
+	// The events are relayed by id, which is only a best-effort order: on postgres the ids
+	// are assigned before the transactions commit, so a concurrent transaction committing
+	// later can publish an event with a lower id after the next ones were relayed.
+	// The publishers should use the event key to ignore duplicates, and must not rely on
+	// a strict order.
+	//
+	// The outbox_events table must exist, see PgSchema, SQLiteSchema and Migrate.
+	Outbox struct {
+		db          db.DB
+		dialect     db.Dialect
+		publisher   Publisher
+		lockID      db.AdvisoryLockID
+		batchSize   int
+		poll        time.Duration
+		maxAttempts int
+		minBackoff  time.Duration
+		maxBackoff  time.Duration
+		retention   time.Duration
+	}
+)
+
+const (
+	StatusPending   Status = "pending"   // waiting to be published
+	StatusPublished Status = "published" // delivered to the publisher
+	StatusDead      Status = "dead"      // failed too many times, see Requeue
+
+	// DefaultLockID is the advisory lock ensuring a single relay runs at a time.
+	DefaultLockID db.AdvisoryLockID = 0x6f7574626f78
+
+	DefaultBatchSize   = 100
+	DefaultPoll        = time.Second
+	DefaultMaxAttempts = 10
+	DefaultMinBackoff  = time.Second
+	DefaultMaxBackoff  = 10 * time.Minute
+	DefaultRetention   = 7 * 24 * time.Hour
+
+	// delay between two cleanups of the published events
+	cleanupInterval = time.Hour
+
+	PgSchema = `
+CREATE TABLE IF NOT EXISTS outbox_events (
+	id BIGSERIAL PRIMARY KEY,
+	topic TEXT NOT NULL,
+	idempotency_key TEXT NOT NULL UNIQUE,
+	payload JSONB NOT NULL,
+	status TEXT NOT NULL DEFAULT 'pending',
+	attempts INTEGER NOT NULL DEFAULT 0,
+	last_error TEXT,
+	run_at TIMESTAMPTZ NOT NULL,
+	created_at TIMESTAMPTZ NOT NULL,
+	updated_at TIMESTAMPTZ NOT NULL
+);
+CREATE INDEX IF NOT EXISTS outbox_events_pending_idx ON outbox_events (id) WHERE status = 'pending';`
+
+	SQLiteSchema = `
+CREATE TABLE IF NOT EXISTS outbox_events (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	topic TEXT NOT NULL,
+	idempotency_key TEXT NOT NULL UNIQUE,
+	payload BLOB NOT NULL,
+	status TEXT NOT NULL DEFAULT 'pending',
+	attempts INTEGER NOT NULL DEFAULT 0,
+	last_error TEXT,
+	run_at DATETIME NOT NULL,
+	created_at DATETIME NOT NULL,
+	updated_at DATETIME NOT NULL
+);
+CREATE INDEX IF NOT EXISTS outbox_events_pending_idx ON outbox_events (id) WHERE status = 'pending';`
+
+	outboxInsert = `
+INSERT INTO outbox_events (topic, idempotency_key, payload, status, run_at, created_at, updated_at)
+VALUES ($1, $2, $3, 'pending', $4, $4, $4)
+ON CONFLICT (idempotency_key) DO NOTHING`
+
+	outboxPending = `
+SELECT * FROM outbox_events
+WHERE status = 'pending'
+ORDER BY id
+LIMIT $1`
+
+	outboxUpdate = `
+UPDATE outbox_events
+SET status = $1, attempts = $2, last_error = $3, run_at = $4, updated_at = $5
+WHERE id = $6`
+
+	outboxRequeue = `
+UPDATE outbox_events
+SET status = 'pending', attempts = 0, run_at = $1, updated_at = $1
+WHERE id = $2 AND status = 'dead'`
+
+	outboxDead = `
+SELECT * FROM outbox_events
+WHERE status = 'dead'
+ORDER BY updated_at DESC, id DESC
+LIMIT $1`
+
+	outboxCleanup = `
+DELETE FROM outbox_events
+WHERE status = 'published' AND updated_at < $1`
+)
+
+// New creates an outbox storing the events in database and relaying them to publisher.
+func New(database db.DB, publisher Publisher, opts ...func(*Outbox)) *Outbox {
+	o := &Outbox{
+		db:          database,
+		dialect:     db.DialectOf(database),
+		publisher:   publisher,
+		lockID:      DefaultLockID,
+		batchSize:   DefaultBatchSize,
+		poll:        DefaultPoll,
+		maxAttempts: DefaultMaxAttempts,
+		minBackoff:  DefaultMinBackoff,
+		maxBackoff:  DefaultMaxBackoff,
+		retention:   DefaultRetention,
+	}
+	for _, opt := range opts {
+		opt(o)
+	}
+	return o
+}
+
+// Migrate creates the outbox_events table if it doesn't exist.
+func (o *Outbox) Migrate(ctx context.Context) error {
+	schema := PgSchema
+	if o.dialect == db.SQLite {
+		schema = SQLiteSchema
+	}
+	return o.db.Query(ctx).Exec(schema)
+}
+
+// Publish writes an event with a random idempotency key, see PublishKey.
+func (o *Outbox) Publish(ctx context.Context, topic string, payload any) error {
+	return o.PublishKey(ctx, topic, utils.RandomHex(16), payload)
+}
+
+// PublishKey writes an event with the JSON encoded payload to the outbox.
+// ctx should carry the transaction changing the data, so the event is only published
+// if the transaction commits. An event with the key of a previous event is ignored.
+func (o *Outbox) PublishKey(ctx context.Context, topic, key string, payload any) error {
+	raw, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	return o.db.Query(ctx).Exec(outboxInsert, topic, key, raw, time.Now().UTC())
+}
+
+// Run relays the events until ctx is canceled, and deletes the old published events.
+// Many instances can run, only one relays at a time.
+func (o *Outbox) Run(ctx context.Context) {
+	wg := sync.WaitGroup{}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		utils.Cron(ctx, cleanupInterval, func() {
+			if _, err := o.Cleanup(ctx); err != nil {
+				log.Printf("outbox error while cleaning up the events: %v", err)
+			}
+		})
+	}()
+
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			wg.Wait()
+			return
+		case <-timer.C:
+		}
+
+		n, err := o.Process(ctx)
+		if err != nil {
+			log.Printf("outbox error while relaying the events: %v", err)
+		}
+		if n > 0 && err == nil {
+			// there may be more events waiting
+			timer.Reset(0)
+		} else {
+			timer.Reset(o.poll)
+		}
+	}
+}
+
+// Process relays a batch of events and returns the number of events processed.
+// Nothing is processed when another instance holds the relay lock.
+func (o *Outbox) Process(ctx context.Context) (int, error) {
+	n := 0
+	relay := func(ctx context.Context) error {
+		var err error
+		n, err = o.relay(ctx)
+		return err
+	}
+
+	// a session lock doesn't hold a transaction while publishing: each status update
+	// commits on its own, and the sqlite writers are not blocked
+	err := o.db.Lock(ctx, o.lockID, relay, db.WithSessionLock())
+	if errors.Is(err, db.ErrLockFailed) {
+		return 0, nil
+	}
+	return n, err
+}
+
+func (o *Outbox) relay(ctx context.Context) (int, error) {
+	// read on the primary, a replica can still list the events published by the last batch
+	batch := []Event{}
+	if err := o.db.Query(db.WithPrimary(ctx)).Select(&batch, outboxPending, o.batchSize); err != nil {
+		return 0, err
+	}
+
+	now := time.Now().UTC()
+	for i := range batch {
+		event := &batch[i]
+		if event.RunAt.After(now) {
+			// waiting for a retry, the next events wait to keep the relay order
+			return i, nil
+		}
+		published, err := o.deliver(ctx, event)
+		if err != nil {
+			return i, err
+		}
+		if !published {
+			return i + 1, nil
+		}
+	}
+	return len(batch), nil
+}
+
+// deliver publishes an event and records the result.
+// It returns false when the event must be retried.
+func (o *Outbox) deliver(ctx context.Context, event *Event) (bool, error) {
+	attempts := event.Attempts + 1
+	err := o.publisher.Publish(ctx, event)
+
+	now := time.Now().UTC()
+	if err == nil {
+		return true, o.db.Query(ctx).Exec(outboxUpdate, StatusPublished, attempts, nil, now, now, event.ID)
+	}
+
+	lastError := err.Error()
+	if attempts >= o.maxAttempts {
+		log.Printf("outbox event %d is dead after %d attempts: %v", event.ID, attempts, err)
+		return true, o.db.Query(ctx).Exec(outboxUpdate, StatusDead, attempts, lastError, now, now, event.ID)
+	}
+
+	delay := utils.Backoff(attempts, o.minBackoff, o.maxBackoff)
+	log.Printf("outbox event %d failed, retrying in %s: %v", event.ID, delay, err)
+	return false, o.db.Query(ctx).Exec(outboxUpdate, StatusPending, attempts, lastError, now.Add(delay), now, event.ID)
+}
+
+// Dead returns the most recent dead events, read on the primary so they can be requeued.
+func (o *Outbox) Dead(ctx context.Context, limit int) ([]Event, error) {
+	res := []Event{}
+	err := o.db.Query(db.WithPrimary(ctx)).Select(&res, outboxDead, limit)
+	return res, err
+}
+
+// Requeue publishes a dead event again.
+func (o *Outbox) Requeue(ctx context.Context, id int64) error {
+	return db.Affected(o.db.Query(ctx).ExecResult(outboxRequeue, time.Now().UTC(), id))
+}
+
+// Cleanup deletes the events published before the retention period, and returns their number.
+func (o *Outbox) Cleanup(ctx context.Context) (int64, error) {
+	res, err := o.db.Query(ctx).ExecResult(outboxCleanup, time.Now().UTC().Add(-o.retention))
+	return res.RowsAffected, err
+}
+
+// WithLockID sets the advisory lock of the relay, for outboxes in different
+// databases of the same server. Default is DefaultLockID.
+func WithLockID(lockID db.AdvisoryLockID) func(*Outbox) {
+	return func(o *Outbox) {
+		o.lockID = lockID
+	}
+}
+
+// WithBatchSize sets the number of events relayed at once. Default is 100.
+func WithBatchSize(size int) func(*Outbox) {
+	return func(o *Outbox) {
+		o.batchSize = size
+	}
+}
+
+// WithPoll sets the delay between two polls of an empty outbox. Default is 1 second.
+func WithPoll(poll time.Duration) func(*Outbox) {
+	return func(o *Outbox) {
+		o.poll = poll
+	}
+}
+
+// WithRetries sets the maximum number of attempts and the backoff between attempts.
+// Defaults are 10 attempts, with a backoff from 1 second to 10 minutes.
+func WithRetries(maxAttempts int, minBackoff, maxBackoff time.Duration) func(*Outbox) {
+	return func(o *Outbox) {
+		o.maxAttempts = maxAttempts
+		o.minBackoff = minBackoff
+		o.maxBackoff = maxBackoff
+	}
+}
+
+// WithRetention sets how long the published events are kept. Default is 7 days.
+func WithRetention(retention time.Duration) func(*Outbox) {
+	return func(o *Outbox) {
+		o.retention = retention
+	}
+}
@@ -0,0 +1,203 @@
+package outbox_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/fdelbos/commons/db"
+	"github.com/fdelbos/commons/internal/testutil"
+	. "github.com/fdelbos/commons/outbox"
+	"github.com/stretchr/testify/assert"
+)
+
+type recorder struct {
+	mut      sync.Mutex
+	failures map[string]int // key -> number of failures before success
+	events   []string
+}
+
+func (r *recorder) Publish(ctx context.Context, event *Event) error {
+	r.mut.Lock()
+	defer r.mut.Unlock()
+	if r.failures[event.Key] > 0 {
+		r.failures[event.Key]--
+		return errors.New("broker unavailable")
+	}
+	r.events = append(r.events, event.Key)
+	return nil
+}
+
+func (r *recorder) published() []string {
+	r.mut.Lock()
+	defer r.mut.Unlock()
+	return append([]string{}, r.events...)
+}
+
+func TestOutbox(t *testing.T) {
+	conn := testutil.SQLite(t)
+	publisher := &recorder{failures: map[string]int{"second": 1, "dead": 10}}
+	out := New(conn, publisher, WithRetries(3, 0, 0))
+	ctx := context.Background()
+	assert.NoError(t, out.Migrate(ctx))
+
+	// the events are only written when the transaction commits
+	errRollback := errors.New("rollback")
+	err := conn.Tx(ctx, func(ctx context.Context) error {
+		return errors.Join(out.PublishKey(ctx, "users", "rolled back", nil), errRollback)
+	})
+	assert.ErrorIs(t, err, errRollback)
+
+	err = conn.Tx(ctx, func(ctx context.Context) error {
+		for _, key := range []string{"first", "second", "third", "first"} {
+			if err := out.PublishKey(ctx, "users", key, map[string]string{"key": key}); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	assert.NoError(t, err)
+
+	// second fails, third waits to keep the order
+	n, err := out.Process(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, n)
+	assert.Equal(t, []string{"first"}, publisher.published())
+
+	n, err = out.Process(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, n)
+	assert.Equal(t, []string{"first", "second", "third"}, publisher.published())
+
+	n, err = out.Process(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, n)
+
+	// dead events don't block the next ones
+	assert.NoError(t, out.PublishKey(ctx, "users", "dead", nil))
+	assert.NoError(t, out.PublishKey(ctx, "users", "after", nil))
+	for i := 0; i < 3; i++ {
+		_, err = out.Process(ctx)
+		assert.NoError(t, err)
+	}
+	assert.Equal(t, []string{"first", "second", "third", "after"}, publisher.published())
+
+	dead, err := out.Dead(ctx, 10)
+	assert.NoError(t, err)
+	assert.Len(t, dead, 1)
+	assert.Equal(t, "dead", dead[0].Key)
+	assert.Equal(t, 3, dead[0].Attempts)
+	assert.Equal(t, "broker unavailable", *dead[0].LastError)
+
+	publisher.failures["dead"] = 0
+	assert.NoError(t, out.Requeue(ctx, dead[0].ID))
+	assert.ErrorIs(t, out.Requeue(ctx, dead[0].ID), db.ErrNotAffected)
+	n, err = out.Process(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, n)
+	assert.Equal(t, []string{"first", "second", "third", "after", "dead"}, publisher.published())
+
+	// the published events are kept during the retention period
+	deleted, err := out.Cleanup(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(0), deleted)
+	deleted, err = New(conn, publisher, WithRetention(0)).Cleanup(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(5), deleted)
+}
+
+func TestOutboxReplica(t *testing.T) {
+	conn := testutil.NewReplica(t)
+	publisher := &recorder{failures: map[string]int{"dead": 10}}
+	out := New(conn, publisher, WithRetries(1, 0, 0))
+	ctx := context.Background()
+	assert.NoError(t, out.Migrate(ctx))
+
+	// the relay reads the pending events on the primary
+	for _, key := range []string{"first", "dead"} {
+		assert.NoError(t, out.PublishKey(ctx, "users", key, nil))
+	}
+	n, err := out.Process(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, n)
+	assert.Equal(t, []string{"first"}, publisher.published())
+
+	dead, err := out.Dead(ctx, 10)
+	assert.NoError(t, err)
+	assert.Len(t, dead, 1)
+	publisher.failures["dead"] = 0
+	assert.NoError(t, out.Requeue(ctx, dead[0].ID))
+	n, err = out.Process(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, n)
+	assert.Equal(t, []string{"first", "dead"}, publisher.published())
+	assert.Equal(t, int64(0), conn.Reads())
+}
+
+func TestOutboxRun(t *testing.T) {
+	conn := testutil.SQLite(t)
+	publisher := &recorder{}
+	out := New(conn, publisher, WithPoll(10*time.Millisecond))
+	ctx, cancel := context.WithCancel(context.Background())
+	assert.NoError(t, out.Migrate(ctx))
+
+	done := make(chan struct{})
+	go func() {
+		out.Run(ctx)
+		close(done)
+	}()
+
+	for i := 0; i < 5; i++ {
+		assert.NoError(t, out.Publish(ctx, "users", i))
+	}
+	assert.Eventually(t, func() bool {
+		return len(publisher.published()) == 5
+	}, time.Second, 10*time.Millisecond)
+
+	cancel()
+	<-done
+}
+
+func TestPublishers(t *testing.T) {
+	event := &Event{ID: 1, Topic: "users", Key: "abc", Payload: json.RawMessage(`{"name":"bob"}`)}
+	ctx := context.Background()
+
+	received := map[string]any{}
+	status := http.StatusOK
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "abc", r.Header.Get("Idempotency-Key"))
+		assert.Equal(t, "Bearer secret", r.Header.Get("Authorization"))
+		raw, _ := io.ReadAll(r.Body)
+		assert.NoError(t, json.Unmarshal(raw, &received))
+		w.WriteHeader(status)
+	}))
+	defer srv.Close()
+
+	webhook := NewWebhook(srv.URL, WithWebhookHeader("Authorization", "Bearer secret"))
+	assert.NoError(t, webhook.Publish(ctx, event))
+	assert.Equal(t, "users", received["topic"])
+	assert.Equal(t, "abc", received["key"])
+	assert.Equal(t, map[string]any{"name": "bob"}, received["payload"])
+
+	status = http.StatusBadGateway
+	assert.ErrorContains(t, webhook.Publish(ctx, event), "status 502")
+
+	topics := []string{}
+	handlers := Handlers{
+		"users": func(ctx context.Context, event *Event) error {
+			topics = append(topics, event.Topic)
+			return nil
+		},
+	}
+	assert.NoError(t, handlers.Publish(ctx, event))
+	assert.NoError(t, handlers.Publish(ctx, &Event{Topic: "unknown"}))
+	assert.Equal(t, []string{"users"}, topics)
+
+	assert.NoError(t, LogPublisher{}.Publish(ctx, event))
+}
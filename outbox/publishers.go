@@ -0,0 +1,116 @@
+package outbox
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"time"
+)
+
+type (
+	// Publisher delivers the events relayed by an Outbox.
+	Publisher interface {
+		Publish(ctx context.Context, event *Event) error
+	}
+
+	// PublisherFunc is an in-process Publisher.
+	PublisherFunc func(ctx context.Context, event *Event) error
+
+	// Handlers is an in-process Publisher calling the handler of the event topic.
+	// The events without a handler are ignored.
+	Handlers map[string]PublisherFunc
+
+	// LogPublisher logs the events, ie: for development.
+	LogPublisher struct{}
+
+	// WebhookPublisher posts the events as JSON to a URL, with the event key in
+	// the Idempotency-Key header. Any non 2xx status is a failure.
+	WebhookPublisher struct {
+		client  *http.Client
+		url     string
+		headers map[string]string
+	}
+)
+
+const (
+	DefaultWebhookTimeout = 30 * time.Second
+
+	// maximum size of an error body kept in the errors
+	maxErrorBody = 1024
+)
+
+func (fn PublisherFunc) Publish(ctx context.Context, event *Event) error {
+	return fn(ctx, event)
+}
+
+func (h Handlers) Publish(ctx context.Context, event *Event) error {
+	if fn, ok := h[event.Topic]; ok {
+		return fn(ctx, event)
+	}
+	return nil
+}
+
+func (LogPublisher) Publish(ctx context.Context, event *Event) error {
+	log.Printf("outbox event %d %s (%s): %s", event.ID, event.Topic, event.Key, event.Payload)
+	return nil
+}
+
+// NewWebhook creates a publisher posting the events to url.
+func NewWebhook(url string, opts ...func(*WebhookPublisher)) *WebhookPublisher {
+	w := &WebhookPublisher{
+		client:  &http.Client{Timeout: DefaultWebhookTimeout},
+		url:     url,
+		headers: map[string]string{},
+	}
+	for _, opt := range opts {
+		opt(w)
+	}
+	return w
+}
+
+func (w *WebhookPublisher) Publish(ctx context.Context, event *Event) error {
+	raw, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.url, bytes.NewReader(raw))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Idempotency-Key", event.Key)
+	for k, v := range w.headers {
+		req.Header.Set(k, v)
+	}
+
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, maxErrorBody))
+		return fmt.Errorf("outbox webhook replied with status %d: %s", resp.StatusCode, body)
+	}
+	_, err = io.Copy(io.Discard, resp.Body)
+	return err
+}
+
+// WithWebhookHeader adds a header to the requests (ie: Authorization).
+func WithWebhookHeader(key, value string) func(*WebhookPublisher) {
+	return func(w *WebhookPublisher) {
+		w.headers[key] = value
+	}
+}
+
+// WithWebhookClient sets the HTTP client used to post the events.
+func WithWebhookClient(client *http.Client) func(*WebhookPublisher) {
+	return func(w *WebhookPublisher) {
+		w.client = client
+	}
+}
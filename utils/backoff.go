@@ -0,0 +1,31 @@
+package utils
+
+import (
+	"math/rand"
+	"time"
+)
+
+// Backoff returns the exponential delay before the given attempt (starting at 1):
+// min, 2*min, 4*min... capped at max.
+func Backoff(attempt int, min, max time.Duration) time.Duration {
+	if attempt < 1 {
+		attempt = 1
+	}
+	delay := min
+	for i := 1; i < attempt; i++ {
+		delay *= 2
+		if delay >= max || delay <= 0 {
+			return max
+		}
+	}
+	if delay > max {
+		return max
+	}
+	return delay
+}
+
+// Jitter returns d randomly increased or decreased by up to factor*d (ie: 0.1 for 10%).
+func Jitter(d time.Duration, factor float64) time.Duration {
+	delta := (rand.Float64()*2 - 1) * factor * float64(d)
+	return d + time.Duration(delta)
+}
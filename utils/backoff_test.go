@@ -0,0 +1,28 @@
+package utils
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBackoff(t *testing.T) {
+	min := time.Second
+	max := time.Minute
+
+	assert.Equal(t, time.Second, Backoff(0, min, max))
+	assert.Equal(t, time.Second, Backoff(1, min, max))
+	assert.Equal(t, 2*time.Second, Backoff(2, min, max))
+	assert.Equal(t, 32*time.Second, Backoff(6, min, max))
+	assert.Equal(t, time.Minute, Backoff(7, min, max))
+	assert.Equal(t, time.Minute, Backoff(1000, min, max))
+}
+
+func TestJitter(t *testing.T) {
+	for i := 0; i < 100; i++ {
+		d := Jitter(time.Second, 0.1)
+		assert.GreaterOrEqual(t, d, 900*time.Millisecond)
+		assert.LessOrEqual(t, d, 1100*time.Millisecond)
+	}
+}
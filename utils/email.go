@@ -0,0 +1,31 @@
+package utils
+
+import (
+	"errors"
+	"net/mail"
+	"strings"
+
+	"golang.org/x/net/idna"
+)
+
+var ErrInvalidEmail = errors.New("invalid email address")
+
+// NormalizeEmail returns the canonical form of an email address, used to compare
+// and store addresses: the address is trimmed and lowercased, and its domain is
+// converted to ASCII (ie: "Bob@Exämple.com " becomes "bob@xn--exmple-cua.com").
+// A display name is not allowed.
+func NormalizeEmail(email string) (string, error) {
+	email = strings.TrimSpace(email)
+	addr, err := mail.ParseAddress(email)
+	if err != nil || addr.Name != "" || addr.Address != strings.Trim(email, "<>") {
+		return "", ErrInvalidEmail
+	}
+
+	at := strings.LastIndexByte(addr.Address, '@')
+	local, domain := addr.Address[:at], addr.Address[at+1:]
+	domain, err = idna.Lookup.ToASCII(domain)
+	if err != nil || !strings.Contains(domain, ".") {
+		return "", ErrInvalidEmail
+	}
+	return strings.ToLower(local) + "@" + domain, nil
+}
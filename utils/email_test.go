@@ -0,0 +1,40 @@
+package utils
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNormalizeEmail(t *testing.T) {
+	valid := map[string]string{
+		"bob@example.com":            "bob@example.com",
+		"  Bob@Example.COM ":         "bob@example.com",
+		"bob+tag@example.com":        "bob+tag@example.com",
+		"bob@Exämple.com":            "bob@xn--exmple-cua.com",
+		"bob@xn--exmple-cua.com":     "bob@xn--exmple-cua.com",
+		"<bob@example.com>":          "bob@example.com",
+		"first.last@sub.example.org": "first.last@sub.example.org",
+	}
+	for email, expected := range valid {
+		normalized, err := NormalizeEmail(email)
+		assert.NoError(t, err, email)
+		assert.Equal(t, expected, normalized, email)
+	}
+
+	invalid := []string{
+		"",
+		"bob",
+		"bob@",
+		"@example.com",
+		"bob@localhost",
+		"Bob <bob@example.com>",
+		"bob@example.com, alice@example.com",
+		"bob@exa mple.com",
+		"bob@-example.com",
+	}
+	for _, email := range invalid {
+		_, err := NormalizeEmail(email)
+		assert.ErrorIs(t, err, ErrInvalidEmail, email)
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"strings"
 	"sync"
 
+	"github.com/fdelbos/commons/utils"
 	"github.com/go-playground/locales/en"
 	ut "github.com/go-playground/universal-translator"
 	"github.com/go-playground/validator/v10"
@@ -40,9 +41,29 @@ func Start() {
 
 			return name
 		})
+
+		registerEmailAddress(v, trans)
 	})
 }
 
+// registerEmailAddress registers the email_address tag, stricter than the email tag:
+// the address must be accepted by utils.NormalizeEmail, so it can be delivered and
+// stored in its normalized form.
+func registerEmailAddress(v *validator.Validate, trans ut.Translator) {
+	v.RegisterValidation("email_address", func(fl validator.FieldLevel) bool {
+		_, err := utils.NormalizeEmail(fl.Field().String())
+		return err == nil
+	})
+	v.RegisterTranslation("email_address", trans,
+		func(ut ut.Translator) error {
+			return ut.Add("email_address", "{0} must be a valid email address", true)
+		},
+		func(ut ut.Translator, fe validator.FieldError) string {
+			t, _ := ut.T("email_address", fe.Field())
+			return t
+		})
+}
+
 func Validator() *validator.Validate {
 	Start()
 
@@ -0,0 +1,23 @@
+package validation_test
+
+import (
+	"errors"
+	"testing"
+
+	. "github.com/fdelbos/commons/validation"
+	"github.com/go-playground/validator/v10"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEmailAddress(t *testing.T) {
+	type signup struct {
+		Email string `json:"email" validate:"required,email_address"`
+	}
+
+	assert.NoError(t, Validator().Struct(signup{Email: "Bob@Exämple.com"}))
+
+	err := Validator().Struct(signup{Email: "Bob <bob@example.com>"})
+	errs := validator.ValidationErrors{}
+	assert.True(t, errors.As(err, &errs))
+	assert.Equal(t, "email must be a valid email address", errs[0].Translate(Translator()))
+}
@@ -5,16 +5,15 @@ import (
 	"math"
 	"strconv"
 
+	"github.com/fdelbos/commons/db"
 	"github.com/fdelbos/commons/validation"
 	"github.com/go-playground/validator/v10"
 	"github.com/gofiber/fiber/v2"
 )
 
 type (
-	Pagination struct {
-		Limit  int64 `json:"limit"`
-		Offset int64 `json:"offset"`
-	}
+	// Pagination is the same type as db.Pagination, so it can be given to db.List.
+	Pagination = db.Pagination
 )
 
 const DefaultMaxOffset = int64(math.MaxInt16)
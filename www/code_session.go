@@ -70,8 +70,14 @@ func (css *CodeSession) Routes(r fiber.Router) {
 	r.Post("/answer", Parser[CodeSessionAnswer](css.Answer))
 }
 
+// Send sends a code to the requested email, in the locale of the Accept-Language header.
 func (css *CodeSession) Send(c *fiber.Ctx, req *CodeSessionRequest) error {
-	err := css.codes.Send(c.Context(), req.Email)
+	ctx := context.Context(c.Context())
+	if locales := Locales(c); len(locales) > 0 {
+		ctx = auth.WithLocales(ctx, locales...)
+	}
+
+	err := css.codes.Send(ctx, req.Email)
 	if err != nil {
 		return ErrInternal(c, err)
 	}
@@ -7,6 +7,7 @@ import (
 	"testing"
 	"time"
 
+	"github.com/fdelbos/commons/auth"
 	"github.com/fdelbos/commons/internal/mocks"
 	. "github.com/fdelbos/commons/www"
 	"github.com/gofiber/fiber/v2"
@@ -49,6 +50,24 @@ func TestCodeSession(t *testing.T) {
 		assert.Equal(t, fiber.StatusOK, resp.StatusCode)
 	})
 
+	t.Run("new codes with locale", func(t *testing.T) {
+		codesService.
+			On("Send", mock.MatchedBy(func(ctx context.Context) bool {
+				locales := auth.LocalesFromContext(ctx)
+				return assert.ObjectsAreEqual([]string{"fr-ca", "fr", "en"}, locales)
+			}), expectedEmail).
+			Return(nil).
+			Once()
+
+		body := bytes.NewBufferString(`{"email":"` + expectedEmail + `"}`)
+		req := httptest.NewRequest("POST", "/send", body)
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Accept-Language", "en;q=0.8,fr-CA,fr;q=0.9")
+		resp, err := app.Test(req)
+		assert.NoError(t, err)
+		assert.Equal(t, fiber.StatusOK, resp.StatusCode)
+	})
+
 	t.Run("validate codes", func(t *testing.T) {
 		codesService.
 			On("Validate", mock.Anything, expectedCode, expectedEmail).
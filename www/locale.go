@@ -0,0 +1,26 @@
+package www
+
+import (
+	"github.com/gofiber/fiber/v2"
+	"golang.org/x/text/language"
+)
+
+// Locales returns the locales accepted by the request from the Accept-Language header,
+// the most preferred first (ie: ["fr-CA", "fr", "en"] for "en;q=0.8,fr-CA,fr;q=0.9"),
+// or nil if there is none. The templates are matched against them with auth.WithLocales.
+func Locales(c *fiber.Ctx) []string {
+	header := c.Get(fiber.HeaderAcceptLanguage)
+	if header == "" {
+		return nil
+	}
+
+	tags, _, err := language.ParseAcceptLanguage(header)
+	if err != nil || len(tags) == 0 {
+		return nil
+	}
+	res := make([]string, len(tags))
+	for i, tag := range tags {
+		res[i] = tag.String()
+	}
+	return res
+}
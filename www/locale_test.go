@@ -0,0 +1,40 @@
+package www_test
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/fdelbos/commons/www"
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLocales(t *testing.T) {
+	app := fiber.New()
+	var locales []string
+	app.Get("/", func(c *fiber.Ctx) error {
+		locales = www.Locales(c)
+		return nil
+	})
+
+	tc := []struct {
+		header  string
+		locales []string
+	}{
+		{"", nil},
+		{"fr-CA", []string{"fr-CA"}},
+		{"fr-CA, en;q=0.8", []string{"fr-CA", "en"}},
+		{"en;q=0.5, de;q=0.7, fr-CA", []string{"fr-CA", "de", "en"}},
+		{"invalid;q=abc", nil},
+	}
+
+	for _, c := range tc {
+		t.Run(c.header, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/", nil)
+			req.Header.Set("Accept-Language", c.header)
+			_, err := app.Test(req)
+			assert.NoError(t, err)
+			assert.Equal(t, c.locales, locales)
+		})
+	}
+}